@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	adminTokenTTL   = 2 * time.Minute
+	keyedSampleSize = 10
+)
+
+var (
+	adminEnabled    bool   // 默认只读，ADMIN_ENABLED=true 或 -admin 时才允许管理操作
+	protectedTopics string // 逗号分隔，支持 * 等通配符
+)
+
+var errAdminDisabled = fmt.Errorf("admin actions are disabled, set %s=true or use -admin", envAdminEnabled)
+
+// isProtectedTopic 内部 topic（__ 开头）总是受保护的
+func isProtectedTopic(topic string) bool {
+	if strings.HasPrefix(topic, "__") {
+		return true
+	}
+	for _, pattern := range strings.Split(protectedTopics, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func checkProtected(topic string) error {
+	if isProtectedTopic(topic) {
+		return fmt.Errorf("topic %s is protected, remove it from %s first", topic, envProtectedTopics)
+	}
+	return nil
+}
+
+// TopicSpec 描述需要创建的 topic
+type TopicSpec struct {
+	Name              string            `json:"name" yaml:"name"`
+	Partitions        int32             `json:"partitions" yaml:"partitions"`
+	ReplicationFactor int16             `json:"replication_factor" yaml:"replication_factor"`
+	Configs           map[string]string `json:"configs,omitempty" yaml:"configs"`
+}
+
+func (spec *TopicSpec) Validate() error {
+	if spec.Name == "" {
+		return fmt.Errorf("missing topic name")
+	}
+	if spec.Partitions <= 0 {
+		return fmt.Errorf("partitions must be positive")
+	}
+	if spec.ReplicationFactor <= 0 {
+		return fmt.Errorf("replication factor must be positive")
+	}
+	return nil
+}
+
+func newClusterAdmin(client sarama.Client) (sarama.ClusterAdmin, error) {
+	return sarama.NewClusterAdmin([]string{brokerAddr}, client.Config())
+}
+
+func createTopic(client sarama.Client, spec TopicSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	admin, err := newClusterAdmin(client)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	detail := &sarama.TopicDetail{NumPartitions: spec.Partitions, ReplicationFactor: spec.ReplicationFactor}
+	if len(spec.Configs) > 0 {
+		detail.ConfigEntries = make(map[string]*string)
+		for k, v := range spec.Configs {
+			value := v
+			detail.ConfigEntries[k] = &value
+		}
+	}
+	return admin.CreateTopic(spec.Name, detail, false)
+}
+
+func addPartitions(client sarama.Client, topic string, count int32) error {
+	if err := checkProtected(topic); err != nil {
+		return err
+	}
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	if int(count) <= len(partitions) {
+		return fmt.Errorf("topic %s already has %d partitions, the count can only be increased", topic, len(partitions))
+	}
+
+	admin, err := newClusterAdmin(client)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	return admin.CreatePartitions(topic, count, nil, false)
+}
+
+func deleteTopic(client sarama.Client, topic string) error {
+	if err := checkProtected(topic); err != nil {
+		return err
+	}
+
+	admin, err := newClusterAdmin(client)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	return admin.DeleteTopic(topic)
+}
+
+// keyedTopic 读取每个分区最后几条消息，有带 key 的消息时认为 topic 按 key 分区
+func keyedTopic(client sarama.Client, topic string) (bool, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return false, err
+	}
+	for _, partition := range partitions {
+		records, err := fetchRecords(client, FetchRequest{Topic: topic, Partition: partition, Start: startTail, Count: keyedSampleSize, Decode: decodeHex})
+		if err != nil {
+			return false, err
+		}
+		for _, r := range records {
+			if r.KeySize > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// addPartitionsWarning 增加分区会改变 key 到分区的映射，按 key 保证的顺序会被打乱。
+// 无法确认 topic 是否有 key 时按有 key 处理，同样需要确认
+func addPartitionsWarning(client sarama.Client, topic string) string {
+	keyed, err := keyedTopic(client, topic)
+	if err != nil {
+		return fmt.Sprintf("can not check whether topic %s has keyed records (%v), adding partitions may break per-key ordering", topic, err)
+	}
+	if keyed {
+		return fmt.Sprintf("topic %s has keyed records, adding partitions changes which partition a key goes to and breaks per-key ordering", topic)
+	}
+	return ""
+}
+
+// confirmTokens 保存 HTTP 接口发放的确认令牌，令牌只能对发放时的操作使用一次
+type confirmTokens struct {
+	sync.Mutex
+	tokens map[string]confirmToken
+}
+
+type confirmToken struct {
+	action  string
+	expires time.Time
+}
+
+var adminTokens = &confirmTokens{tokens: make(map[string]confirmToken)}
+
+func (c *confirmTokens) Issue(action string) (string, time.Time) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(adminTokenTTL)
+
+	c.Lock()
+	defer c.Unlock()
+	for t, entry := range c.tokens {
+		if time.Now().After(entry.expires) {
+			delete(c.tokens, t)
+		}
+	}
+	c.tokens[token] = confirmToken{action: action, expires: expires}
+	return token, expires
+}
+
+func (c *confirmTokens) Consume(token, action string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.tokens[token]
+	if !ok || entry.action != action || time.Now().After(entry.expires) {
+		return false
+	}
+	delete(c.tokens, token)
+	return true
+}
+
+// ConfirmRequired 是破坏性操作没有带有效令牌时的响应，带上 confirm 参数重新请求即可执行
+type ConfirmRequired struct {
+	Action  string    `json:"action"`
+	Warning string    `json:"warning,omitempty"`
+	Confirm string    `json:"confirm"`
+	Expires time.Time `json:"expires"`
+}
+
+// confirmed 检查请求中的令牌，没有有效令牌时返回 409 和新的令牌
+func confirmed(w http.ResponseWriter, r *http.Request, action, warning string) bool {
+	if token := r.URL.Query().Get("confirm"); token != "" && adminTokens.Consume(token, action) {
+		return true
+	}
+
+	token, expires := adminTokens.Issue(action)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(ConfirmRequired{Action: action, Warning: warning, Confirm: token, Expires: expires})
+	return false
+}
+
+// handleAdminTopics 处理 /api/admin/topics：
+// POST /api/admin/topics 创建 topic，POST /api/admin/topics/{name}/partitions?count= 增加分区，DELETE /api/admin/topics/{name} 删除 topic
+func handleAdminTopics(client sarama.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled {
+			http.Error(w, errAdminDisabled.Error(), http.StatusForbidden)
+			return
+		}
+
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/topics"), "/"), "/")
+		var err error
+		switch {
+		case r.Method == http.MethodPost && parts[0] == "":
+			var spec TopicSpec
+			b, readErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+			if readErr == nil {
+				readErr = json.Unmarshal(b, &spec)
+			}
+			if readErr != nil {
+				http.Error(w, fmt.Sprintf("invalid topic: %v", readErr), http.StatusBadRequest)
+				return
+			}
+			err = createTopic(client, spec)
+
+		case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "partitions":
+			count, convErr := strconv.ParseInt(r.URL.Query().Get("count"), 10, 32)
+			if convErr != nil {
+				http.Error(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+			if err := checkProtected(parts[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			// 只有按 key 分区的 topic 需要确认
+			if warning := addPartitionsWarning(client, parts[0]); warning != "" {
+				if !confirmed(w, r, fmt.Sprintf("add-partitions %s %d", parts[0], count), warning) {
+					return
+				}
+			}
+			err = addPartitions(client, parts[0], int32(count))
+
+		case r.Method == http.MethodDelete && len(parts) == 1 && parts[0] != "":
+			if err := checkProtected(parts[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if !confirmed(w, r, "delete-topic "+parts[0], "") {
+				return
+			}
+			err = deleteTopic(client, parts[0])
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			logrus.Warnf("admin %s %s error: %v", r.Method, r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	}
+}
+
+// adminFlag 添加 -admin 参数，默认使用 ADMIN_ENABLED 环境变量
+func adminFlag(fs *cliFlags) {
+	fs.BoolVar(&adminEnabled, "admin", adminEnabled, "allow admin actions")
+}
+
+// confirmTopicName 破坏性操作需要输入 topic 名称确认，也可以通过 -confirm 参数指定
+func confirmTopicName(action, topic, given string) bool {
+	if given != "" {
+		return given == topic
+	}
+	fmt.Fprintf(os.Stderr, "%s, type the topic name to confirm: ", action)
+	answer, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(answer) == topic
+}
+
+// configFlags 收集可以重复指定的 -config key=value 参数
+type configFlags map[string]string
+
+func (c configFlags) String() string {
+	return fmt.Sprint(map[string]string(c))
+}
+
+func (c configFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("config must be key=value")
+	}
+	c[kv[0]] = kv[1]
+	return nil
+}
+
+func runCreateTopic(args []string) {
+	fs := newFlagSet("create-topic")
+	adminFlag(fs)
+	partitions := fs.Int("partitions", 1, "number of partitions")
+	replication := fs.Int("replication", 1, "replication factor")
+	configs := make(configFlags)
+	fs.Var(configs, "config", "topic config as key=value, may be repeated")
+	name := requireArg(fs, fs.Parse(args), "name")
+	if !adminEnabled {
+		exitOnError(errAdminDisabled)
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	exitOnError(createTopic(NewKafkaMonitor().kafkaClient, TopicSpec{
+		Name:              name,
+		Partitions:        int32(*partitions),
+		ReplicationFactor: int16(*replication),
+		Configs:           configs,
+	}))
+	fmt.Fprintf(os.Stderr, "created topic %s\n", name)
+}
+
+func runAddPartitions(args []string) {
+	fs := newFlagSet("add-partitions")
+	adminFlag(fs)
+	count := fs.Int("count", 0, "the new total number of partitions")
+	confirm := fs.String("confirm", "", "topic name to confirm without prompting when the topic is keyed")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+	if !adminEnabled {
+		exitOnError(errAdminDisabled)
+	}
+	exitOnError(checkProtected(topic))
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	client := NewKafkaMonitor().kafkaClient
+	if warning := addPartitionsWarning(client, topic); warning != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+		if !confirmTopicName(fmt.Sprintf("add partitions to %s", topic), topic, *confirm) {
+			exitOnError(fmt.Errorf("not confirmed"))
+		}
+	}
+	exitOnError(addPartitions(client, topic, int32(*count)))
+	fmt.Fprintf(os.Stderr, "topic %s now has %d partitions\n", topic, *count)
+}
+
+func runDeleteTopic(args []string) {
+	fs := newFlagSet("delete-topic")
+	adminFlag(fs)
+	confirm := fs.String("confirm", "", "topic name to confirm without prompting")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+	if !adminEnabled {
+		exitOnError(errAdminDisabled)
+	}
+	exitOnError(checkProtected(topic))
+	if !confirmTopicName(fmt.Sprintf("delete topic %s", topic), topic, *confirm) {
+		exitOnError(fmt.Errorf("not confirmed"))
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	exitOnError(deleteTopic(NewKafkaMonitor().kafkaClient, topic))
+	fmt.Fprintf(os.Stderr, "deleted topic %s\n", topic)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestIsProtectedTopic(t *testing.T) {
+	last := protectedTopics
+	defer func() { protectedTopics = last }()
+	protectedTopics = "payments, audit-*, ,[bad"
+
+	tests := []struct {
+		topic string
+		want  bool
+	}{
+		{"__consumer_offsets", true},
+		{"__transaction_state", true},
+		{"payments", true},
+		{"audit-log", true},
+		{"audit", false},
+		{"orders", false},
+		{"_schemas", false},
+	}
+
+	for _, tt := range tests {
+		if got := isProtectedTopic(tt.topic); got != tt.want {
+			t.Errorf("isProtectedTopic(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+
+	// 空的配置不保护普通 topic
+	protectedTopics = ""
+	if isProtectedTopic("orders") || !isProtectedTopic("__consumer_offsets") {
+		t.Error("empty PROTECTED_TOPICS should only protect internal topics")
+	}
+}
+
+func TestConfirmTokens(t *testing.T) {
+	c := &confirmTokens{tokens: make(map[string]confirmToken)}
+
+	token, expires := c.Issue("delete-topic orders")
+	if time.Until(expires) <= 0 || time.Until(expires) > adminTokenTTL {
+		t.Errorf("expires %v, want within %v", expires, adminTokenTTL)
+	}
+	if c.Consume(token, "delete-topic payments") {
+		t.Error("token consumed for another action")
+	}
+	if c.Consume("unknown", "delete-topic orders") {
+		t.Error("unknown token consumed")
+	}
+	if !c.Consume(token, "delete-topic orders") {
+		t.Error("valid token not consumed")
+	}
+	if c.Consume(token, "delete-topic orders") {
+		t.Error("token consumed twice")
+	}
+
+	expired, _ := c.Issue("delete-topic orders")
+	c.tokens[expired] = confirmToken{action: "delete-topic orders", expires: time.Now().Add(-time.Second)}
+	if c.Consume(expired, "delete-topic orders") {
+		t.Error("expired token consumed")
+	}
+	// 发放新令牌时清理过期的令牌
+	c.tokens[expired] = confirmToken{action: "delete-topic orders", expires: time.Now().Add(-time.Second)}
+	c.Issue("delete-topic payments")
+	if _, ok := c.tokens[expired]; ok {
+		t.Error("expired token not removed")
+	}
+}
+
+func TestHandleAdminTopics(t *testing.T) {
+	lastAdmin, lastProtected, lastAddr := adminEnabled, protectedTopics, brokerAddr
+	defer func() { adminEnabled, protectedTopics, brokerAddr = lastAdmin, lastProtected, lastAddr }()
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	// OffsetRequest 的响应没有分区，读取 orders 的消息失败，无法确认是否有 key
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"OffsetRequest":       sarama.NewMockWrapper(&sarama.OffsetResponse{Version: 1}),
+		"DeleteTopicsRequest": sarama.NewMockWrapper(&sarama.DeleteTopicsResponse{Version: 1, TopicErrorCodes: map[string]sarama.KError{"orders": sarama.ErrNoError}}),
+	})
+	brokerAddr = broker.Addr()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	handler := handleAdminTopics(client)
+	request := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+	confirmRequired := func(name string, w *httptest.ResponseRecorder) ConfirmRequired {
+		var resp ConfirmRequired
+		if w.Code != http.StatusConflict {
+			t.Fatalf("%s: status %d, want 409: %s", name, w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Confirm == "" {
+			t.Fatalf("%s: invalid confirm response %s", name, w.Body)
+		}
+		return resp
+	}
+
+	adminEnabled = false
+	if w := request(http.MethodDelete, "/api/admin/topics/orders"); w.Code != http.StatusForbidden {
+		t.Errorf("admin disabled: status %d, want 403", w.Code)
+	}
+
+	adminEnabled, protectedTopics = true, "payments"
+	for _, tt := range []struct{ method, url string }{
+		{http.MethodDelete, "/api/admin/topics/payments"},
+		{http.MethodDelete, "/api/admin/topics/__consumer_offsets"},
+		{http.MethodPost, "/api/admin/topics/payments/partitions?count=3"},
+	} {
+		if w := request(tt.method, tt.url); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want 403", tt.method, tt.url, w.Code)
+		}
+	}
+
+	first := confirmRequired("delete", request(http.MethodDelete, "/api/admin/topics/orders"))
+	if first.Action != "delete-topic orders" {
+		t.Errorf("action %q, want delete-topic orders", first.Action)
+	}
+	// 令牌只能用于发放时的操作
+	confirmRequired("delete with another token", request(http.MethodDelete, "/api/admin/topics/orders?confirm=unknown"))
+	w := request(http.MethodDelete, "/api/admin/topics/orders?confirm="+first.Confirm)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ok"`) {
+		t.Errorf("confirmed delete: status %d: %s", w.Code, w.Body)
+	}
+	confirmRequired("token reused", request(http.MethodDelete, "/api/admin/topics/orders?confirm="+first.Confirm))
+
+	// 无法读取消息时按有 key 处理，需要确认
+	resp := confirmRequired("add partitions", request(http.MethodPost, "/api/admin/topics/orders/partitions?count=3"))
+	if !strings.Contains(resp.Warning, "can not check whether topic orders has keyed records") {
+		t.Errorf("warning %q", resp.Warning)
+	}
+}
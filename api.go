@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+type TopicResource struct {
+	*Topic
+	Lag int64 `json:"lag"`
+}
+
+type PartitionLag struct {
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	LogEndOffset int64  `json:"log_end_offset"`
+	Lag          int64  `json:"lag"`
+}
+
+type GroupResource struct {
+	Subscriber
+	Lag        int64          `json:"lag"`
+	Partitions []PartitionLag `json:"partitions"`
+}
+
+type BrokerResource struct {
+	BrokerNode
+	Controller bool          `json:"controller"`
+	Leaders    int           `json:"leaders"`
+	Configs    []ConfigEntry `json:"configs"`
+}
+
+// topicResource 中 topic 的 lag 取所有订阅者中最大的 lag
+func topicResource(topic *Topic) TopicResource {
+	resource := TopicResource{Topic: topic}
+	for _, sub := range topic.Subscribers {
+		if sub.Lag > resource.Lag {
+			resource.Lag = sub.Lag
+		}
+	}
+	return resource
+}
+
+func topicResources(metrics *Metrics) []TopicResource {
+	resources := make([]TopicResource, 0, len(metrics.Topics.Items))
+	for _, topic := range metrics.Topics.Items {
+		resources = append(resources, topicResource(topic))
+	}
+	return resources
+}
+
+// partitionLags 返回 group 在各个分区上的 lag，未提交过 offset 的分区 offset 为 -1
+func partitionLags(metrics *Metrics, group string) []PartitionLag {
+	lags := make([]PartitionLag, 0)
+	for _, topic := range metrics.Topics.Items {
+		for _, sub := range topic.Subscribers {
+			if sub.GroupID != group || len(sub.NextOffsets) != len(topic.Partitions) {
+				continue
+			}
+
+			for j, partition := range topic.Partitions {
+				lag := PartitionLag{Topic: topic.Name, Partition: partition, Offset: sub.NextOffsets[j]}
+				if j < len(topic.AvailableOffsets) {
+					lag.LogEndOffset = topic.AvailableOffsets[j]
+				}
+				if lag.Offset != -1 {
+					lag.Lag = lag.LogEndOffset - lag.Offset
+				}
+				lags = append(lags, lag)
+			}
+		}
+	}
+	return lags
+}
+
+func groupResource(metrics *Metrics, sub Subscriber) GroupResource {
+	resource := GroupResource{Subscriber: sub, Partitions: partitionLags(metrics, sub.GroupID)}
+	for _, lag := range resource.Partitions {
+		resource.Lag += lag.Lag
+	}
+	return resource
+}
+
+func groupResources(metrics *Metrics) []GroupResource {
+	resources := make([]GroupResource, 0, len(metrics.Subscribers.Items))
+	for _, sub := range metrics.Subscribers.Items {
+		resources = append(resources, groupResource(metrics, sub))
+	}
+	return resources
+}
+
+// findTopic 和 findGroup 通过快照中的索引查找，只计算该资源
+func findTopic(metrics *Metrics, name string) (TopicResource, bool) {
+	idx, ok := metrics.Topics.filter[name]
+	if !ok {
+		return TopicResource{}, false
+	}
+	return topicResource(metrics.Topics.Items[idx]), true
+}
+
+func findGroup(metrics *Metrics, id string) (GroupResource, bool) {
+	idx, ok := metrics.Subscribers.filter[id]
+	if !ok {
+		return GroupResource{}, false
+	}
+	return groupResource(metrics, metrics.Subscribers.Items[idx]), true
+}
+
+// findBroker 按 id 或地址查找
+func findBroker(metrics *Metrics, id string) (BrokerResource, bool) {
+	for _, broker := range brokerResources(metrics) {
+		if strconv.Itoa(int(broker.ID)) == id || broker.Addr == id {
+			return broker, true
+		}
+	}
+	return BrokerResource{}, false
+}
+
+func brokerResources(metrics *Metrics) []BrokerResource {
+	leaders := make(map[int32]int)
+	for _, topic := range metrics.Topics.Items {
+		for _, leader := range topic.Leaders {
+			leaders[leader]++
+		}
+	}
+
+	configs := make(map[int32][]ConfigEntry)
+	for _, bc := range metrics.Brokers.Configs {
+		configs[bc.ID] = bc.Configs
+	}
+
+	resources := make([]BrokerResource, 0, len(metrics.Brokers.Nodes))
+	for _, node := range metrics.Brokers.Nodes {
+		resources = append(resources, BrokerResource{
+			BrokerNode: node,
+			Controller: node.Addr == metrics.Brokers.Controller,
+			Leaders:    leaders[node.ID],
+			Configs:    configs[node.ID],
+		})
+	}
+	return resources
+}
+
+// listQuery 对应列表接口的查询参数
+type listQuery struct {
+	name           *regexp.Regexp
+	minLag         int64
+	hasSubscribers string
+	sort           string
+	desc           bool
+	offset         int
+	limit          int
+	fields         []string
+}
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	query := r.URL.Query()
+	q := listQuery{limit: defaultPageLimit, hasSubscribers: query.Get("has_subscribers")}
+
+	if name := query.Get("name"); name != "" {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return q, fmt.Errorf("invalid name pattern: %v", err)
+		}
+		q.name = re
+	}
+
+	var err error
+	if v := query.Get("min_lag"); v != "" {
+		if q.minLag, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid min_lag: %v", err)
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, fmt.Errorf("invalid offset: %s", v)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 {
+			return q, fmt.Errorf("invalid limit: %s", v)
+		}
+		if q.limit > maxPageLimit {
+			q.limit = maxPageLimit
+		}
+	}
+
+	// sort=-lag 表示按 lag 降序
+	q.sort = query.Get("sort")
+	if strings.HasPrefix(q.sort, "-") {
+		q.sort, q.desc = q.sort[1:], true
+	}
+
+	if fields := query.Get("fields"); fields != "" {
+		q.fields = strings.Split(fields, ",")
+	}
+	return q, nil
+}
+
+// toDocuments 将资源转换为 JSON 对象，以便按任意字段排序和选择字段
+func toDocuments(v interface{}) []map[string]interface{} {
+	docs := make([]map[string]interface{}, 0)
+	decodeDocument(v, &docs)
+	return docs
+}
+
+func toDocument(v interface{}) map[string]interface{} {
+	doc := make(map[string]interface{})
+	decodeDocument(v, &doc)
+	return doc
+}
+
+func decodeDocument(v, out interface{}) {
+	b, _ := json.Marshal(v)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	_ = decoder.Decode(out)
+}
+
+func compareValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case json.Number:
+		if y, ok := b.(json.Number); ok {
+			fx, _ := x.Float64()
+			fy, _ := y.Float64()
+			switch {
+			case fx < fy:
+				return -1
+			case fx > fy:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if x {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	}
+
+	// 缺失的字段排在最前面
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func selectFields(doc map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return doc
+	}
+
+	selected := make(map[string]interface{})
+	for _, field := range fields {
+		if v, ok := doc[field]; ok {
+			selected[field] = v
+		}
+	}
+	return selected
+}
+
+type listResponse struct {
+	Total  int                      `json:"total"`
+	Offset int                      `json:"offset"`
+	Limit  int                      `json:"limit"`
+	Items  []map[string]interface{} `json:"items"`
+}
+
+// paginate 依次完成排序、分页和字段选择
+func paginate(docs []map[string]interface{}, q listQuery) listResponse {
+	if q.sort != "" {
+		sort.SliceStable(docs, func(i, j int) bool {
+			c := compareValues(docs[i][q.sort], docs[j][q.sort])
+			if q.desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	resp := listResponse{Total: len(docs), Offset: q.offset, Limit: q.limit, Items: []map[string]interface{}{}}
+	for i := q.offset; i < len(docs) && i < q.offset+q.limit; i++ {
+		resp.Items = append(resp.Items, selectFields(docs[i], q.fields))
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.Marshal(v)
+	_, _ = fmt.Fprint(w, string(b))
+}
+
+func listTopics(metrics *Metrics, q listQuery) listResponse {
+	resources := make([]TopicResource, 0)
+	for _, topic := range topicResources(metrics) {
+		if q.name != nil && !q.name.MatchString(topic.Name) {
+			continue
+		}
+		if topic.Lag < q.minLag {
+			continue
+		}
+		if q.hasSubscribers != "" && (len(topic.Subscribers) > 0) != (q.hasSubscribers == "true") {
+			continue
+		}
+		resources = append(resources, topic)
+	}
+	return paginate(toDocuments(resources), q)
+}
+
+func listGroups(metrics *Metrics, q listQuery) listResponse {
+	resources := make([]GroupResource, 0)
+	for _, group := range groupResources(metrics) {
+		if q.name != nil && !q.name.MatchString(group.GroupID) {
+			continue
+		}
+		if group.Lag < q.minLag {
+			continue
+		}
+		resources = append(resources, group)
+	}
+	return paginate(toDocuments(resources), q)
+}
+
+func listBrokers(metrics *Metrics, q listQuery) listResponse {
+	resources := make([]BrokerResource, 0)
+	for _, broker := range brokerResources(metrics) {
+		if q.name != nil && !q.name.MatchString(broker.Addr) {
+			continue
+		}
+		resources = append(resources, broker)
+	}
+	return paginate(toDocuments(resources), q)
+}
+
+// handleAPIv1 处理 /api/v1/{topics,groups,brokers}[/{id}]
+// 列表接口支持 name/min_lag/has_subscribers/sort/offset/limit/fields 参数
+func handleAPIv1(w http.ResponseWriter, r *http.Request) {
+	metrics := currentMetrics
+	if metrics == nil {
+		http.Error(w, "metrics not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	q, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/"), "/", 2)
+	if len(parts) == 1 {
+		switch parts[0] {
+		case "topics":
+			writeJSON(w, listTopics(metrics, q))
+		case "groups":
+			writeJSON(w, listGroups(metrics, q))
+		case "brokers":
+			writeJSON(w, listBrokers(metrics, q))
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	var resource interface{}
+	var ok bool
+	switch parts[0] {
+	case "topics":
+		resource, ok = findTopic(metrics, parts[1])
+	case "groups":
+		resource, ok = findGroup(metrics, parts[1])
+	case "brokers":
+		resource, ok = findBroker(metrics, parts[1])
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, selectFields(toDocument(resource), q.fields))
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	decodeAvro = "avro"
+
+	registryTimeout    = 5 * time.Second
+	registryRetryAfter = 30 * time.Second
+)
+
+var schemaRegistryURL string
+
+// avroSchema 是解析后的 Avro schema，命名类型通过同一个指针引用
+type avroSchema struct {
+	typ      string
+	fields   []avroField
+	symbols  []string
+	items    *avroSchema
+	values   *avroSchema
+	branches []*avroSchema
+	size     int
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true, "float": true, "double": true, "bytes": true, "string": true,
+}
+
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+	p := &avroParser{named: make(map[string]*avroSchema)}
+	return p.parse(v, "")
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch x := v.(type) {
+	case string:
+		if avroPrimitives[x] {
+			return &avroSchema{typ: x}, nil
+		}
+		if s, ok := p.named[fullName(x, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[x]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", x)
+
+	case []interface{}:
+		s := &avroSchema{typ: "union"}
+		for _, item := range x {
+			branch, err := p.parse(item, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		typ, _ := x["type"].(string)
+		if ns, ok := x["namespace"].(string); ok {
+			namespace = ns
+		}
+
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			name, _ := x["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("avro %s without name", typ)
+			}
+			full := fullName(name, namespace)
+			if i := strings.LastIndex(full, "."); i >= 0 {
+				namespace = full[:i]
+			}
+
+			// 先注册名字，记录可以引用自身
+			s := &avroSchema{typ: typ}
+			p.named[full] = s
+
+			switch typ {
+			case "enum":
+				symbols, _ := x["symbols"].([]interface{})
+				for _, symbol := range symbols {
+					s.symbols = append(s.symbols, fmt.Sprint(symbol))
+				}
+			case "fixed":
+				size, _ := x["size"].(float64)
+				s.size = int(size)
+			default:
+				s.typ = "record"
+				fields, _ := x["fields"].([]interface{})
+				for _, f := range fields {
+					field, _ := f.(map[string]interface{})
+					name, _ := field["name"].(string)
+					fs, err := p.parse(field["type"], namespace)
+					if err != nil {
+						return nil, err
+					}
+					s.fields = append(s.fields, avroField{name: name, schema: fs})
+				}
+			}
+			return s, nil
+
+		case "array":
+			items, err := p.parse(x["items"], namespace)
+			return &avroSchema{typ: typ, items: items}, err
+
+		case "map":
+			values, err := p.parse(x["values"], namespace)
+			return &avroSchema{typ: typ, values: values}, err
+		}
+
+		// {"type": "long", "logicalType": "timestamp-millis"} 等按原始类型解码
+		if x["type"] != nil {
+			return p.parse(x["type"], namespace)
+		}
+	}
+	return nil, fmt.Errorf("invalid avro schema %v", v)
+}
+
+// avroDecoder 将 Avro 二进制数据直接写为 JSON，保持 record 字段的顺序
+type avroDecoder struct {
+	data []byte
+	pos  int
+	out  bytes.Buffer
+}
+
+var errAvroShort = fmt.Errorf("avro data too short")
+
+func (d *avroDecoder) long() (int64, error) {
+	var u uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if d.pos >= len(d.data) {
+			return 0, errAvroShort
+		}
+		b := d.data[d.pos]
+		d.pos++
+		u |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return int64(u>>1) ^ -int64(u&1), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid avro varint")
+}
+
+func (d *avroDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errAvroShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *avroDecoder) writeJSON(v interface{}) {
+	b, _ := json.Marshal(v)
+	d.out.Write(b)
+}
+
+func (d *avroDecoder) writeFloat(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		d.out.WriteString("null")
+		return
+	}
+	d.out.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// writeBytes 按 Avro JSON 编码的约定，每个字节对应一个 0-255 的字符
+func (d *avroDecoder) writeBytes(b []byte) {
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		runes = append(runes, rune(c))
+	}
+	d.writeJSON(string(runes))
+}
+
+// blocks 读取 array 和 map 的数据块，块大小为负数时后面跟着块的字节数
+// 元素数量不能超过剩余的字节数，否则 null 这类不占字节的元素会让错误的数量循环很多次，
+// 因此超过剩余字节数的 null 数组也会被当作错误的数据
+func (d *avroDecoder) blocks(item func(i int) error) error {
+	i := 0
+	for {
+		count, err := d.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err := d.long(); err != nil {
+				return err
+			}
+		}
+		if count < 0 || count > int64(len(d.data)-d.pos) {
+			return fmt.Errorf("invalid avro block count %d with %d bytes left", count, len(d.data)-d.pos)
+		}
+		for ; count > 0; count-- {
+			if err := item(i); err != nil {
+				return err
+			}
+			i++
+		}
+	}
+}
+
+func (d *avroDecoder) decode(s *avroSchema) error {
+	switch s.typ {
+	case "null":
+		d.out.WriteString("null")
+
+	case "boolean":
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		d.writeJSON(b[0] != 0)
+
+	case "int", "long":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(strconv.FormatInt(n, 10))
+
+	case "float":
+		b, err := d.read(4)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+
+	case "double":
+		b, err := d.read(8)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+
+	case "bytes", "string":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		b, err := d.read(int(n))
+		if err != nil {
+			return err
+		}
+		if s.typ == "string" {
+			d.writeJSON(string(b))
+		} else {
+			d.writeBytes(b)
+		}
+
+	case "fixed":
+		b, err := d.read(s.size)
+		if err != nil {
+			return err
+		}
+		d.writeBytes(b)
+
+	case "enum":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		if n < 0 || int(n) >= len(s.symbols) {
+			return fmt.Errorf("invalid avro enum index %d", n)
+		}
+		d.writeJSON(s.symbols[n])
+
+	case "union":
+		// 为了便于阅读，union 直接输出值，不使用 {"type": value} 的形式
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		if n < 0 || int(n) >= len(s.branches) {
+			return fmt.Errorf("invalid avro union index %d", n)
+		}
+		return d.decode(s.branches[n])
+
+	case "record":
+		d.out.WriteByte('{')
+		for i, f := range s.fields {
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			d.writeJSON(f.name)
+			d.out.WriteByte(':')
+			if err := d.decode(f.schema); err != nil {
+				return err
+			}
+		}
+		d.out.WriteByte('}')
+
+	case "array":
+		d.out.WriteByte('[')
+		err := d.blocks(func(i int) error {
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			return d.decode(s.items)
+		})
+		if err != nil {
+			return err
+		}
+		d.out.WriteByte(']')
+
+	case "map":
+		d.out.WriteByte('{')
+		err := d.blocks(func(i int) error {
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			n, err := d.long()
+			if err != nil {
+				return err
+			}
+			key, err := d.read(int(n))
+			if err != nil {
+				return err
+			}
+			d.writeJSON(string(key))
+			d.out.WriteByte(':')
+			return d.decode(s.values)
+		})
+		if err != nil {
+			return err
+		}
+		d.out.WriteByte('}')
+
+	default:
+		return fmt.Errorf("unsupported avro type %q", s.typ)
+	}
+	return nil
+}
+
+// SchemaRegistry 从 Schema Registry 按 id 获取 schema，id 对应的 schema 不会变化，因此一直缓存
+// 获取失败的 id 在 registryRetryAfter 内直接返回上次的错误，避免每条消息都请求一次
+type SchemaRegistry struct {
+	sync.Mutex
+	url      string
+	client   *http.Client
+	schemas  map[uint32]*avroSchema
+	errors   map[uint32]registryError
+	inflight map[uint32]*registryFetch
+}
+
+type registryError struct {
+	err error
+	at  time.Time
+}
+
+// registryFetch 是正在进行的请求，同一个 id 的其他调用等待 done 关闭后使用它的结果
+type registryFetch struct {
+	done   chan struct{}
+	schema *avroSchema
+	err    error
+}
+
+func NewSchemaRegistry(url string) *SchemaRegistry {
+	return &SchemaRegistry{
+		url:      strings.TrimRight(url, "/"),
+		client:   &http.Client{Timeout: registryTimeout},
+		schemas:  make(map[uint32]*avroSchema),
+		errors:   make(map[uint32]registryError),
+		inflight: make(map[uint32]*registryFetch),
+	}
+}
+
+// Schema 请求 registry 时不持有锁，其他 id 的查询不会被慢请求阻塞
+func (r *SchemaRegistry) Schema(id uint32) (*avroSchema, error) {
+	r.Lock()
+	if s, ok := r.schemas[id]; ok {
+		r.Unlock()
+		return s, nil
+	}
+	if e, ok := r.errors[id]; ok && time.Since(e.at) < registryRetryAfter {
+		r.Unlock()
+		return nil, e.err
+	}
+	if f, ok := r.inflight[id]; ok {
+		r.Unlock()
+		<-f.done
+		return f.schema, f.err
+	}
+	f := &registryFetch{done: make(chan struct{})}
+	r.inflight[id] = f
+	r.Unlock()
+
+	f.schema, f.err = r.fetch(id)
+
+	r.Lock()
+	delete(r.inflight, id)
+	if f.err != nil {
+		r.errors[id] = registryError{f.err, time.Now()}
+	} else {
+		delete(r.errors, id)
+		r.schemas[id] = f.schema
+	}
+	r.Unlock()
+	close(f.done)
+	return f.schema, f.err
+}
+
+func (r *SchemaRegistry) fetch(id uint32) (*avroSchema, error) {
+	resp, err := r.client.Get(fmt.Sprintf("%s/schemas/ids/%d", r.url, id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema %d: registry returned %s: %s", id, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("schema %d: %v", id, err)
+	}
+	if result.SchemaType != "" && result.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema %d is %s, not AVRO", id, result.SchemaType)
+	}
+
+	s, err := parseAvroSchema(result.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %v", id, err)
+	}
+	return s, nil
+}
+
+var (
+	registryMu     sync.Mutex
+	schemaRegistry *SchemaRegistry
+)
+
+// currentRegistry 在第一次使用时根据 schemaRegistryURL 创建
+func currentRegistry() (*SchemaRegistry, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if schemaRegistryURL == "" {
+		return nil, fmt.Errorf("schema registry is not configured, set %s or -registry", envSchemaRegistry)
+	}
+	if schemaRegistry == nil || schemaRegistry.url != strings.TrimRight(schemaRegistryURL, "/") {
+		schemaRegistry = NewSchemaRegistry(schemaRegistryURL)
+	}
+	return schemaRegistry, nil
+}
+
+// isAvroFramed 判断是否为 Confluent 格式：magic byte 0 + 4 字节 schema id + Avro 数据
+func isAvroFramed(b []byte) bool {
+	return len(b) >= 5 && b[0] == 0
+}
+
+// decodeAvroBytes 将 Confluent 格式的 Avro 数据解码为格式化的 JSON
+func decodeAvroBytes(b []byte) (string, error) {
+	if !isAvroFramed(b) {
+		return "", fmt.Errorf("not a schema registry framed avro record")
+	}
+
+	registry, err := currentRegistry()
+	if err != nil {
+		return "", err
+	}
+	schema, err := registry.Schema(binary.BigEndian.Uint32(b[1:5]))
+	if err != nil {
+		return "", err
+	}
+
+	d := &avroDecoder{data: b[5:]}
+	if err := d.decode(schema); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, d.out.Bytes(), "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const orderSchema = `{"type": "record", "name": "Order", "namespace": "shop", "fields": [
+	{"name": "id", "type": "long"},
+	{"name": "customer", "type": "string"},
+	{"name": "tags", "type": {"type": "array", "items": "string"}},
+	{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "PAID"]}},
+	{"name": "note", "type": ["null", "string"]},
+	{"name": "price", "type": "double"},
+	{"name": "counts", "type": {"type": "map", "values": "int"}},
+	{"name": "paid", "type": "boolean"},
+	{"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "parent", "type": ["null", "Order"]}
+]}`
+
+// avroLong 按 zigzag varint 编码
+func avroLong(n int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, n)]
+}
+
+func avroString(s string) []byte {
+	return append(avroLong(int64(len(s))), s...)
+}
+
+func avroFrame(id uint32, parts ...[]byte) []byte {
+	b := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], id)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func orderRecord() []byte {
+	price := make([]byte, 8)
+	binary.LittleEndian.PutUint64(price, math.Float64bits(9.5))
+
+	var b []byte
+	for _, part := range [][]byte{
+		avroLong(42), avroString("ann"),
+		avroLong(2), avroString("vip"), avroString("new"), avroLong(0),
+		avroLong(1),
+		avroLong(1), avroString("leave at door"),
+		price,
+		avroLong(-1), avroLong(4), avroString("a"), avroLong(3), avroLong(0),
+		{1},
+		avroLong(1560000000000),
+		avroLong(0),
+	} {
+		b = append(b, part...)
+	}
+	return b
+}
+
+// newTestRegistry 启动一个假的 Schema Registry，返回每个 id 被请求的次数
+// id 5 的请求会通知 started，并等到 release 关闭后才返回
+func newTestRegistry(started, release chan struct{}) (*httptest.Server, map[string]*int32) {
+	schemas := map[string]string{
+		"1": fmt.Sprintf(`{"schema": %q}`, orderSchema),
+		"2": `{"schema": "{\"type\": \"array\", \"items\": \"null\"}"}`,
+		"4": `{"schema": "syntax = \"proto3\";", "schemaType": "PROTOBUF"}`,
+		"5": `{"schema": "\"string\""}`,
+	}
+	requests := map[string]*int32{}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		requests[id] = new(int32)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/schemas/ids/")
+		if n, ok := requests[id]; ok {
+			atomic.AddInt32(n, 1)
+		}
+		if id == "5" {
+			started <- struct{}{}
+			<-release
+		}
+		schema, ok := schemas[id]
+		if !ok {
+			http.Error(w, `{"error_code": 40403, "message": "Schema not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(schema))
+	}))
+	return server, requests
+}
+
+func TestDecodeAvroBytes(t *testing.T) {
+	server, requests := newTestRegistry(nil, nil)
+	defer server.Close()
+
+	lastURL, lastRegistry := schemaRegistryURL, schemaRegistry
+	schemaRegistryURL = server.URL + "/"
+	defer func() { schemaRegistryURL, schemaRegistry = lastURL, lastRegistry }()
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  string
+	}{
+		{"record", avroFrame(1, orderRecord()), `{"id":42,"customer":"ann","tags":["vip","new"],"status":"PAID","note":"leave at door","price":9.5,"counts":{"a":3},"paid":true,"created":1560000000000,"parent":null}`, ""},
+		{"cached record", avroFrame(1, orderRecord()), `{"id":42,"customer":"ann","tags":["vip","new"],"status":"PAID","note":"leave at door","price":9.5,"counts":{"a":3},"paid":true,"created":1560000000000,"parent":null}`, ""},
+		{"not framed", []byte(`{"id": 42}`), "", "not a schema registry framed avro record"},
+		{"too short", []byte{0, 0, 0, 1}, "", "not a schema registry framed avro record"},
+		{"truncated", avroFrame(1, orderRecord()[:5]), "", "avro data too short"},
+		{"bad enum", avroFrame(1, avroLong(1), avroString("a"), avroLong(0), avroLong(5)), "", "invalid avro enum index 5"},
+		{"bad block count", avroFrame(2, avroLong(math.MaxInt64)), "", fmt.Sprintf("invalid avro block count %d with 0 bytes left", int64(math.MaxInt64))},
+		{"negative block count", avroFrame(2, avroLong(-3), avroLong(0), []byte{0, 0}), "", "invalid avro block count 3 with 2 bytes left"},
+		{"null items", avroFrame(2, avroLong(1), avroLong(0)), `[null]`, ""},
+		{"missing schema", avroFrame(3), "", "schema 3: registry returned 404 Not Found"},
+		{"missing schema again", avroFrame(3), "", "schema 3: registry returned 404 Not Found"},
+		{"not avro", avroFrame(4), "", "schema 4 is PROTOBUF, not AVRO"},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeAvroBytes(tt.data)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(got)); err != nil || compact.String() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// 成功的 schema 一直缓存，失败的 schema 在 registryRetryAfter 内也不会重新请求
+	for id, want := range map[string]int32{"1": 1, "2": 1, "3": 1} {
+		if n := atomic.LoadInt32(requests[id]); n != want {
+			t.Errorf("schema %s requested %d times, want %d", id, n, want)
+		}
+	}
+}
+
+func TestSchemaRegistryInflight(t *testing.T) {
+	started, release := make(chan struct{}, 5), make(chan struct{})
+	server, requests := newTestRegistry(started, release)
+	defer server.Close()
+
+	registry := NewSchemaRegistry(server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, err := registry.Schema(5); err != nil || s.typ != "string" {
+				t.Errorf("Schema(5) = %v, %v", s, err)
+			}
+		}()
+	}
+	<-started
+
+	// 慢请求进行期间其他 id 的查询不需要等待
+	done := make(chan error)
+	go func() {
+		_, err := registry.Schema(1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Schema(1) is blocked by the request for schema 5")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(requests["5"]); n != 1 {
+		t.Errorf("schema 5 requested %d times, want 1", n)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// brokerLocalConfigs 中的配置项在每个 broker 上本就应该不同，不参与漂移检查
+var brokerLocalConfigs = map[string]bool{
+	"broker.id":                      true,
+	"broker.rack":                    true,
+	"listeners":                      true,
+	"advertised.listeners":           true,
+	"host.name":                      true,
+	"advertised.host.name":           true,
+	"port":                           true,
+	"advertised.port":                true,
+	"log.dir":                        true,
+	"log.dirs":                       true,
+	"listener.security.protocol.map": true,
+}
+
+type BrokerConfig struct {
+	Broker  string        `json:"broker"`
+	ID      int32         `json:"id"`
+	Configs []ConfigEntry `json:"configs"`
+}
+
+type BrokerValue struct {
+	Broker string `json:"broker"`
+	Value  string `json:"value"`
+}
+
+type ConfigDrift struct {
+	Name   string        `json:"name"`
+	Values []BrokerValue `json:"values"`
+}
+
+// DriftReport 是 /drift 的响应，FailedBrokers 中的 broker 没有取到配置，没有参与比较
+type DriftReport struct {
+	Drift         []ConfigDrift `json:"drift"`
+	FailedBrokers []string      `json:"failed_brokers"`
+}
+
+// refreshBrokerConfigs
+// 按 CONFIG_INTERVAL 间隔刷新，有 broker 没有取到配置时下一次采集重试
+func (m *KafkaMonitor) refreshBrokerConfigs() {
+	if time.Since(m.brokerConfigsUpdated) >= time.Duration(configInterval)*time.Second {
+		configs := make([]BrokerConfig, 0, len(m.brokers))
+		failed := make([]string, 0)
+		for _, broker := range m.brokers {
+			if err := m.reconnectBroker(broker); err != nil {
+				failed = append(failed, broker.Addr())
+				continue
+			}
+
+			id := strconv.Itoa(int(broker.ID()))
+			resp, err := describeConfigs(broker, sarama.BrokerResource, []string{id})
+			if err != nil {
+				logrus.Warnf("describe broker %s configs error: %v", broker.Addr(), err)
+				failed = append(failed, broker.Addr())
+				continue
+			}
+
+			configs = append(configs, BrokerConfig{
+				Broker:  broker.Addr(),
+				ID:      broker.ID(),
+				Configs: resp[id],
+			})
+		}
+
+		sort.Slice(configs, func(i, j int) bool { return configs[i].ID < configs[j].ID })
+		sort.Strings(failed)
+		m.brokerConfigs = configs
+		m.brokerConfigsFailed = failed
+		if len(failed) == 0 {
+			m.brokerConfigsUpdated = time.Now()
+		}
+
+		for _, drift := range configDrift(configs) {
+			logrus.Warnf("broker config %s differs between brokers: %v", drift.Name, drift.Values)
+		}
+	}
+
+	m.metrics.Brokers.Configs = m.brokerConfigs
+	m.metrics.Brokers.Drift = configDrift(m.brokerConfigs)
+	m.metrics.Brokers.FailedBrokers = m.brokerConfigsFailed
+}
+
+// configDrift 找出在不同 broker 上取值不一致的配置项
+func configDrift(configs []BrokerConfig) []ConfigDrift {
+	values := make(map[string][]BrokerValue)
+	for _, bc := range configs {
+		for _, entry := range bc.Configs {
+			if entry.Sensitive || brokerLocalConfigs[entry.Name] {
+				continue
+			}
+			values[entry.Name] = append(values[entry.Name], BrokerValue{Broker: bc.Broker, Value: entry.Value})
+		}
+	}
+
+	drifts := make([]ConfigDrift, 0)
+	for name, vs := range values {
+		differ := len(vs) != len(configs)
+		for i := 1; i < len(vs) && !differ; i++ {
+			differ = vs[i].Value != vs[0].Value
+		}
+		if differ {
+			drifts = append(drifts, ConfigDrift{Name: name, Values: vs})
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Name < drifts[j].Name })
+	return drifts
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type command struct {
+	name  string
+	usage string
+	desc  string
+	run   func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve", "collect cluster metrics every tick and serve the HTTP API (default)", func([]string) { serve() }},
+		{"brokers", "brokers [-o format]", "list brokers", runBrokers},
+		{"topics", "topics [-o format]", "list topics", runTopics},
+		{"topic", "topic <name> [-o format]", "describe the partitions of a topic", runTopic},
+		{"groups", "groups [-o format]", "list consumer groups", runGroups},
+		{"group", "group <id> [-o format]", "describe the partition lag of a consumer group", runGroup},
+		{"lag", "lag [-min-lag n] [-o format]", "list the lag of every group on every partition", runLag},
+		{"peek", "peek <topic> [flags]", "print records of a partition from an offset, time, group offset or the tail", runPeek},
+		{"tail", "tail <topic> [flags]", "follow new records of a topic like tail -f", runTail},
+		{"search", "search <topic> [flags]", "scan a topic for records matching a substring, regex or JSON path", runSearch},
+		{"produce", "produce <topic> [value...] [flags]", "send records from arguments, a file or stdin and print their offsets", runProduce},
+		{"dump", "dump <topic> [flags]", "export records of a topic to a JSON lines file", runDump},
+		{"restore", "restore <file> [flags]", "produce records of a dump file back into a topic", runRestore},
+		{"copy", "copy <topic> [flags]", "copy records to another topic or cluster, optionally following new records", runCopy},
+		{"create-topic", "create-topic <name> [flags]", "create a topic (requires -admin)", runCreateTopic},
+		{"add-partitions", "add-partitions <topic> -count n", "increase the partition count of a topic (requires -admin)", runAddPartitions},
+		{"delete-topic", "delete-topic <topic> [flags]", "delete a topic (requires -admin)", runDeleteTopic},
+		{"plan", "plan <file> [-o format]", "compare a YAML file of desired topics with the cluster and print the changes", runPlan},
+		{"apply", "apply <file> [flags]", "create topics, add partitions and alter configs to match a YAML file (requires -admin)", runApply},
+		{"offsets", "offsets <topic> -time t [flags]", "find the offset of the first record at or after a time in every partition", runOffsets},
+		{"reset-offsets", "reset-offsets <group> [flags]", "show and commit new offsets of an inactive group", runResetOffsets},
+		{"export-offsets", "export-offsets <group>... [flags]", "export the committed offsets of groups to a JSON file", runExportOffsets},
+		{"import-offsets", "import-offsets <file> [flags]", "validate and commit offsets of an exported file to an inactive group", runImportOffsets},
+		{"query", "query <sql> [-o format]", "run a SQL-like query against the cluster snapshot", runQuery},
+		{"top", "top", "full-screen view of group and topic lag, refreshed every tick", runTop},
+		{"shell", "shell", "interactive shell with history and tab completion", runShell},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: kfk <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", cmd.usage, cmd.desc)
+	}
+	fmt.Fprintln(os.Stderr, "\nCommon flags:\n  -b addr     kafka broker address (default $BROKER_ADDR or localhost:9092)\n  -o format   output format: table, json, yaml or csv (default table)")
+}
+
+// runCommand 不带参数运行时与之前一样启动服务
+func runCommand(args []string) {
+	if len(args) == 0 {
+		serve()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	if args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	}
+	usage()
+	os.Exit(2)
+}
+
+// cliFlags 是所有一次性命令共用的参数
+type cliFlags struct {
+	*flag.FlagSet
+	output string
+}
+
+func newFlagSet(name string) *cliFlags {
+	fs := &cliFlags{FlagSet: flag.NewFlagSet(name, flag.ExitOnError)}
+	fs.StringVar(&brokerAddr, "b", brokerAddr, "kafka broker address")
+	fs.StringVar(&fs.output, "o", outputTable, "output format: table, json, yaml or csv")
+	return fs
+}
+
+// Parse 允许参数和位置参数交替出现，如 kfk topic foo -o json
+func (fs *cliFlags) Parse(args []string) []string {
+	positional := make([]string, 0)
+	for {
+		_ = fs.FlagSet.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// collect 连接集群完成一次采集，一次性命令不会写入 MongoDB
+func collect() *Metrics {
+	logrus.SetLevel(logrus.ErrorLevel)
+	return refreshMetrics(NewKafkaMonitor())
+}
+
+// refreshMetrics 采集一次集群状态，需要继续使用 monitor 的 client 时直接调用
+func refreshMetrics(monitor *KafkaMonitor) *Metrics {
+	if err := monitor.Refresh(); err != nil {
+		logrus.Fatal(err)
+	}
+	if currentMetrics == nil {
+		logrus.Fatal("could not collect cluster metrics")
+	}
+	return currentMetrics
+}
+
+func requireArg(fs *cliFlags, args []string, name string) string {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: kfk %s <%s> [flags]\n", fs.Name(), name)
+		os.Exit(2)
+	}
+	return args[0]
+}
+
+func formatInt32s(ids []int32) string {
+	return "[" + formatReplicas(ids) + "]"
+}
+
+func printBrokers(w io.Writer, format string, metrics *Metrics) error {
+	resources := brokerResources(metrics)
+	table := Table{Headers: []string{"id", "addr", "rack", "controller", "leaders"}}
+	for _, b := range resources {
+		table.Append(b.ID, b.Addr, b.Rack, b.Controller, b.Leaders)
+	}
+	return printOutput(w, format, table, resources)
+}
+
+func printTopics(w io.Writer, format string, metrics *Metrics) error {
+	resources := topicResources(metrics)
+	table := Table{Headers: []string{"name", "partitions", "replication", "logsize", "subscribers", "max_lag"}}
+	for _, t := range resources {
+		groups := make([]string, 0, len(t.Subscribers))
+		for _, sub := range t.Subscribers {
+			groups = append(groups, sub.GroupID)
+		}
+		table.Append(t.Name, len(t.Partitions), t.ReplicationFactor, t.LogSize, strings.Join(groups, ","), t.Lag)
+	}
+	return printOutput(w, format, table, resources)
+}
+
+func printTopic(w io.Writer, format string, metrics *Metrics, name string) error {
+	idx, ok := metrics.Topics.filter[name]
+	if !ok {
+		return fmt.Errorf("topic %s not found", name)
+	}
+	topic := metrics.Topics.Items[idx]
+
+	table := Table{Headers: []string{"partition", "leader", "isr", "log_start", "log_end"}}
+	for j, partition := range topic.Partitions {
+		row := []interface{}{partition, "-", "-", "-", "-"}
+		if j < len(topic.Leaders) {
+			row[1] = topic.Leaders[j]
+		}
+		if j < len(topic.ISR) {
+			row[2] = formatInt32s(topic.ISR[j])
+		}
+		if j < len(topic.LogStartOffsets) {
+			row[3] = topic.LogStartOffsets[j]
+		}
+		if j < len(topic.AvailableOffsets) {
+			row[4] = topic.AvailableOffsets[j]
+		}
+		table.Append(row...)
+	}
+	return printOutput(w, format, table, topicResource(topic))
+}
+
+func printGroups(w io.Writer, format string, metrics *Metrics) error {
+	resources := groupResources(metrics)
+	table := Table{Headers: []string{"group", "state", "members", "topics", "lag", "health"}}
+	for _, g := range resources {
+		table.Append(g.GroupID, g.State, len(g.Members), strings.Join(g.Topic, ","), g.Lag, g.Health)
+	}
+	return printOutput(w, format, table, resources)
+}
+
+func printGroup(w io.Writer, format string, metrics *Metrics, id string) error {
+	g, ok := findGroup(metrics, id)
+	if !ok {
+		return fmt.Errorf("group %s not found", id)
+	}
+
+	table := Table{Headers: []string{"topic", "partition", "offset", "log_end", "lag"}}
+	for _, p := range g.Partitions {
+		table.Append(p.Topic, p.Partition, p.Offset, p.LogEndOffset, p.Lag)
+	}
+	return printOutput(w, format, table, g)
+}
+
+type GroupPartitionLag struct {
+	Group string `json:"group"`
+	PartitionLag
+}
+
+func printLag(w io.Writer, format string, metrics *Metrics, minLag int64) error {
+	lags := make([]GroupPartitionLag, 0)
+	table := Table{Headers: []string{"group", "topic", "partition", "offset", "log_end", "lag"}}
+	for _, g := range groupResources(metrics) {
+		for _, p := range g.Partitions {
+			if p.Lag < minLag {
+				continue
+			}
+			table.Append(g.GroupID, p.Topic, p.Partition, p.Offset, p.LogEndOffset, p.Lag)
+			lags = append(lags, GroupPartitionLag{Group: g.GroupID, PartitionLag: p})
+		}
+	}
+	return printOutput(w, format, table, lags)
+}
+
+// stdinReader 是所有交互式输入共用的 reader，每次新建 reader 会丢掉上一个 reader 已经缓冲的输入
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirmStdin 执行修改操作前要求在 stdin 输入 yes 确认
+func confirmStdin(action string) bool {
+	fmt.Fprintf(os.Stderr, "%s, type 'yes' to confirm: ", action)
+	answer, err := stdinReader.ReadString('\n')
+	return (err == nil || err == io.EOF) && strings.TrimSpace(answer) == "yes"
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func runBrokers(args []string) {
+	fs := newFlagSet("brokers")
+	fs.Parse(args)
+	exitOnError(printBrokers(os.Stdout, fs.output, collect()))
+}
+
+func runTopics(args []string) {
+	fs := newFlagSet("topics")
+	fs.Parse(args)
+	exitOnError(printTopics(os.Stdout, fs.output, collect()))
+}
+
+func runTopic(args []string) {
+	fs := newFlagSet("topic")
+	name := requireArg(fs, fs.Parse(args), "name")
+	exitOnError(printTopic(os.Stdout, fs.output, collect(), name))
+}
+
+func runGroups(args []string) {
+	fs := newFlagSet("groups")
+	fs.Parse(args)
+	exitOnError(printGroups(os.Stdout, fs.output, collect()))
+}
+
+func runGroup(args []string) {
+	fs := newFlagSet("group")
+	id := requireArg(fs, fs.Parse(args), "id")
+	exitOnError(printGroup(os.Stdout, fs.output, collect(), id))
+}
+
+func runLag(args []string) {
+	fs := newFlagSet("lag")
+	minLag := fs.Int64("min-lag", 0, "only show partitions with at least this lag")
+	fs.Parse(args)
+	exitOnError(printLag(os.Stdout, fs.output, collect(), *minLag))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestConfirmSharesStdin(t *testing.T) {
+	last := stdinReader
+	defer func() { stdinReader = last }()
+
+	// 一次写入所有回答，后面的确认必须读到前面的 reader 已经缓冲的内容
+	stdinReader = bufio.NewReader(strings.NewReader("yes\nno\norders\n yes \n"))
+	got := []bool{
+		confirmStdin("import offsets into group a"),
+		confirmStdin("import offsets into group b"),
+		confirmTopicName("delete topic orders", "orders", ""),
+		confirmStdin("import offsets into group c"),
+		confirmStdin("import offsets into group d"),
+	}
+	want := []bool{true, false, true, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("answer %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if !confirmTopicName("delete topic orders", "orders", "orders") || confirmTopicName("delete topic orders", "orders", "other") {
+		t.Error("confirmTopicName does not use the given confirmation")
+	}
+}
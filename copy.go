@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	copyBatchSize        = 500
+	copyProgressInterval = 5 * time.Second
+)
+
+// CopyCheckpoint 记录每个分区下一条需要复制的 offset，只在写入目标 topic 成功后更新
+type CopyCheckpoint struct {
+	Topic      string          `json:"topic"`
+	DestTopic  string          `json:"dest_topic"`
+	DestBroker string          `json:"dest_broker"`
+	Partitions map[int32]int64 `json:"partitions"`
+}
+
+// loadCopyCheckpoint 读取 checkpoint，文件不存在时返回空的 checkpoint，
+// 源 topic 或者目标不一致时报错，避免把消息续写到另一个目标
+func loadCopyCheckpoint(file string, req CopyRequest) (*CopyCheckpoint, error) {
+	cp := &CopyCheckpoint{Topic: req.Source.Topic, DestTopic: req.Topic, DestBroker: req.Broker, Partitions: make(map[int32]int64)}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", file, err)
+	}
+	if cp.Topic != req.Source.Topic {
+		return nil, fmt.Errorf("checkpoint %s belongs to topic %s", file, cp.Topic)
+	}
+	if cp.DestTopic != req.Topic || cp.DestBroker != req.Broker {
+		return nil, fmt.Errorf("checkpoint %s copies to topic %s on %s", file, cp.DestTopic, cp.DestBroker)
+	}
+	if cp.Partitions == nil {
+		cp.Partitions = make(map[int32]int64)
+	}
+	return cp, nil
+}
+
+// Save 先写临时文件再重命名，中途退出也不会留下不完整的 checkpoint
+func (cp *CopyCheckpoint) Save(file string) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// CopyRequest 描述复制的范围和过滤条件，范围和匹配条件与 search 相同，不指定匹配条件时复制所有消息
+type CopyRequest struct {
+	Source        SearchRequest
+	Filter        bool
+	Topic         string // 目标 topic
+	Broker        string // 目标集群的 broker 地址，记录在 checkpoint 中
+	Follow        bool   // 复制完范围内的消息后继续复制新消息
+	KeepPartition bool
+	Checkpoint    string
+}
+
+type CopyStats struct {
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func copyMessage(topic string, msg *sarama.ConsumerMessage, keepPartition bool) *sarama.ProducerMessage {
+	out := &sarama.ProducerMessage{Topic: topic, Timestamp: msg.Timestamp}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		out.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		out.Headers = append(out.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	if keepPartition {
+		out.Partition = msg.Partition
+	}
+	return out
+}
+
+// copyTopic 从源 topic 读取消息并分批写入目标 topic，每批写入成功后更新 checkpoint，
+// 没有新消息时立即写入当前的批次，done 关闭时写完当前批次后返回
+func copyTopic(client sarama.Client, producer sarama.SyncProducer, req CopyRequest, done <-chan struct{}, progress func(CopyStats)) (CopyStats, error) {
+	var stats CopyStats
+	src := req.Source
+
+	partitions := src.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(src.Topic); err != nil {
+			return stats, err
+		}
+	}
+
+	cp := &CopyCheckpoint{Topic: src.Topic, DestTopic: req.Topic, DestBroker: req.Broker, Partitions: make(map[int32]int64)}
+	if req.Checkpoint != "" {
+		var err error
+		if cp, err = loadCopyCheckpoint(req.Checkpoint, req); err != nil {
+			return stats, err
+		}
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return stats, err
+	}
+	defer consumer.Close()
+
+	type scan struct {
+		pc         sarama.PartitionConsumer
+		partition  int32
+		start, end int64
+	}
+	scans := make([]scan, 0, len(partitions))
+	closeAll := func() {
+		for _, sc := range scans {
+			_ = sc.pc.Close()
+		}
+	}
+
+	for _, partition := range partitions {
+		start, end, err := src.scanRange(client, partition)
+		if err != nil {
+			closeAll()
+			return stats, err
+		}
+		// 从 checkpoint 恢复时忽略起始位置，但仍然使用范围的结束位置
+		if next, ok := cp.Partitions[partition]; ok && next > start {
+			start = next
+		}
+		if req.Follow {
+			end = -1
+		} else if start >= end {
+			continue
+		}
+
+		pc, err := consumer.ConsumePartition(src.Topic, partition, start)
+		if err != nil {
+			closeAll()
+			return stats, err
+		}
+		scans = append(scans, scan{pc, partition, start, end})
+	}
+
+	stop := make(chan struct{})
+	messages := make(chan *sarama.ConsumerMessage)
+	// 有结束位置的分区没有读完时在这里报告，所有分区结束后返回第一个错误
+	failures := make(chan error, len(scans))
+	var wg sync.WaitGroup
+
+	for _, sc := range scans {
+		wg.Add(1)
+		go func(sc scan) {
+			defer wg.Done()
+			defer sc.pc.Close()
+
+			next := sc.start
+			for {
+				var idle <-chan time.Time
+				if sc.end >= 0 {
+					idle = time.After(fetchTimeout)
+				}
+
+				select {
+				case <-stop:
+					return
+				case err := <-sc.pc.Errors():
+					if sc.end >= 0 {
+						failures <- fmt.Errorf("%s/%d: %v", err.Topic, err.Partition, err.Err)
+						return
+					}
+					logrus.Warnf("copy %s/%d error: %v", err.Topic, err.Partition, err.Err)
+				case msg := <-sc.pc.Messages():
+					if sc.end >= 0 && msg.Offset >= sc.end {
+						return
+					}
+					select {
+					case messages <- msg:
+					case <-stop:
+						return
+					}
+					next = msg.Offset + 1
+					if sc.end >= 0 && next >= sc.end {
+						return
+					}
+				case <-idle:
+					if err := checkPartitionEnd(client, src.Topic, sc.partition, next, sc.end); err != nil {
+						failures <- err
+					}
+					return
+				}
+			}
+		}(sc)
+	}
+
+	go func() {
+		wg.Wait()
+		close(messages)
+	}()
+	defer func() {
+		close(stop)
+		for range messages {
+		}
+	}()
+
+	batch := make([]*sarama.ProducerMessage, 0, copyBatchSize)
+	next := make(map[int32]int64)
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := producer.SendMessages(batch); err != nil {
+				if errs, ok := err.(sarama.ProducerErrors); ok && len(errs) > 0 {
+					return fmt.Errorf("%d records failed, first error: %v", len(errs), errs[0].Err)
+				}
+				return err
+			}
+			stats.Copied += int64(len(batch))
+			batch = batch[:0]
+		}
+
+		if req.Checkpoint == "" || len(next) == 0 {
+			return nil
+		}
+		for partition, offset := range next {
+			cp.Partitions[partition] = offset
+		}
+		next = make(map[int32]int64)
+		return cp.Save(req.Checkpoint)
+	}
+
+	ticker := time.NewTicker(copyProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		var msg *sarama.ConsumerMessage
+		var ok bool
+		select {
+		case msg, ok = <-messages:
+		default:
+			// 没有等待中的消息时先写入当前批次
+			if err := flush(); err != nil {
+				return stats, err
+			}
+			select {
+			case msg, ok = <-messages:
+			case <-ticker.C:
+				progress(stats)
+				continue
+			case <-done:
+				return stats, flush()
+			}
+		}
+
+		if !ok {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+			select {
+			case err := <-failures:
+				return stats, err
+			default:
+				return stats, nil
+			}
+		}
+
+		next[msg.Partition] = msg.Offset + 1
+		if req.Filter && !src.Match(msg) {
+			stats.Skipped++
+			continue
+		}
+		stats.Bytes += int64(len(msg.Key) + len(msg.Value))
+		batch = append(batch, copyMessage(req.Topic, msg, req.KeepPartition))
+		if len(batch) >= copyBatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+
+		select {
+		case <-ticker.C:
+			progress(stats)
+		case <-done:
+			return stats, flush()
+		default:
+		}
+	}
+}
+
+func runCopy(args []string) {
+	fs := newFlagSet("copy")
+	target := fs.String("t", "", "destination topic, the source topic by default")
+	targetBroker := fs.String("to-b", "", "destination kafka broker address, the source cluster by default")
+	partitions := fs.String("p", "", "comma separated source partitions, all partitions by default")
+	fromOffset := fs.Int64("from-offset", -1, "start at this offset")
+	toOffset := fs.Int64("to-offset", -1, "stop before this offset")
+	fromTime := fs.String("from-time", "", "start at this time (RFC3339 or milliseconds)")
+	toTime := fs.String("to-time", "", "stop before this time (RFC3339 or milliseconds)")
+	follow := fs.Bool("follow", false, "keep copying new records until interrupted")
+	field := fs.String("field", fieldAny, "where to match: any, key, value or header")
+	contains := fs.String("contains", "", "only copy records containing this substring")
+	pattern := fs.String("regex", "", "only copy records matching this regular expression")
+	jsonPath := fs.String("jsonpath", "", "only copy JSON records matching a predicate such as '$.user.id == 42'")
+	keepPartition := fs.Bool("keep-partition", false, "copy every record to the same partition instead of partitioning by key")
+	checkpoint := fs.String("checkpoint", "", "resume from and save progress to this file")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+
+	req := CopyRequest{Source: NewSearchRequest(topic), Topic: *target, Follow: *follow, KeepPartition: *keepPartition, Checkpoint: *checkpoint}
+	src := &req.Source
+	src.FromOffset, src.ToOffset, src.Field, src.Contains = *fromOffset, *toOffset, *field, *contains
+
+	var err error
+	if *partitions != "" {
+		src.Partitions, err = parseInt32s(*partitions)
+		exitOnError(err)
+	}
+	if *fromTime != "" {
+		src.FromTime, err = parseTimestamp(*fromTime)
+		exitOnError(err)
+	}
+	if *toTime != "" {
+		src.ToTime, err = parseTimestamp(*toTime)
+		exitOnError(err)
+	}
+	if *pattern != "" {
+		src.Regex, err = regexp.Compile(*pattern)
+		exitOnError(err)
+	}
+	if *jsonPath != "" {
+		src.JSONPath, err = parseJSONPredicate(*jsonPath)
+		exitOnError(err)
+	}
+	if req.Filter = src.Contains != "" || src.Regex != nil || src.JSONPath != nil; req.Filter {
+		exitOnError(src.Validate())
+	}
+
+	if *targetBroker == "" {
+		*targetBroker = brokerAddr
+	}
+	if req.Topic == "" {
+		req.Topic = topic
+	}
+	if req.Topic == topic && *targetBroker == brokerAddr {
+		exitOnError(fmt.Errorf("source and destination are the same topic, use -t or -to-b"))
+	}
+	req.Broker = *targetBroker
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	partitioner := sarama.NewHashPartitioner
+	if *keepPartition {
+		partitioner = sarama.NewManualPartitioner
+	}
+	producer, err := newSyncProducer(*targetBroker, partitioner)
+	exitOnError(err)
+	defer producer.Close()
+
+	// Ctrl-C 时写完当前批次并保存 checkpoint 后退出
+	done := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(done)
+	}()
+
+	started := time.Now()
+	report := func(stats CopyStats) {
+		rate := float64(stats.Copied) / time.Since(started).Seconds()
+		fmt.Fprintf(os.Stderr, "copied %d records (%d bytes), skipped %d, %.0f records/s\n", stats.Copied, stats.Bytes, stats.Skipped, rate)
+	}
+	stats, err := copyTopic(monitor.kafkaClient, producer, req, done, report)
+	report(stats)
+	exitOnError(err)
+}
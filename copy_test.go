@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// testProducer 记录写入的消息，代替目标集群
+type testProducer struct {
+	sent []*sarama.ProducerMessage
+}
+
+func (p *testProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return msg.Partition, int64(len(p.sent) - 1), nil
+}
+
+func (p *testProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.sent = append(p.sent, msgs...)
+	return nil
+}
+
+func (p *testProducer) Close() error { return nil }
+
+func TestLoadCopyCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kfk-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "checkpoint.json")
+
+	req := CopyRequest{Source: NewSearchRequest("orders"), Topic: "orders-mirror", Broker: "backup:9092"}
+	cp, err := loadCopyCheckpoint(file, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Partitions) != 0 || cp.DestTopic != "orders-mirror" || cp.DestBroker != "backup:9092" {
+		t.Errorf("missing file: got %+v", cp)
+	}
+	cp.Partitions[0] = 42
+	if err := cp.Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(req *CopyRequest)
+		err    string
+	}{
+		{"same", func(req *CopyRequest) {}, ""},
+		{"other source", func(req *CopyRequest) { req.Source.Topic = "payments" }, "belongs to topic orders"},
+		{"other destination topic", func(req *CopyRequest) { req.Topic = "orders-copy" }, "copies to topic orders-mirror on backup:9092"},
+		{"other destination broker", func(req *CopyRequest) { req.Broker = "localhost:9092" }, "copies to topic orders-mirror on backup:9092"},
+	}
+
+	for _, tt := range tests {
+		r := req
+		tt.change(&r)
+		cp, err := loadCopyCheckpoint(file, r)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || cp.Partitions[0] != 42 {
+			t.Errorf("%s: got %+v, %v", tt.name, cp, err)
+		}
+	}
+}
+
+// orders/0 的范围是 [0, 3)，checkpoint 中已经复制到 2，只复制 offset 2；
+// orders/1 的范围也是 [0, 3)，但只能读到 0 和 1，空闲后以停在 offset 2 的错误结束，
+// 已经复制的消息仍然保存在 checkpoint 中
+func TestCopyTopicResumeAndStall(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	resp := &sarama.FetchResponse{Version: 4}
+	for _, partition := range []int32{0, 1} {
+		resp.AddError("orders", partition, sarama.ErrNoError)
+		block := resp.GetBlock("orders", partition)
+		block.HighWaterMarkOffset = 3
+		block.LastStableOffset = 3
+		block.RecordsSet = []*sarama.Records{recordBatch(0, false), recordBatch(1, false)}
+		if partition == 0 {
+			// broker 从请求的 offset 开始返回
+			block.RecordsSet = []*sarama.Records{recordBatch(2, false)}
+		}
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 0, sarama.OffsetNewest, 3).
+			SetOffset("orders", 1, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, sarama.OffsetNewest, 3),
+		"FetchRequest": sarama.NewMockWrapper(resp),
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dir, err := ioutil.TempDir("", "kfk-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	req := CopyRequest{Source: NewSearchRequest("orders"), Topic: "orders-mirror", Broker: "backup:9092", Checkpoint: filepath.Join(dir, "checkpoint.json")}
+	cp := &CopyCheckpoint{Topic: "orders", DestTopic: "orders-mirror", DestBroker: "backup:9092", Partitions: map[int32]int64{0: 2}}
+	if err := cp.Save(req.Checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	producer := &testProducer{}
+	stats, err := copyTopic(client, producer, req, nil, func(CopyStats) {})
+	want := "orders/1: no records after offset 2 before the end offset 3"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+	if stats.Copied != 3 || len(producer.sent) != 3 {
+		t.Errorf("copied %d, sent %d, want 3", stats.Copied, len(producer.sent))
+	}
+	for _, msg := range producer.sent {
+		if msg.Topic != "orders-mirror" {
+			t.Errorf("sent to %s, want orders-mirror", msg.Topic)
+		}
+	}
+
+	saved, err := loadCopyCheckpoint(req.Checkpoint, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int32]int64{0: 3, 1: 2}; !reflect.DeepEqual(saved.Partitions, want) {
+		t.Errorf("checkpoint %v, want %v", saved.Partitions, want)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleDashboard 返回内嵌的单页面 dashboard，页面不依赖任何外部资源
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprint(w, dashboardHTML)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kfk</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; margin: 0; background: #f5f6f8; color: #222; font-size: 13px; }
+header { background: #23272e; color: #fff; padding: 12px 24px; display: flex; align-items: baseline; gap: 24px; }
+header h1 { font-size: 18px; margin: 0; }
+header span { color: #aab; }
+main { display: grid; grid-template-columns: 1fr 1fr; gap: 16px; padding: 16px 24px; }
+section { background: #fff; border-radius: 4px; box-shadow: 0 1px 2px rgba(0,0,0,.1); padding: 12px 16px; overflow: auto; }
+section.wide { grid-column: 1 / 3; }
+h2 { font-size: 14px; margin: 0 0 8px; display: flex; justify-content: space-between; }
+input { font-size: 12px; padding: 2px 6px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; white-space: nowrap; }
+th { color: #666; font-weight: 600; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.clickable { cursor: pointer; }
+tr.clickable:hover, tr.selected { background: #eef3fb; }
+.health { padding: 1px 6px; border-radius: 3px; color: #fff; font-size: 11px; }
+.health-ok { background: #3a9a5b; }
+.health-lagging { background: #d08a1a; }
+.health-stalled, .health-inactive { background: #8a6fc2; }
+.health-data_loss { background: #c93c3c; }
+#events { max-height: 320px; overflow: auto; }
+#events div { padding: 3px 0; border-bottom: 1px solid #f0f0f0; font-family: Menlo, monospace; font-size: 12px; }
+#chart { width: 100%; height: 180px; }
+.muted { color: #999; }
+</style>
+</head>
+<body>
+<header>
+  <h1>🖇 kfk</h1>
+  <span id="controller"></span>
+  <span id="updated"></span>
+</header>
+<main>
+  <section>
+    <h2>Brokers</h2>
+    <table id="brokers"></table>
+  </section>
+  <section>
+    <h2>Events</h2>
+    <div id="events"></div>
+  </section>
+  <section class="wide">
+    <h2>Topics <input id="topic-filter" placeholder="filter"></h2>
+    <table id="topics"></table>
+  </section>
+  <section>
+    <h2>Groups <input id="group-filter" placeholder="filter"></h2>
+    <table id="groups"></table>
+  </section>
+  <section>
+    <h2 id="group-title">Group</h2>
+    <svg id="chart"></svg>
+    <table id="partitions"></table>
+  </section>
+</main>
+<script>
+// snapshot 保存推送的快照，按 /api/stream?delta=true 推送的变化更新；state 中是由它计算出的表格数据
+var snapshot = { topics: {}, groups: {}, brokers: null };
+var state = { groups: [], topics: [], selected: null };
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return { "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" }[c];
+  });
+}
+
+function num(n) {
+  return Number(n || 0).toLocaleString();
+}
+
+function get(url) {
+  return fetch(url).then(function (resp) { return resp.json(); });
+}
+
+function table(el, headers, rows) {
+  var html = "<tr>" + headers.map(function (h) {
+    return "<th" + (h.num ? " class=num" : "") + ">" + esc(h.title) + "</th>";
+  }).join("") + "</tr>";
+  document.getElementById(el).innerHTML = html + rows.join("");
+}
+
+function renderBrokers(items) {
+  table("brokers", [{ title: "id" }, { title: "addr" }, { title: "rack" }, { title: "leaders", num: true }],
+    items.map(function (b) {
+      return "<tr><td>" + b.id + (b.controller ? " ★" : "") + "</td><td>" + esc(b.addr) + "</td><td>" +
+        esc(b.rack || "") + "</td><td class=num>" + b.leaders + "</td></tr>";
+    }));
+}
+
+function renderTopics() {
+  var filter = document.getElementById("topic-filter").value;
+  table("topics", [{ title: "name" }, { title: "partitions", num: true }, { title: "rf", num: true },
+    { title: "logsize", num: true }, { title: "msg/s", num: true }, { title: "max lag", num: true }, { title: "subscribers" }],
+    state.topics.filter(function (t) { return t.name.indexOf(filter) >= 0; }).map(function (t) {
+      var subs = (t.subscribers || []).map(function (s) { return s.group_id; }).join(", ");
+      return "<tr><td>" + esc(t.name) + "</td><td class=num>" + t.partitions.length + "</td><td class=num>" +
+        t.replication_factor + "</td><td class=num>" + num(t.logsize) + "</td><td class=num>" +
+        Number(t.produce_rate || 0).toFixed(1) + "</td><td class=num>" + num(t.lag) + "</td><td>" + esc(subs) + "</td></tr>";
+    }));
+}
+
+function renderGroups() {
+  var filter = document.getElementById("group-filter").value;
+  table("groups", [{ title: "group" }, { title: "state" }, { title: "members", num: true }, { title: "lag", num: true }, { title: "health" }],
+    state.groups.filter(function (g) { return g.group_id.indexOf(filter) >= 0; }).map(function (g) {
+      return "<tr class=\"clickable" + (g.group_id === state.selected ? " selected" : "") + "\" data-group=\"" + esc(g.group_id) + "\"><td>" +
+        esc(g.group_id) + "</td><td>" + esc(g.state || "") + "</td><td class=num>" + (g.members || []).length +
+        "</td><td class=num>" + num(g.lag) + "</td><td><span class=\"health health-" + esc(g.health) + "\">" + esc(g.health) + "</span></td></tr>";
+    }));
+}
+
+function renderGroup() {
+  var group = state.groups.filter(function (g) { return g.group_id === state.selected; })[0];
+  if (!group) {
+    document.getElementById("group-title").textContent = "Group";
+    document.getElementById("partitions").innerHTML = "<tr><td class=muted>select a group</td></tr>";
+    document.getElementById("chart").innerHTML = "";
+    return;
+  }
+
+  document.getElementById("group-title").textContent = group.group_id;
+  table("partitions", [{ title: "topic" }, { title: "partition", num: true }, { title: "offset", num: true },
+    { title: "log end", num: true }, { title: "lag", num: true }],
+    (group.partitions || []).map(function (p) {
+      return "<tr><td>" + esc(p.topic) + "</td><td class=num>" + p.partition + "</td><td class=num>" +
+        (p.offset < 0 ? "-" : num(p.offset)) + "</td><td class=num>" + num(p.log_end_offset) + "</td><td class=num>" + num(p.lag) + "</td></tr>";
+    }));
+
+  get("/api/history?group=" + encodeURIComponent(group.group_id)).then(renderChart);
+}
+
+function renderChart(points) {
+  var svg = document.getElementById("chart");
+  var w = svg.clientWidth || 400, h = svg.clientHeight || 180, pad = 24;
+  if (!points || points.length < 2) {
+    svg.innerHTML = "<text x=8 y=20 fill=#999>not enough history yet</text>";
+    return;
+  }
+
+  var max = Math.max.apply(null, points.map(function (p) { return p.lag; })) || 1;
+  var t0 = points[0].timestamp, t1 = points[points.length - 1].timestamp || t0 + 1;
+  var path = points.map(function (p, i) {
+    var x = pad + (w - 2 * pad) * (p.timestamp - t0) / Math.max(t1 - t0, 1);
+    var y = h - pad - (h - 2 * pad) * p.lag / max;
+    return (i ? "L" : "M") + x.toFixed(1) + "," + y.toFixed(1);
+  }).join(" ");
+
+  svg.innerHTML = "<line x1=" + pad + " y1=" + (h - pad) + " x2=" + (w - pad) + " y2=" + (h - pad) + " stroke=#ccc />" +
+    "<path d=\"" + path + "\" fill=none stroke=#2f6fce stroke-width=2 />" +
+    "<text x=" + pad + " y=14 fill=#666>max lag " + num(max) + "</text>" +
+    "<text x=" + pad + " y=" + (h - 6) + " fill=#999>" + new Date(t0 * 1000).toLocaleTimeString() + "</text>" +
+    "<text x=" + (w - pad) + " y=" + (h - 6) + " fill=#999 text-anchor=end>" + new Date(t1 * 1000).toLocaleTimeString() + "</text>";
+}
+
+function renderEvent(e, prepend) {
+  var div = document.createElement("div");
+  var subject = [e.topic, e.partition !== undefined ? "#" + e.partition : "", e.group, e.broker].filter(Boolean).join(" ");
+  var change = e.old_value || e.new_value ? " " + (e.old_value || "") + " → " + (e.new_value || "") : "";
+  div.textContent = new Date(e.timestamp * 1000).toLocaleTimeString() + "  " + e.type + "  " + subject + change;
+
+  var el = document.getElementById("events");
+  if (prepend) {
+    el.insertBefore(div, el.firstChild);
+  } else {
+    el.appendChild(div);
+  }
+}
+
+function values(m) {
+  return Object.keys(m).map(function (k) { return m[k]; });
+}
+
+// applyDelta 合并一次推送的变化，再按 /api/v1 的规则计算 lag、分区 lag 和 leader 数
+function applyDelta(delta) {
+  (delta.topics || []).forEach(function (t) { snapshot.topics[t.name] = t; });
+  (delta.removed_topics || []).forEach(function (name) { delete snapshot.topics[name]; });
+  (delta.subscribers || []).forEach(function (g) { snapshot.groups[g.group_id] = g; });
+  (delta.removed_subscribers || []).forEach(function (id) { delete snapshot.groups[id]; });
+  if (delta.brokers) {
+    snapshot.brokers = delta.brokers;
+  }
+
+  var topics = values(snapshot.topics), leaders = {};
+  state.topics = topics.map(function (t) {
+    (t.leaders || []).forEach(function (id) { leaders[id] = (leaders[id] || 0) + 1; });
+    var lag = 0;
+    (t.subscribers || []).forEach(function (s) { lag = Math.max(lag, s.lag); });
+    return { name: t.name, partitions: t.partitions || [], replication_factor: t.replication_factor, logsize: t.logsize,
+      produce_rate: t.produce_rate, lag: lag, subscribers: t.subscribers };
+  }).sort(function (a, b) { return a.name < b.name ? -1 : a.name > b.name ? 1 : 0; });
+
+  state.groups = values(snapshot.groups).map(function (g) {
+    var partitions = [];
+    topics.forEach(function (t) {
+      (t.subscribers || []).forEach(function (s) {
+        if (s.group_id !== g.group_id || (s.next_offsets || []).length !== (t.partitions || []).length) {
+          return;
+        }
+        t.partitions.forEach(function (p, j) {
+          var end = (t.available_offsets || [])[j] || 0, offset = s.next_offsets[j];
+          partitions.push({ topic: t.name, partition: p, offset: offset, log_end_offset: end, lag: offset === -1 ? 0 : end - offset });
+        });
+      });
+    });
+    var lag = partitions.reduce(function (sum, p) { return sum + p.lag; }, 0);
+    return { group_id: g.group_id, state: g.state, members: g.members, health: g.health, lag: lag, partitions: partitions };
+  }).sort(function (a, b) { return b.lag - a.lag || (a.group_id < b.group_id ? -1 : 1); });
+
+  var brokers = snapshot.brokers || {};
+  var nodes = (brokers.nodes || []).slice().sort(function (a, b) { return a.id - b.id; }).map(function (n) {
+    return { id: n.id, addr: n.addr, rack: n.rack, controller: n.addr === brokers.controller, leaders: leaders[n.id] || 0 };
+  });
+  document.getElementById("controller").textContent = "controller: " + (brokers.controller || "-") + "  brokers: " + nodes.length;
+  document.getElementById("updated").textContent = "updated " + new Date(delta.timestamp * 1000).toLocaleTimeString();
+
+  renderBrokers(nodes);
+  renderTopics();
+  renderGroups();
+  renderGroup();
+}
+
+document.getElementById("topic-filter").oninput = renderTopics;
+document.getElementById("group-filter").oninput = renderGroups;
+document.getElementById("groups").onclick = function (ev) {
+  var row = ev.target.closest("tr[data-group]");
+  if (row) {
+    state.selected = row.getAttribute("data-group");
+    renderGroups();
+    renderGroup();
+  }
+};
+
+get("/api/events?limit=50").then(function (events) {
+  events.reverse().forEach(function (e) { renderEvent(e, false); });
+});
+
+// 每个连接的第一次推送是完整的快照，重连后服务端重新开始计算变化，需要丢弃旧的状态
+var source = new EventSource("/api/stream?delta=true");
+source.addEventListener("open", function () { snapshot = { topics: {}, groups: {}, brokers: null }; });
+source.addEventListener("snapshot", function (msg) { applyDelta(JSON.parse(msg.data)); });
+source.addEventListener("event", function (msg) { renderEvent(JSON.parse(msg.data), true); });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 按大小清理时，只有分区大小接近 retention.bytes 后 log start offset 才会随写入向前推进
+	retentionBytesNear = 0.9
+	// 估算分区大小时每条消息在 key 和 value 之外的开销（字节）
+	recordOverhead   = 20
+	recordSampleSize = 10
+	// 每次刷新最多读取的消息时间戳数量，其余分区在之后的刷新中逐步读取
+	maxRecordTimeFetches = 20
+)
+
+type recordSample struct {
+	size    float64
+	sampled time.Time
+}
+
+// recordTime 读取一条消息的时间戳（毫秒），消费者停止时提交的位置不变，复用上一次的结果。
+// 第一次刷新时还没有生产速率，所有落后的分区都会走到这里，因此不读取，命令行只刷新一次也就不会变慢
+func (m *KafkaMonitor) recordTime(topic string, partition int32, offset int64) (int64, bool) {
+	key := fmt.Sprintf("%s/%d/%d", topic, partition, offset)
+	if ts, ok := m.lastRecordTimes[key]; ok {
+		m.recordTimes[key] = ts
+		return ts, true
+	}
+	if m.lastMetrics == nil || m.recordTimeFetches >= maxRecordTimeFetches {
+		return 0, false
+	}
+	m.recordTimeFetches++
+
+	records, err := fetchRecords(m.kafkaClient, FetchRequest{Topic: topic, Partition: partition, Start: startOffset, Offset: offset, Count: 1, Decode: decodeHex})
+	if err != nil || len(records) == 0 || records[0].Timestamp.IsZero() {
+		if err != nil {
+			logrus.Warnf("fetch record %s error: %v", key, err)
+		}
+		return 0, false
+	}
+	ts := records[0].Timestamp.UnixNano() / int64(time.Millisecond)
+	m.recordTimes[key] = ts
+	return ts, true
+}
+
+// recordSize 采样每个分区最后几条消息，估算 topic 平均每条消息占用的字节数，按 CONFIG_INTERVAL 间隔重新采样
+func (m *KafkaMonitor) recordSize(topic *Topic) float64 {
+	if sample, ok := m.recordSizes[topic.Name]; ok && time.Since(sample.sampled) < time.Duration(configInterval)*time.Second {
+		return sample.size
+	}
+
+	var total, count int
+	for _, partition := range topic.Partitions {
+		records, err := fetchRecords(m.kafkaClient, FetchRequest{Topic: topic.Name, Partition: partition, Start: startTail, Count: recordSampleSize, Decode: decodeHex})
+		if err != nil {
+			logrus.Warnf("sample records of topic %s error: %v", topic.Name, err)
+			return 0
+		}
+		for _, r := range records {
+			total += r.KeySize + r.ValueSize + recordOverhead
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+
+	size := float64(total) / float64(count)
+	m.recordSizes[topic.Name] = recordSample{size: size, sampled: time.Now()}
+	return size
+}
+
+// produceRates returns the per-partition produce rate (messages per second)
+// of a topic, derived from the log end offsets of the previous refresh.
+func (m *KafkaMonitor) produceRates(topic *Topic) []float64 {
+	rates := make([]float64, len(topic.Partitions))
+	if m.lastMetrics == nil {
+		return rates
+	}
+
+	idx, ok := m.lastMetrics.Topics.filter[topic.Name]
+	if !ok {
+		return rates
+	}
+	last := m.lastMetrics.Topics.Items[idx]
+
+	elapsed := m.metrics.Timestamp - m.lastMetrics.Timestamp
+	if elapsed <= 0 || len(last.AvailableOffsets) != len(topic.AvailableOffsets) {
+		return rates
+	}
+
+	for i := 0; i < len(topic.AvailableOffsets) && i < len(rates); i++ {
+		if delta := topic.AvailableOffsets[i] - last.AvailableOffsets[i]; delta > 0 {
+			rates[i] = float64(delta) / float64(elapsed)
+		}
+	}
+	return rates
+}
+
+// detectDataLoss
+// 当消费者提交的 offset 小于分区的 log start offset 时，说明消息在被消费前已经被删除
+// 对于仍然落后的消费者，根据生产速率和 retention 配置估算其开始丢失数据的剩余时间（秒）
+func (m *KafkaMonitor) detectDataLoss() {
+	m.lastRecordTimes, m.recordTimes = m.recordTimes, make(map[string]int64)
+	m.recordTimeFetches = 0
+	now := m.metrics.Timestamp
+
+	for _, topic := range m.metrics.Topics.Items {
+		rates := m.produceRates(topic)
+		for _, rate := range rates {
+			topic.ProduceRate += rate
+		}
+
+		for _, item := range topic.Subscribers {
+			item.TimeToDataLoss = -1
+			if len(item.NextOffsets) != len(topic.Partitions) || len(topic.LogStartOffsets) != len(topic.Partitions) {
+				continue
+			}
+
+			eta := math.Inf(1)
+			for j := 0; j < len(topic.Partitions); j++ {
+				committed := item.NextOffsets[j]
+				if committed == -1 {
+					continue
+				}
+
+				start := topic.LogStartOffsets[j]
+				if committed < start {
+					item.DataLoss = true
+					item.LostMessages += start - committed
+					continue
+				}
+
+				lag := topic.AvailableOffsets[j] - committed
+				if lag <= 0 {
+					continue
+				}
+
+				// 最早未消费的消息写入后超过 retention.ms 将被删除，
+				// 有写入时按 lag/rate 估算它的写入时间，没有写入时读取它的时间戳
+				if topic.RetentionMs > 0 {
+					if rates[j] > 0 {
+						eta = math.Min(eta, float64(topic.RetentionMs)/1000-float64(lag)/rates[j])
+					} else if ts, ok := m.recordTime(topic.Name, topic.Partitions[j], committed); ok {
+						eta = math.Min(eta, float64(topic.RetentionMs-(now*1000-ts))/1000)
+					}
+				}
+
+				// 按大小清理时，分区达到 retention.bytes 后 log start offset 以生产速率向前推进，
+				// 离上限较远的分区不会被清理
+				if topic.RetentionBytes > 0 && rates[j] > 0 {
+					size := m.recordSize(topic)
+					if size <= 0 {
+						continue
+					}
+					logBytes := float64(topic.AvailableOffsets[j]-start) * size
+					if logBytes < retentionBytesNear*float64(topic.RetentionBytes) {
+						continue
+					}
+					headroom := math.Max(float64(topic.RetentionBytes)-logBytes, 0) / (rates[j] * size)
+					eta = math.Min(eta, headroom+float64(committed-start)/rates[j])
+				}
+			}
+
+			if !math.IsInf(eta, 1) {
+				item.TimeToDataLoss = int64(math.Max(eta, 0))
+			}
+
+			if item.DataLoss {
+				logrus.Warnf("group %s lost %d messages of topic %s: committed offsets below log start",
+					item.GroupID, item.LostMessages, topic.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectDataLoss(t *testing.T) {
+	now := newTestMetrics().Timestamp
+	// 上一次刷新在 10 秒前，orders 各分区的生产速率为 1/s、0、10/s
+	previous := func() *Metrics {
+		last := newTestMetrics()
+		last.Timestamp = now - 10
+		last.Topics.Items[0].AvailableOffsets = []int64{90, 200, 200}
+		return last
+	}
+
+	tests := []struct {
+		name  string
+		setup func(m *KafkaMonitor, orders *Topic)
+		lost  int64
+		eta   int64
+	}{
+		{"committed below log start", func(m *KafkaMonitor, orders *Topic) {
+			orders.LogStartOffsets = []int64{95, 0, 260}
+		}, 15, -1},
+		{"eta from produce rate", func(m *KafkaMonitor, orders *Topic) {
+			m.lastMetrics = previous()
+		}, 0, 604800 - 10},
+		{"eta from record timestamps", func(m *KafkaMonitor, orders *Topic) {
+			m.lastMetrics = newTestMetrics()
+			m.lastMetrics.Timestamp = now - 10
+			m.recordTimes["orders/0/90"] = (now - 3600) * 1000
+			m.recordTimes["orders/2/250"] = (now - 7200) * 1000
+		}, 0, 604800 - 7200},
+		{"no timestamps on the first refresh", func(m *KafkaMonitor, orders *Topic) {
+			// 没有 lastMetrics 时不读取消息，kafkaClient 为 nil 也不会被用到
+		}, 0, -1},
+		{"eta from retention bytes", func(m *KafkaMonitor, orders *Topic) {
+			m.lastMetrics = previous()
+			orders.RetentionMs = 0
+			orders.RetentionBytes = 10500
+			m.recordSizes["orders"] = recordSample{size: 100, sampled: time.Now()}
+		}, 0, 20},
+		{"far below retention bytes", func(m *KafkaMonitor, orders *Topic) {
+			m.lastMetrics = previous()
+			orders.RetentionMs = 0
+			orders.RetentionBytes = 1000000
+			m.recordSizes["orders"] = recordSample{size: 100, sampled: time.Now()}
+		}, 0, -1},
+	}
+
+	for _, tt := range tests {
+		m := &KafkaMonitor{
+			metrics:     newTestMetrics(),
+			recordTimes: make(map[string]int64),
+			recordSizes: make(map[string]recordSample),
+		}
+		orders := m.metrics.Topics.Items[0]
+		tt.setup(m, orders)
+		m.detectDataLoss()
+
+		billing, audit := orders.Subscribers[0], orders.Subscribers[1]
+		if billing.LostMessages != tt.lost || billing.DataLoss != (tt.lost > 0) {
+			t.Errorf("%s: lost %d (%v), want %d", tt.name, billing.LostMessages, billing.DataLoss, tt.lost)
+		}
+		if billing.TimeToDataLoss != tt.eta {
+			t.Errorf("%s: time to data loss %d, want %d", tt.name, billing.TimeToDataLoss, tt.eta)
+		}
+		if audit.DataLoss || audit.TimeToDataLoss != -1 {
+			t.Errorf("%s: audit has no lag, got data loss %v eta %d", tt.name, audit.DataLoss, audit.TimeToDataLoss)
+		}
+	}
+}
+
+func TestProduceRates(t *testing.T) {
+	m := &KafkaMonitor{metrics: newTestMetrics()}
+	orders := m.metrics.Topics.Items[0]
+	if rates := m.produceRates(orders); rates[0] != 0 || rates[1] != 0 || rates[2] != 0 {
+		t.Errorf("rates without a previous refresh = %v", rates)
+	}
+
+	m.lastMetrics = newTestMetrics()
+	m.lastMetrics.Timestamp -= 10
+	m.lastMetrics.Topics.Items[0].AvailableOffsets = []int64{90, 210, 200}
+	want := []float64{1, 0, 10}
+	rates := m.produceRates(orders)
+	for i := range want {
+		if rates[i] != want[i] {
+			t.Errorf("rates = %v, want %v", rates, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const restoreBatchSize = 500
+
+type DumpHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// DumpRecord 是 dump 文件中的一行，key、value 和 header 的值以 base64 保存，可以区分 null 和空值
+type DumpRecord struct {
+	Topic     string       `json:"topic"`
+	Partition int32        `json:"partition"`
+	Offset    int64        `json:"offset"`
+	Timestamp time.Time    `json:"timestamp"`
+	Key       []byte       `json:"key"`
+	Value     []byte       `json:"value"`
+	Headers   []DumpHeader `json:"headers,omitempty"`
+}
+
+func newDumpRecord(msg *sarama.ConsumerMessage) DumpRecord {
+	record := DumpRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for _, h := range msg.Headers {
+		record.Headers = append(record.Headers, DumpHeader{Key: string(h.Key), Value: h.Value})
+	}
+	return record
+}
+
+// dumpTopic 按分区依次读取范围内的所有消息，范围与 search 相同
+func dumpTopic(client sarama.Client, req SearchRequest, write func(DumpRecord) error) (int64, error) {
+	partitions := req.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(req.Topic); err != nil {
+			return 0, err
+		}
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return 0, err
+	}
+	defer consumer.Close()
+
+	var dumped int64
+	for _, partition := range partitions {
+		start, end, err := req.scanRange(client, partition)
+		if err != nil {
+			return dumped, err
+		}
+		if start >= end {
+			continue
+		}
+
+		pc, err := consumer.ConsumePartition(req.Topic, partition, start)
+		if err != nil {
+			return dumped, err
+		}
+
+		err = func() error {
+			defer pc.Close()
+			next := start
+			for {
+				select {
+				case err := <-pc.Errors():
+					return err
+				case msg := <-pc.Messages():
+					if msg.Offset >= end {
+						return nil
+					}
+					if err := write(newDumpRecord(msg)); err != nil {
+						return err
+					}
+					dumped++
+					next = msg.Offset + 1
+					if next >= end {
+						return nil
+					}
+				case <-time.After(fetchTimeout):
+					return checkPartitionEnd(client, req.Topic, partition, next, end)
+				}
+			}
+		}()
+		if err != nil {
+			return dumped, err
+		}
+	}
+	return dumped, nil
+}
+
+// checkPartitionEnd 在读取空闲时确认 [next, end) 中剩下的都是事务的控制消息，它们不会被 consumer 返回，
+// sarama 按 read uncommitted 读取，被中止的事务消息仍然会返回，因此只需要检查控制消息
+func checkPartitionEnd(client sarama.Client, topic string, partition int32, next, end int64) error {
+	stalled := func() error {
+		return fmt.Errorf("%s/%d: no records after offset %d before the end offset %d", topic, partition, next, end)
+	}
+
+	for next < end {
+		leader, err := client.Leader(topic, partition)
+		if err != nil {
+			return err
+		}
+
+		req := &sarama.FetchRequest{Version: 4, MinBytes: 1, MaxBytes: sarama.MaxResponseSize, Isolation: sarama.ReadUncommitted}
+		req.AddBlock(topic, partition, next, 1024*1024)
+		resp, err := leader.Fetch(req)
+		if err != nil {
+			return err
+		}
+		block := resp.GetBlock(topic, partition)
+		if block == nil {
+			return stalled()
+		}
+		if block.Err != sarama.ErrNoError {
+			return fmt.Errorf("%s/%d: %v", topic, partition, block.Err)
+		}
+		if block.HighWaterMarkOffset < end {
+			return fmt.Errorf("%s/%d: high watermark moved back to %d before the end offset %d", topic, partition, block.HighWaterMarkOffset, end)
+		}
+
+		advanced := false
+		for _, records := range block.RecordsSet {
+			if next >= end {
+				break
+			}
+			batch := records.RecordBatch
+			if batch == nil {
+				// 旧格式的消息没有控制消息
+				return stalled()
+			}
+			last := batch.FirstOffset + int64(batch.LastOffsetDelta)
+			if last < next {
+				continue
+			}
+			if !batch.Control {
+				return stalled()
+			}
+			next, advanced = last+1, true
+		}
+		if !advanced {
+			return stalled()
+		}
+	}
+	return nil
+}
+
+// RestoreOptions 控制写回时是否保留原来的分区和时间戳，Topic 为空时写回原来的 topic
+type RestoreOptions struct {
+	Topic         string
+	KeepPartition bool
+	KeepTimestamp bool
+}
+
+// newSyncProducer 使用单独的 client，保留原来的分区时使用 sarama.NewManualPartitioner
+func newSyncProducer(addr string, partitioner sarama.PartitionerConstructor) (sarama.SyncProducer, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = partitioner
+	return sarama.NewSyncProducer([]string{addr}, cfg)
+}
+
+func (opts RestoreOptions) message(record DumpRecord) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: opts.Topic}
+	if msg.Topic == "" {
+		msg.Topic = record.Topic
+	}
+	// nil 的 value 是 tombstone，需要原样保留
+	if record.Key != nil {
+		msg.Key = sarama.ByteEncoder(record.Key)
+	}
+	if record.Value != nil {
+		msg.Value = sarama.ByteEncoder(record.Value)
+	}
+	for _, h := range record.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+	}
+	if opts.KeepPartition {
+		msg.Partition = record.Partition
+	}
+	if opts.KeepTimestamp {
+		msg.Timestamp = record.Timestamp
+	}
+	return msg
+}
+
+// restoreRecords 逐行读取 dump 文件并分批写入，返回写入的消息数
+func restoreRecords(client sarama.Client, producer sarama.SyncProducer, r io.Reader, opts RestoreOptions) (int64, error) {
+	partitions := make(map[string]int32)
+	partitionCount := func(topic string) (int32, error) {
+		if n, ok := partitions[topic]; ok {
+			return n, nil
+		}
+		ids, err := client.Partitions(topic)
+		if err != nil {
+			return 0, fmt.Errorf("topic %s: %v", topic, err)
+		}
+		partitions[topic] = int32(len(ids))
+		return partitions[topic], nil
+	}
+
+	var restored int64
+	batch := make([]*sarama.ProducerMessage, 0, restoreBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := producer.SendMessages(batch); err != nil {
+			if errs, ok := err.(sarama.ProducerErrors); ok && len(errs) > 0 {
+				return fmt.Errorf("%d records failed, first error: %v", len(errs), errs[0].Err)
+			}
+			return err
+		}
+		restored += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return restored, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			var record DumpRecord
+			if err := json.Unmarshal(b, &record); err != nil {
+				return restored, fmt.Errorf("line %d: %v", line, err)
+			}
+
+			msg := opts.message(record)
+			if msg.Topic == "" {
+				return restored, fmt.Errorf("line %d: missing topic", line)
+			}
+			if opts.KeepPartition {
+				n, err := partitionCount(msg.Topic)
+				if err != nil {
+					return restored, err
+				}
+				if msg.Partition < 0 || msg.Partition >= n {
+					return restored, fmt.Errorf("line %d: partition %d does not exist, %s has %d partitions", line, msg.Partition, msg.Topic, n)
+				}
+			}
+
+			batch = append(batch, msg)
+			if len(batch) >= restoreBatchSize {
+				if err := flush(); err != nil {
+					return restored, err
+				}
+			}
+		}
+		if err == io.EOF {
+			return restored, flush()
+		}
+	}
+}
+
+func runDump(args []string) {
+	fs := newFlagSet("dump")
+	partitions := fs.String("p", "", "comma separated partitions, all partitions by default")
+	fromOffset := fs.Int64("from-offset", -1, "start at this offset")
+	toOffset := fs.Int64("to-offset", -1, "stop before this offset")
+	fromTime := fs.String("from-time", "", "start at this time (RFC3339 or milliseconds)")
+	toTime := fs.String("to-time", "", "stop before this time (RFC3339 or milliseconds)")
+	file := fs.String("f", "-", "write records to this file, - for stdout")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+
+	req := NewSearchRequest(topic)
+	req.FromOffset, req.ToOffset = *fromOffset, *toOffset
+	var err error
+	if *partitions != "" {
+		req.Partitions, err = parseInt32s(*partitions)
+		exitOnError(err)
+	}
+	if *fromTime != "" {
+		req.FromTime, err = parseTimestamp(*fromTime)
+		exitOnError(err)
+	}
+	if *toTime != "" {
+		req.ToTime, err = parseTimestamp(*toTime)
+		exitOnError(err)
+	}
+
+	out := os.Stdout
+	if *file != "-" {
+		out, err = os.Create(*file)
+		exitOnError(err)
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	encoder := json.NewEncoder(w)
+	dumped, err := dumpTopic(monitor.kafkaClient, req, func(record DumpRecord) error {
+		return encoder.Encode(record)
+	})
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	exitOnError(err)
+	fmt.Fprintf(os.Stderr, "dumped %d records\n", dumped)
+}
+
+func runRestore(args []string) {
+	fs := newFlagSet("restore")
+	topic := fs.String("t", "", "produce to this topic instead of the topic in the file")
+	keepPartition := fs.Bool("keep-partition", false, "produce every record to its original partition instead of partitioning by key")
+	keepTimestamp := fs.Bool("keep-timestamp", false, "keep the original timestamps instead of the current time")
+	file := requireArg(fs, fs.Parse(args), "file")
+
+	in := os.Stdin
+	if file != "-" {
+		var err error
+		in, err = os.Open(file)
+		exitOnError(err)
+		defer in.Close()
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	partitioner := sarama.NewHashPartitioner
+	if *keepPartition {
+		partitioner = sarama.NewManualPartitioner
+	}
+	producer, err := newSyncProducer(brokerAddr, partitioner)
+	exitOnError(err)
+	defer producer.Close()
+
+	opts := RestoreOptions{Topic: *topic, KeepPartition: *keepPartition, KeepTimestamp: *keepTimestamp}
+	restored, err := restoreRecords(monitor.kafkaClient, producer, in, opts)
+	fmt.Fprintf(os.Stderr, "restored %d records\n", restored)
+	exitOnError(err)
+}
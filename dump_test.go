@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// recordBatch 构造一个只有一条消息的 batch，control 为 true 时是事务的控制消息
+func recordBatch(offset int64, control bool) *sarama.Records {
+	batch := &sarama.RecordBatch{Version: 2, FirstOffset: offset, Control: control}
+	batch.Records = []*sarama.Record{{Key: []byte{0, 0, 0, 1}, Value: []byte{0, 0, 0, 0, 0, 0}}}
+	return &sarama.Records{RecordBatch: batch}
+}
+
+func TestCheckPartitionEnd(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetLeader("orders", 0, broker.BrokerID())
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{"MetadataRequest": metadata})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name      string
+		next, end int64
+		watermark int64
+		batches   []*sarama.Records
+		err       string
+	}{
+		{"commit marker", 5, 6, 6, []*sarama.Records{recordBatch(5, true)}, ""},
+		{"markers then later records", 5, 7, 8, []*sarama.Records{recordBatch(5, true), recordBatch(6, true), recordBatch(7, false)}, ""},
+		{"batch before next", 5, 6, 6, []*sarama.Records{recordBatch(4, false), recordBatch(5, true)}, ""},
+		{"missing record", 5, 6, 6, []*sarama.Records{recordBatch(5, false)}, "orders/0: no records after offset 5 before the end offset 6"},
+		{"record after marker", 5, 7, 7, []*sarama.Records{recordBatch(5, true), recordBatch(6, false)}, "orders/0: no records after offset 6 before the end offset 7"},
+		{"empty fetch", 5, 6, 6, nil, "orders/0: no records after offset 5 before the end offset 6"},
+		{"truncated log", 5, 6, 4, nil, "orders/0: high watermark moved back to 4 before the end offset 6"},
+	}
+
+	for _, tt := range tests {
+		resp := &sarama.FetchResponse{Version: 4}
+		resp.AddError("orders", 0, sarama.ErrNoError)
+		block := resp.GetBlock("orders", 0)
+		block.HighWaterMarkOffset = tt.watermark
+		block.LastStableOffset = tt.watermark
+		block.RecordsSet = tt.batches
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": metadata,
+			"FetchRequest":    sarama.NewMockWrapper(resp),
+		})
+
+		err := checkPartitionEnd(client, "orders", 0, tt.next, tt.end)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventTopicCreated    = "topic_created"
+	eventTopicDeleted    = "topic_deleted"
+	eventPartitionsAdded = "partitions_added"
+	eventBrokerJoined    = "broker_joined"
+	eventBrokerLeft      = "broker_left"
+	eventControllerMoved = "controller_moved"
+	eventGroupCreated    = "group_created"
+	eventGroupEmptied    = "group_emptied"
+	eventGroupDeleted    = "group_deleted"
+	eventGroupRebalanced = "group_rebalanced"
+	eventLeaderChanged   = "leader_changed"
+	eventISRShrunk       = "isr_shrunk"
+	eventISRExpanded     = "isr_expanded"
+	eventDataLoss        = "data_loss"
+	eventDataLossRisk    = "data_loss_risk"
+
+	groupStateEmpty = "Empty"
+	groupStateDead  = "Dead"
+
+	maxEvents          = 1000
+	defaultEventsLimit = 100
+)
+
+type Event struct {
+	ID        int64  `json:"id" bson:"id"`
+	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+	Type      string `json:"type" bson:"type"`
+	Topic     string `json:"topic,omitempty" bson:"topic,omitempty"`
+	Partition *int32 `json:"partition,omitempty" bson:"partition,omitempty"`
+	Group     string `json:"group,omitempty" bson:"group,omitempty"`
+	Broker    string `json:"broker,omitempty" bson:"broker,omitempty"`
+	OldValue  string `json:"old_value,omitempty" bson:"old_value,omitempty"`
+	NewValue  string `json:"new_value,omitempty" bson:"new_value,omitempty"`
+}
+
+type EventFilter struct {
+	Type   string
+	Topic  string
+	Group  string
+	Broker string
+	Since  int64
+	After  int64
+	Limit  int
+}
+
+func (f EventFilter) Match(e Event) bool {
+	return (f.Type == "" || f.Type == e.Type) &&
+		(f.Topic == "" || f.Topic == e.Topic) &&
+		(f.Group == "" || f.Group == e.Group) &&
+		(f.Broker == "" || f.Broker == e.Broker) &&
+		e.Timestamp >= f.Since &&
+		e.ID > f.After
+}
+
+// EventLog 在内存中保存最近的 maxEvents 条集群变更事件
+type EventLog struct {
+	sync.RWMutex
+	items  []Event
+	lastID int64
+}
+
+func (l *EventLog) Add(events ...Event) []Event {
+	l.Lock()
+	defer l.Unlock()
+
+	for i := range events {
+		l.lastID++
+		events[i].ID = l.lastID
+	}
+
+	l.items = append(l.items, events...)
+	if len(l.items) > maxEvents {
+		l.items = append([]Event{}, l.items[len(l.items)-maxEvents:]...)
+	}
+	return events
+}
+
+func (l *EventLog) SetLastID(id int64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if id > l.lastID {
+		l.lastID = id
+	}
+}
+
+// Query 返回符合条件的最新 limit 条事件，按 ID 升序排列
+func (l *EventLog) Query(filter EventFilter) []Event {
+	l.RLock()
+	defer l.RUnlock()
+
+	events := make([]Event, 0)
+	for i := len(l.items) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		if filter.Match(l.items[i]) {
+			events = append(events, l.items[i])
+		}
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events
+}
+
+var eventLog = &EventLog{}
+
+func partitionPtr(partition int32) *int32 {
+	return &partition
+}
+
+func diffBrokers(last, current *Metrics) []Event {
+	events := make([]Event, 0)
+	add := func(e Event) {
+		e.Timestamp = current.Timestamp
+		events = append(events, e)
+	}
+
+	lastBrokers := make(map[string]bool)
+	for _, broker := range last.Brokers.Members {
+		lastBrokers[broker] = true
+	}
+	currentBrokers := make(map[string]bool)
+	for _, broker := range current.Brokers.Members {
+		currentBrokers[broker] = true
+		if !lastBrokers[broker] {
+			add(Event{Type: eventBrokerJoined, Broker: broker})
+		}
+	}
+	for _, broker := range last.Brokers.Members {
+		if !currentBrokers[broker] {
+			add(Event{Type: eventBrokerLeft, Broker: broker})
+		}
+	}
+	if last.Brokers.Controller != current.Brokers.Controller {
+		add(Event{
+			Type:     eventControllerMoved,
+			Broker:   current.Brokers.Controller,
+			OldValue: last.Brokers.Controller,
+			NewValue: current.Brokers.Controller,
+		})
+	}
+	return events
+}
+
+func diffTopics(last, current *Metrics) []Event {
+	events := make([]Event, 0)
+	add := func(e Event) {
+		e.Timestamp = current.Timestamp
+		events = append(events, e)
+	}
+
+	for _, topic := range current.Topics.Items {
+		idx, ok := last.Topics.filter[topic.Name]
+		if !ok {
+			add(Event{Type: eventTopicCreated, Topic: topic.Name, NewValue: strconv.Itoa(len(topic.Partitions))})
+			continue
+		}
+		events = append(events, diffPartitions(last.Topics.Items[idx], topic, current.Timestamp)...)
+		events = append(events, diffDataLoss(last.Topics.Items[idx], topic, current.Timestamp)...)
+	}
+	for _, topic := range last.Topics.Items {
+		if _, ok := current.Topics.filter[topic.Name]; !ok {
+			add(Event{Type: eventTopicDeleted, Topic: topic.Name})
+		}
+	}
+	return events
+}
+
+func diffGroups(last, current *Metrics) []Event {
+	events := make([]Event, 0)
+	add := func(e Event) {
+		e.Timestamp = current.Timestamp
+		events = append(events, e)
+	}
+
+	for _, group := range current.Subscribers.Items {
+		idx, ok := last.Subscribers.filter[group.GroupID]
+		if !ok {
+			add(Event{Type: eventGroupCreated, Group: group.GroupID, NewValue: group.State})
+			continue
+		}
+
+		lastGroup := last.Subscribers.Items[idx]
+		switch {
+		case group.State == groupStateDead && lastGroup.State != groupStateDead:
+			add(Event{Type: eventGroupDeleted, Group: group.GroupID, OldValue: lastGroup.State})
+		case group.State == groupStateEmpty && lastGroup.State != groupStateEmpty:
+			add(Event{Type: eventGroupEmptied, Group: group.GroupID, OldValue: lastGroup.State})
+		case len(group.Members) > 0 && rebalanced(lastGroup, group):
+			add(Event{
+				Type:     eventGroupRebalanced,
+				Group:    group.GroupID,
+				OldValue: strconv.Itoa(len(lastGroup.Members)),
+				NewValue: strconv.Itoa(len(group.Members)),
+			})
+		}
+	}
+	for _, group := range last.Subscribers.Items {
+		if _, ok := current.Subscribers.filter[group.GroupID]; !ok {
+			add(Event{Type: eventGroupDeleted, Group: group.GroupID, OldValue: group.State})
+		}
+	}
+
+	return events
+}
+
+// rebalanced
+// 每次 rebalance 都会增加 generation，同一批成员重新加入时成员集合不变，只能通过 generation 发现。
+// generation 未知时（没有读取 __consumer_offsets）退回到比较成员集合（已按 member id 排序）
+func rebalanced(last, current Subscriber) bool {
+	if last.Generation > 0 && current.Generation > 0 {
+		return last.Generation != current.Generation
+	}
+	if len(last.Members) != len(current.Members) {
+		return true
+	}
+	for i := range current.Members {
+		if current.Members[i].MemberID != last.Members[i].MemberID {
+			return true
+		}
+	}
+	return false
+}
+
+func diffPartitions(last, current *Topic, timestamp int64) []Event {
+	events := make([]Event, 0)
+
+	if len(current.Partitions) > len(last.Partitions) {
+		events = append(events, Event{
+			Timestamp: timestamp,
+			Type:      eventPartitionsAdded,
+			Topic:     current.Name,
+			OldValue:  strconv.Itoa(len(last.Partitions)),
+			NewValue:  strconv.Itoa(len(current.Partitions)),
+		})
+	}
+
+	for i := 0; i < len(last.Partitions) && i < len(current.Partitions); i++ {
+		partition := current.Partitions[i]
+		if i < len(last.Leaders) && i < len(current.Leaders) && last.Leaders[i] != current.Leaders[i] {
+			events = append(events, Event{
+				Timestamp: timestamp,
+				Type:      eventLeaderChanged,
+				Topic:     current.Name,
+				Partition: partitionPtr(partition),
+				OldValue:  strconv.Itoa(int(last.Leaders[i])),
+				NewValue:  strconv.Itoa(int(current.Leaders[i])),
+			})
+		}
+
+		if i < len(last.ISR) && i < len(current.ISR) {
+			// 按成员比较，副本被替换时 ISR 的大小不变，分别产生收缩和扩张事件
+			isrEvent := func(typ string) Event {
+				return Event{
+					Timestamp: timestamp,
+					Type:      typ,
+					Topic:     current.Name,
+					Partition: partitionPtr(partition),
+					OldValue:  formatReplicas(last.ISR[i]),
+					NewValue:  formatReplicas(current.ISR[i]),
+				}
+			}
+			removed, added := diffReplicas(last.ISR[i], current.ISR[i])
+			if removed {
+				events = append(events, isrEvent(eventISRShrunk))
+			}
+			if added {
+				events = append(events, isrEvent(eventISRExpanded))
+			}
+		}
+	}
+	return events
+}
+
+func atDataLossRisk(sub *TopicSubscriber) bool {
+	return sub.TimeToDataLoss >= 0 && sub.TimeToDataLoss < int64(dataLossAlert)
+}
+
+// diffDataLoss 订阅者开始丢失数据，或距离丢失数据的时间低于 DATA_LOSS_ALERT 时产生告警事件，
+// 状态持续期间不会重复产生
+func diffDataLoss(last, current *Topic, timestamp int64) []Event {
+	events := make([]Event, 0)
+
+	lastSubs := make(map[string]*TopicSubscriber)
+	for _, sub := range last.Subscribers {
+		lastSubs[sub.GroupID] = sub
+	}
+	for _, sub := range current.Subscribers {
+		prev, ok := lastSubs[sub.GroupID]
+		if !ok {
+			prev = &TopicSubscriber{TimeToDataLoss: -1}
+		}
+
+		switch {
+		case sub.DataLoss && !prev.DataLoss:
+			events = append(events, Event{
+				Timestamp: timestamp,
+				Type:      eventDataLoss,
+				Topic:     current.Name,
+				Group:     sub.GroupID,
+				NewValue:  strconv.FormatInt(sub.LostMessages, 10),
+			})
+		case !sub.DataLoss && atDataLossRisk(sub) && !atDataLossRisk(prev):
+			events = append(events, Event{
+				Timestamp: timestamp,
+				Type:      eventDataLossRisk,
+				Topic:     current.Name,
+				Group:     sub.GroupID,
+				OldValue:  strconv.FormatInt(prev.TimeToDataLoss, 10),
+				NewValue:  strconv.FormatInt(sub.TimeToDataLoss, 10),
+			})
+		}
+	}
+	return events
+}
+
+// diffReplicas 返回是否有副本离开、是否有副本加入
+func diffReplicas(last, current []int32) (removed, added bool) {
+	members := make(map[int32]bool, len(last))
+	for _, id := range last {
+		members[id] = true
+	}
+	for _, id := range current {
+		if !members[id] {
+			added = true
+		}
+		delete(members, id)
+	}
+	return len(members) > 0, added
+}
+
+func formatReplicas(replicas []int32) string {
+	ids := make([]string, 0, len(replicas))
+	for _, id := range replicas {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+	return strings.Join(ids, ",")
+}
+
+// detectEvents
+// 每个部分与它最近一次完整采集的结果对比，获取失败的部分不产生事件，也不作为下一次的对比基准，
+// 避免把获取失败误认为删除、恢复后又误认为创建。第一次完整采集没有可以对比的结果，不产生事件
+func (m *KafkaMonitor) detectEvents() {
+	sections := []struct {
+		name string
+		diff func(last, current *Metrics) []Event
+	}{
+		{collectBrokers, diffBrokers},
+		{collectTopics, diffTopics},
+		{collectSubscribers, diffGroups},
+	}
+
+	events := make([]Event, 0)
+	for _, section := range sections {
+		if m.metrics.failed[section.name] {
+			continue
+		}
+		if base := m.eventBase[section.name]; base != nil {
+			events = append(events, section.diff(base, m.metrics)...)
+		}
+		m.eventBase[section.name] = m.metrics
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	events = eventLog.Add(events...)
+	for _, e := range events {
+		streamHub.Publish(streamMessage{kind: streamEvent, event: e})
+		logrus.Infof("cluster event %s: topic=%s group=%s broker=%s %s -> %s",
+			e.Type, e.Topic, e.Group, e.Broker, e.OldValue, e.NewValue)
+	}
+
+	if IsUseMongo() {
+		checkErr(mgoClient.SaveEvents(events))
+	}
+}
+
+func parseEventFilter(r *http.Request) EventFilter {
+	query := r.URL.Query()
+	filter := EventFilter{
+		Type:   query.Get("type"),
+		Topic:  query.Get("topic"),
+		Group:  query.Get("group"),
+		Broker: query.Get("broker"),
+		Limit:  defaultEventsLimit,
+	}
+
+	filter.Since, _ = strconv.ParseInt(query.Get("since"), 10, 64)
+	filter.After, _ = strconv.ParseInt(query.Get("after"), 10, 64)
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+	}
+	if filter.Limit > maxEvents {
+		filter.Limit = maxEvents
+	}
+	return filter
+}
+
+// handleEvents 处理 /api/events
+// 支持 type/topic/group/broker/since/after/limit 过滤参数，limit 最大为 maxEvents
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter := parseEventFilter(r)
+
+	var events []Event
+	if IsUseMongo() {
+		var err error
+		if events, err = mgoClient.FindEvents(filter); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		events = eventLog.Query(filter)
+	}
+
+	b, _ := json.Marshal(events)
+	_, _ = fmt.Fprint(w, string(b))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// eventTypes 只比较类型和对象，时间戳由 current.Timestamp 决定
+func eventTypes(events []Event) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		name := e.Type
+		for _, field := range []string{e.Topic, e.Group, e.Broker} {
+			if field != "" {
+				name += " " + field
+			}
+		}
+		if e.Partition != nil {
+			name += "/" + formatReplicas([]int32{*e.Partition})
+		}
+		if e.OldValue != "" || e.NewValue != "" {
+			name += " " + e.OldValue + "->" + e.NewValue
+		}
+		types = append(types, name)
+	}
+	return types
+}
+
+func TestDiffBrokers(t *testing.T) {
+	current := newTestMetrics()
+	current.Brokers.Members = []string{"broker2:9092", "broker3:9092"}
+	current.Brokers.Controller = "broker2:9092"
+
+	want := []string{
+		"broker_joined broker3:9092",
+		"broker_left broker1:9092",
+		"controller_moved broker2:9092 broker1:9092->broker2:9092",
+	}
+	if got := eventTypes(diffBrokers(newTestMetrics(), current)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := diffBrokers(newTestMetrics(), newTestMetrics()); len(got) != 0 {
+		t.Errorf("unchanged brokers: %v", got)
+	}
+}
+
+func TestDiffGroups(t *testing.T) {
+	members := func(ids ...string) []GroupMember {
+		items := make([]GroupMember, 0, len(ids))
+		for _, id := range ids {
+			items = append(items, GroupMember{MemberID: id})
+		}
+		return items
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *Metrics)
+		want   []string
+	}{
+		{"unchanged", func(m *Metrics) {}, []string{}},
+		{"same members rejoined", func(m *Metrics) {
+			m.Subscribers.SetGroup("billing", "Stable", members("m1"), 4)
+		}, []string{"group_rebalanced billing 1->1"}},
+		{"generation decides when known", func(m *Metrics) {
+			m.Subscribers.SetGroup("billing", "Stable", members("m2"), 3)
+		}, []string{}},
+		{"members without generation", func(m *Metrics) {
+			m.Subscribers.SetGroup("billing", "Stable", members("m1", "m2"), 0)
+		}, []string{"group_rebalanced billing 1->2"}},
+		{"emptied", func(m *Metrics) {
+			m.Subscribers.SetGroup("billing", groupStateEmpty, members(), 4)
+		}, []string{"group_emptied billing Stable->"}},
+		{"dead", func(m *Metrics) {
+			m.Subscribers.SetGroup("audit", groupStateDead, members(), 0)
+		}, []string{"group_deleted audit Empty->"}},
+		{"created", func(m *Metrics) {
+			m.Subscribers.SetGroup("reports", "Stable", members("r1"), 1)
+		}, []string{"group_created reports ->Stable"}},
+	}
+
+	for _, tt := range tests {
+		current := newTestMetrics()
+		tt.change(current)
+		if got := eventTypes(diffGroups(newTestMetrics(), current)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	last := newTestMetrics()
+	last.Subscribers.SetGroup("reports", "Stable", members("r1"), 1)
+	want := []string{"group_deleted reports Stable->"}
+	if got := eventTypes(diffGroups(last, newTestMetrics())); !reflect.DeepEqual(got, want) {
+		t.Errorf("removed group: got %q, want %q", got, want)
+	}
+}
+
+func TestDiffPartitions(t *testing.T) {
+	last := newTestMetrics().Topics.Items[0]
+	current := newTestMetrics().Topics.Items[0]
+	current.Partitions = []int32{0, 1, 2, 3}
+	current.Leaders = []int32{2, 2, 1, 1}
+	current.ISR = [][]int32{{1, 2}, {2, 3}, {1, 2}, {1}}
+
+	want := []string{
+		"partitions_added orders 3->4",
+		"leader_changed orders/0 1->2",
+		"isr_shrunk orders/1 2,1->2,3",
+		"isr_expanded orders/1 2,1->2,3",
+		"isr_expanded orders/2 1->1,2",
+	}
+	if got := eventTypes(diffPartitions(last, current, 1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffDataLoss(t *testing.T) {
+	dataLossAlert = 3600
+	tests := []struct {
+		name      string
+		last, cur TopicSubscriber
+		want      []string
+	}{
+		{"starts losing", TopicSubscriber{TimeToDataLoss: 100}, TopicSubscriber{DataLoss: true, LostMessages: 5, TimeToDataLoss: -1}, []string{"data_loss orders billing ->5"}},
+		{"still losing", TopicSubscriber{DataLoss: true}, TopicSubscriber{DataLoss: true, LostMessages: 9}, []string{}},
+		{"at risk", TopicSubscriber{TimeToDataLoss: -1}, TopicSubscriber{TimeToDataLoss: 600}, []string{"data_loss_risk orders billing -1->600"}},
+		{"still at risk", TopicSubscriber{TimeToDataLoss: 900}, TopicSubscriber{TimeToDataLoss: 600}, []string{}},
+		{"far from loss", TopicSubscriber{TimeToDataLoss: -1}, TopicSubscriber{TimeToDataLoss: 7200}, []string{}},
+	}
+
+	for _, tt := range tests {
+		last, cur := tt.last, tt.cur
+		last.GroupID, cur.GroupID = "billing", "billing"
+		got := diffDataLoss(&Topic{Name: "orders", Subscribers: []*TopicSubscriber{&last}}, &Topic{Name: "orders", Subscribers: []*TopicSubscriber{&cur}}, 1)
+		if !reflect.DeepEqual(eventTypes(got), tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, eventTypes(got), tt.want)
+		}
+	}
+
+	// 新出现的订阅者按没有风险处理
+	cur := &TopicSubscriber{GroupID: "audit", TimeToDataLoss: 60}
+	want := []string{"data_loss_risk orders audit -1->60"}
+	if got := eventTypes(diffDataLoss(&Topic{Name: "orders"}, &Topic{Name: "orders", Subscribers: []*TopicSubscriber{cur}}, 1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("new subscriber: got %q, want %q", got, want)
+	}
+}
+
+func TestDetectEventsSkipsFailedSections(t *testing.T) {
+	m := &KafkaMonitor{eventBase: make(map[string]*Metrics)}
+	detect := func(metrics *Metrics) []string {
+		last := eventLog.lastID
+		m.metrics = metrics
+		m.detectEvents()
+		return eventTypes(eventLog.Query(EventFilter{After: last, Limit: maxEvents}))
+	}
+
+	if got := detect(newTestMetrics()); len(got) != 0 {
+		t.Errorf("first snapshot: %q", got)
+	}
+
+	// topics 获取失败时 payments 不在快照中，不能当作被删除，也不能作为下一次的对比基准
+	failed := newTestMetrics()
+	failed.Topics.Items = failed.Topics.Items[:1]
+	delete(failed.Topics.filter, "payments")
+	failed.fail(collectTopics)
+	failed.Brokers.Controller = "broker2:9092"
+	if got, want := detect(failed), []string{"controller_moved broker2:9092 broker1:9092->broker2:9092"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failed topics: got %q, want %q", got, want)
+	}
+
+	recovered := newTestMetrics()
+	recovered.Brokers.Controller = "broker2:9092"
+	if got := detect(recovered); len(got) != 0 {
+		t.Errorf("recovered topics: %q", got)
+	}
+}
+
+func TestParseEventFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		limit int
+	}{
+		{"", defaultEventsLimit},
+		{"limit=5", 5},
+		{"limit=-1", defaultEventsLimit},
+		{"limit=x", defaultEventsLimit},
+		{"limit=1000000", maxEvents},
+	}
+
+	for _, tt := range tests {
+		filter := parseEventFilter(httptest.NewRequest("GET", "/api/events?"+tt.query, nil))
+		if filter.Limit != tt.limit {
+			t.Errorf("%q: limit %d, want %d", tt.query, filter.Limit, tt.limit)
+		}
+	}
+}
+
+func TestGroupGenerationsApply(t *testing.T) {
+	key := func(version uint16, group string) []byte {
+		return append([]byte{byte(version >> 8), byte(version), 0, byte(len(group))}, group...)
+	}
+	// version 3、protocol_type "consumer"、generation 7
+	value := append([]byte{0, 3, 0, 8}, "consumer"...)
+	value = append(value, 0, 0, 0, 7, 0, 5)
+
+	g := &GroupGenerations{items: make(map[string]int32)}
+	if err := g.apply(key(groupMetadataKeyVersion, "billing"), value); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Get("billing"); got != 7 {
+		t.Errorf("generation %d, want 7", got)
+	}
+
+	// offset commit 的 key 被忽略
+	if err := g.apply(key(1, "billing"), []byte{0}); err != nil {
+		t.Error(err)
+	}
+	if err := g.apply(key(groupMetadataKeyVersion, "billing"), value[:6]); err == nil {
+		t.Error("expected an error for truncated metadata")
+	}
+
+	g.loading = 1
+	if got := g.Get("billing"); got != 0 {
+		t.Errorf("generation while loading %d, want 0", got)
+	}
+	g.loading = 0
+
+	if err := g.apply(key(groupMetadataKeyVersion, "billing"), nil); err != nil {
+		t.Error(err)
+	}
+	if got := g.Get("billing"); got != 0 {
+		t.Errorf("generation after delete %d, want 0", got)
+	}
+	if got := (*GroupGenerations)(nil).Get("billing"); got != 0 {
+		t.Errorf("nil generations %d, want 0", got)
+	}
+}
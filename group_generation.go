@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	consumerOffsetsTopic = "__consumer_offsets"
+	// __consumer_offsets 中 key 版本为 2 的消息是 group 元数据，value 以 version、protocol_type、generation 开头
+	groupMetadataKeyVersion = 2
+)
+
+// GroupGenerations 从 __consumer_offsets 中读取 group 元数据，记录每个 group 当前的 generation。
+// DescribeGroups 不返回 generation，同一批成员重新加入的 rebalance 只能通过 generation 发现
+type GroupGenerations struct {
+	sync.RWMutex
+	items map[string]int32
+	// 还没有读到启动时 high watermark 的分区数，读完之前 generation 可能是旧值，不对外返回
+	loading int
+}
+
+// Get 返回 group 当前的 generation，未知或者还在读取历史消息时返回 0
+func (g *GroupGenerations) Get(group string) int32 {
+	if g == nil {
+		return 0
+	}
+	g.RLock()
+	defer g.RUnlock()
+
+	if g.loading > 0 {
+		return 0
+	}
+	return g.items[group]
+}
+
+// apply 处理一条 __consumer_offsets 消息，value 为空表示 group 已被删除
+func (g *GroupGenerations) apply(key, value []byte) error {
+	group, ok, err := decodeGroupMetadataKey(key)
+	if err != nil || !ok {
+		return err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	if value == nil {
+		delete(g.items, group)
+		return nil
+	}
+	if len(value) < 4 {
+		return fmt.Errorf("group %s: metadata too short", group)
+	}
+	n := int(binary.BigEndian.Uint16(value[2:]))
+	if len(value) < 4+n+4 {
+		return fmt.Errorf("group %s: metadata too short", group)
+	}
+	g.items[group] = int32(binary.BigEndian.Uint32(value[4+n:]))
+	return nil
+}
+
+func decodeGroupMetadataKey(key []byte) (string, bool, error) {
+	if len(key) < 4 {
+		return "", false, fmt.Errorf("consumer offsets key too short")
+	}
+	if binary.BigEndian.Uint16(key) != groupMetadataKeyVersion {
+		return "", false, nil
+	}
+	n := int(binary.BigEndian.Uint16(key[2:]))
+	if len(key) < 4+n {
+		return "", false, fmt.Errorf("consumer offsets key too short")
+	}
+	return string(key[4 : 4+n]), true, nil
+}
+
+// watchGroupGenerations 从头读取 __consumer_offsets 的全部分区并持续跟踪新消息，
+// 该 topic 是压缩的，启动时读取的历史消息数量与 group 和分区的数量相当
+func watchGroupGenerations(client sarama.Client) (*GroupGenerations, error) {
+	partitions, err := client.Partitions(consumerOffsetsTopic)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GroupGenerations{items: make(map[string]int32)}
+	for _, partition := range partitions {
+		start, err := client.GetOffset(consumerOffsetsTopic, partition, sarama.OffsetOldest)
+		if err != nil {
+			consumer.Close()
+			return nil, err
+		}
+		end, err := client.GetOffset(consumerOffsetsTopic, partition, sarama.OffsetNewest)
+		if err != nil {
+			consumer.Close()
+			return nil, err
+		}
+		pc, err := consumer.ConsumePartition(consumerOffsetsTopic, partition, sarama.OffsetOldest)
+		if err != nil {
+			consumer.Close()
+			return nil, err
+		}
+
+		loaded := start >= end
+		if !loaded {
+			g.Lock()
+			g.loading++
+			g.Unlock()
+		}
+		go func(partition int32, pc sarama.PartitionConsumer, loaded bool) {
+			for {
+				select {
+				case msg := <-pc.Messages():
+					if err := g.apply(msg.Key, msg.Value); err != nil {
+						logrus.Warnf("%s/%d offset %d: %v", consumerOffsetsTopic, partition, msg.Offset, err)
+					}
+					if !loaded && msg.Offset+1 >= end {
+						loaded = true
+						g.Lock()
+						g.loading--
+						g.Unlock()
+					}
+				case err := <-pc.Errors():
+					logrus.Warnf("consume %s/%d error: %v", consumerOffsetsTopic, partition, err)
+				}
+			}
+		}(partition, pc, loaded)
+	}
+	return g, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxHistoryPoints = 240
+	healthWindow     = 5
+
+	healthOK       = "ok"
+	healthLagging  = "lagging"
+	healthStalled  = "stalled"
+	healthInactive = "inactive"
+	healthDataLoss = "data_loss"
+)
+
+type LagPoint struct {
+	Timestamp int64 `json:"timestamp"`
+	Lag       int64 `json:"lag"`
+	Offset    int64 `json:"offset"`
+}
+
+// LagHistory 在内存中保存每个 group 以及每个 topic 最近 maxHistoryPoints 次采集的 lag，topic 的 lag 取订阅者中最大的 lag
+type LagHistory struct {
+	sync.RWMutex
+	points map[string][]LagPoint
+	topics map[string][]LagPoint
+}
+
+func (h *LagHistory) Record(metrics *Metrics) {
+	h.Lock()
+	defer h.Unlock()
+
+	points := make(map[string]LagPoint)
+	topics := make(map[string]LagPoint)
+	for _, topic := range metrics.Topics.Items {
+		t := LagPoint{Timestamp: metrics.Timestamp}
+		for _, sub := range topic.Subscribers {
+			p := points[sub.GroupID]
+			p.Timestamp = metrics.Timestamp
+			p.Lag += sub.Lag
+			p.Offset += sub.Offset
+			points[sub.GroupID] = p
+
+			if sub.Lag > t.Lag {
+				t.Lag = sub.Lag
+			}
+			t.Offset += sub.Offset
+		}
+		topics[topic.Name] = t
+	}
+
+	appendPoints(h.points, points)
+	appendPoints(h.topics, topics)
+}
+
+// appendPoints 追加本次采集的数据，已经不存在的 group 或 topic 不再保留历史
+func appendPoints(history map[string][]LagPoint, points map[string]LagPoint) {
+	for key, p := range points {
+		items := append(history[key], p)
+		if len(items) > maxHistoryPoints {
+			items = items[len(items)-maxHistoryPoints:]
+		}
+		history[key] = items
+	}
+
+	for key := range history {
+		if _, ok := points[key]; !ok {
+			delete(history, key)
+		}
+	}
+}
+
+func (h *LagHistory) Get(group string) []LagPoint {
+	h.RLock()
+	defer h.RUnlock()
+
+	return append([]LagPoint{}, h.points[group]...)
+}
+
+func (h *LagHistory) GetTopic(topic string) []LagPoint {
+	h.RLock()
+	defer h.RUnlock()
+
+	return append([]LagPoint{}, h.topics[topic]...)
+}
+
+// lagGrowth 返回最近 healthWindow 次采集中 lag 的变化量
+func lagGrowth(points []LagPoint) int64 {
+	if len(points) < 2 {
+		return 0
+	}
+	first := len(points) - healthWindow
+	if first < 0 {
+		first = 0
+	}
+	return points[len(points)-1].Lag - points[first].Lag
+}
+
+var lagHistory = &LagHistory{points: make(map[string][]LagPoint), topics: make(map[string][]LagPoint)}
+
+// groupHealth
+// data_loss: 已经有消息在消费前被删除
+// inactive: 有 lag 但没有活跃的成员
+// stalled: 有 lag 但最近几次采集 offset 没有变化
+// lagging: 最近几次采集 lag 持续增长
+func groupHealth(metrics *Metrics, group GroupResource) string {
+	for _, topic := range metrics.Topics.Items {
+		for _, sub := range topic.Subscribers {
+			if sub.GroupID == group.GroupID && sub.DataLoss {
+				return healthDataLoss
+			}
+		}
+	}
+
+	if group.Lag > 0 && len(group.Members) == 0 {
+		return healthInactive
+	}
+
+	points := lagHistory.Get(group.GroupID)
+	if len(points) < healthWindow {
+		return healthOK
+	}
+	points = points[len(points)-healthWindow:]
+
+	stalled, growing := group.Lag > 0, true
+	for i := 1; i < len(points); i++ {
+		if points[i].Offset != points[0].Offset {
+			stalled = false
+		}
+		if points[i].Lag <= points[i-1].Lag {
+			growing = false
+		}
+	}
+
+	switch {
+	case stalled:
+		return healthStalled
+	case growing:
+		return healthLagging
+	}
+	return healthOK
+}
+
+// assessGroups 在记录 lag 历史之后计算每个 group 的健康状态，保存在快照中随推送一起发送
+func (m *KafkaMonitor) assessGroups() {
+	for i := range m.metrics.Subscribers.Items {
+		sub := &m.metrics.Subscribers.Items[i]
+		sub.Health = groupHealth(m.metrics, groupResource(m.metrics, *sub))
+	}
+}
+
+// handleHistory 处理 /api/history?group={id}
+// 使用 MongoDB 时从保存的 topics 中读取，不受重启影响，查询失败或未使用 MongoDB 时返回内存中的历史
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		http.Error(w, "missing group", http.StatusBadRequest)
+		return
+	}
+
+	if IsUseMongo() {
+		points, err := mgoClient.FindGroupLag(group, maxHistoryPoints)
+		if err == nil {
+			writeJSON(w, points)
+			return
+		}
+		logrus.Warnf("find lag history of group %s error: %v", group, err)
+	}
+	writeJSON(w, lagHistory.Get(group))
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestAssessGroups(t *testing.T) {
+	m := &KafkaMonitor{metrics: newTestMetrics()}
+	orders := m.metrics.Topics.Items[0]
+	orders.Subscribers[1].DataLoss = true
+	m.metrics.Subscribers.SetGroup("billing", "Empty", []GroupMember{}, 3)
+	m.assessGroups()
+
+	want := map[string]string{"billing": healthInactive, "audit": healthDataLoss}
+	for _, sub := range m.metrics.Subscribers.Items {
+		if sub.Health != want[sub.GroupID] {
+			t.Errorf("%s: health %q, want %q", sub.GroupID, sub.Health, want[sub.GroupID])
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -16,20 +17,34 @@ const (
 	collectTopics      = "topics"
 	collectSubscribers = "subscribers"
 	collectBrokers     = "brokers"
-
-	defaultDName      = "kfk"
-	defaultBrokerID   = 1
-	defaultInterval   = 15
-	defaultBrokerAddr = "localhost:9092"
-
-	envBrokerAddr   = "BROKER_ADDR"
-	envMongoUri     = "MONGO_URI"
-	envTickInterval = "TICK_INTERVAL"
+	collectChanges     = "topic_changes"
+	collectEvents      = "events"
+
+	defaultDName          = "kfk"
+	defaultBrokerID       = 1
+	defaultInterval       = 15
+	defaultConfigInterval = 300
+	defaultDataLossAlert  = 3600
+	defaultBrokerAddr     = "localhost:9092"
+
+	envBrokerAddr       = "BROKER_ADDR"
+	envMongoUri         = "MONGO_URI"
+	envTickInterval     = "TICK_INTERVAL"
+	envConfigInterval   = "CONFIG_INTERVAL"
+	envDataLossAlert    = "DATA_LOSS_ALERT"
+	envSchemaRegistry   = "SCHEMA_REGISTRY_URL"
+	envProtoDescriptors = "PROTO_DESCRIPTORS"
+	envProtoTopics      = "PROTO_TOPICS"
+	envProtoTypeHeader  = "PROTO_TYPE_HEADER"
+	envAdminEnabled     = "ADMIN_ENABLED"
+	envProtectedTopics  = "PROTECTED_TOPICS"
 )
 
 var (
-	brokerAddr   = defaultBrokerAddr
-	tickInterval = defaultInterval
+	brokerAddr     = defaultBrokerAddr
+	tickInterval   = defaultInterval
+	configInterval = defaultConfigInterval
+	dataLossAlert  = defaultDataLossAlert
 )
 
 func init() {
@@ -41,30 +56,70 @@ func init() {
 		mongoUri = os.Getenv(envMongoUri)
 	}
 
+	schemaRegistryURL = os.Getenv(envSchemaRegistry)
+	protoDescriptorFiles = os.Getenv(envProtoDescriptors)
+	protoTopicTypes = os.Getenv(envProtoTopics)
+	if os.Getenv(envProtoTypeHeader) != "" {
+		protoTypeHeader = os.Getenv(envProtoTypeHeader)
+	}
+	adminEnabled, _ = strconv.ParseBool(os.Getenv(envAdminEnabled))
+	protectedTopics = os.Getenv(envProtectedTopics)
+
 	interval, err := strconv.Atoi(os.Getenv(envTickInterval))
 	if !(tickInterval < 0 || err != nil) {
 		tickInterval = interval
 	}
 
-	if IsUseMongo() {
-		mgoClient = NewMongoClient()
+	interval, err = strconv.Atoi(os.Getenv(envConfigInterval))
+	if err == nil && interval > 0 {
+		configInterval = interval
+	}
+
+	interval, err = strconv.Atoi(os.Getenv(envDataLossAlert))
+	if err == nil && interval >= 0 {
+		dataLossAlert = interval
 	}
 }
 
+type BrokerNode struct {
+	ID   int32  `json:"id"`
+	Addr string `json:"addr"`
+	Rack string `json:"rack"`
+}
+
 type Brokers struct {
-	Members    []string `json:"members"`
-	Controller string   `json:"controller"`
+	Members    []string       `json:"members"`
+	Nodes      []BrokerNode   `json:"nodes"`
+	Controller string         `json:"controller"`
+	Configs    []BrokerConfig `json:"configs"`
+	Drift      []ConfigDrift  `json:"drift"`
+	// 没有取到配置的 broker，不参与漂移比较
+	FailedBrokers []string `json:"failed_brokers"`
 }
 
 type TopicSubscriber struct {
-	NextOffsets []int64 `json:"next_offsets"`
-	Offset      int64   `json:"offset"`
-	GroupID     string  `json:"group_id"`
+	NextOffsets    []int64 `json:"next_offsets"`
+	Offset         int64   `json:"offset"`
+	GroupID        string  `json:"group_id"`
+	Lag            int64   `json:"lag"`
+	DataLoss       bool    `json:"data_loss"`
+	LostMessages   int64   `json:"lost_messages"`
+	TimeToDataLoss int64   `json:"time_to_data_loss"`
+}
+
+type GroupMember struct {
+	MemberID   string `json:"member_id"`
+	ClientID   string `json:"client_id"`
+	ClientHost string `json:"client_host"`
 }
 
 type Subscriber struct {
-	GroupID string   `json:"group_id"`
-	Topic   []string `json:"topics"`
+	GroupID    string        `json:"group_id"`
+	Topic      []string      `json:"topics"`
+	State      string        `json:"state"`
+	Members    []GroupMember `json:"members"`
+	Generation int32         `json:"generation"` // 0 表示未知
+	Health     string        `json:"health"`
 }
 
 type Subscribers struct {
@@ -90,12 +145,34 @@ func (s *Subscribers) Add(groupID, topic string) {
 	})
 }
 
+// SetGroup 记录 group 的状态、成员和 generation，没有成员的 group 也会被记录
+func (s *Subscribers) SetGroup(groupID, state string, members []GroupMember, generation int32) {
+	idx, ok := s.filter[groupID]
+	if !ok {
+		idx = len(s.Items)
+		s.filter[groupID] = idx
+		s.Items = append(s.Items, Subscriber{GroupID: groupID, Topic: []string{}})
+	}
+
+	s.Items[idx].State = state
+	s.Items[idx].Members = members
+	s.Items[idx].Generation = generation
+}
+
 type Topic struct {
-	Name             string             `json:"name"`
-	Partitions       []int32            `json:"partitions"`
-	Subscribers      []*TopicSubscriber `json:"subscribers"`
-	AvailableOffsets []int64            `json:"available_offsets"`
-	LogSize          int64              `json:"logsize"`
+	Name              string             `json:"name"`
+	Partitions        []int32            `json:"partitions"`
+	ReplicationFactor int                `json:"replication_factor"`
+	Leaders           []int32            `json:"leaders"`
+	ISR               [][]int32          `json:"isr"`
+	Subscribers       []*TopicSubscriber `json:"subscribers"`
+	AvailableOffsets  []int64            `json:"available_offsets"`
+	LogStartOffsets   []int64            `json:"log_start_offsets"`
+	LogSize           int64              `json:"logsize"`
+	ProduceRate       float64            `json:"produce_rate"`
+	RetentionMs       int64              `json:"retention_ms"`
+	RetentionBytes    int64              `json:"retention_bytes"`
+	Configs           []ConfigEntry      `json:"configs"`
 }
 
 type Topics struct {
@@ -133,11 +210,19 @@ type Metrics struct {
 	Subscribers
 	Topics
 	Brokers
+
+	// 本次采集中获取失败的部分（collectTopics/collectSubscribers/collectBrokers），这些部分的数据不完整
+	failed map[string]bool
+}
+
+func (m *Metrics) fail(section string) {
+	m.failed[section] = true
 }
 
 func NewMetrics() *Metrics {
 	return &Metrics{
 		Timestamp:   time.Now().Unix(),
+		failed:      make(map[string]bool),
 		Subscribers: Subscribers{filter: make(map[string]int)},
 		Topics:      Topics{filter: make(map[string]int)},
 	}
@@ -145,14 +230,50 @@ func NewMetrics() *Metrics {
 
 var currentMetrics *Metrics
 
+// Snapshot 是 /metrics 返回的一次完整采集结果
+type Snapshot struct {
+	Timestamp   int64        `json:"timestamp"`
+	Topics      []*Topic     `json:"topics"`
+	Subscribers []Subscriber `json:"subscribers"`
+	Brokers     Brokers      `json:"brokers"`
+}
+
+func NewSnapshot(metrics *Metrics) Snapshot {
+	return Snapshot{
+		Timestamp:   metrics.Timestamp,
+		Topics:      metrics.Topics.Items,
+		Subscribers: metrics.Subscribers.Items,
+		Brokers:     metrics.Brokers,
+	}
+}
+
 type KafkaMonitor struct {
 	kafkaCfg    *sarama.Config
 	kafkaClient sarama.Client
 
-	metrics *Metrics
+	metrics     *Metrics
+	lastMetrics *Metrics
+	// 每个部分最近一次完整采集的结果，作为生成集群变更事件的对比基准
+	eventBase map[string]*Metrics
 
 	groups  map[string][]string
 	brokers map[string]*sarama.Broker
+
+	// 只在 serve 模式下跟踪，为 nil 时 generation 都是未知
+	generations *GroupGenerations
+
+	topicConfigs     map[string][]ConfigEntry
+	configsUpdated   time.Time
+	configsDescribed map[string]bool
+
+	brokerConfigs        []BrokerConfig
+	brokerConfigsUpdated time.Time
+	brokerConfigsFailed  []string
+
+	recordTimes       map[string]int64
+	lastRecordTimes   map[string]int64
+	recordTimeFetches int
+	recordSizes       map[string]recordSample
 }
 
 func NewKafkaMonitor() *KafkaMonitor {
@@ -170,9 +291,13 @@ func NewKafkaMonitor() *KafkaMonitor {
 	}
 
 	return &KafkaMonitor{
-		kafkaClient: kafkaClient,
-		metrics:     NewMetrics(),
-		brokers:     bs,
+		kafkaClient:  kafkaClient,
+		metrics:      NewMetrics(),
+		brokers:      bs,
+		topicConfigs: make(map[string][]ConfigEntry),
+		eventBase:    make(map[string]*Metrics),
+		recordTimes:  make(map[string]int64),
+		recordSizes:  make(map[string]recordSample),
 	}
 }
 
@@ -187,26 +312,50 @@ func (m *KafkaMonitor) reconnectBroker(broker *sarama.Broker) error {
 	return nil
 }
 
-// Refresh
-func (m *KafkaMonitor) Refresh() {
+// refreshBrokers
+// 集群成员可能发生变化，每次刷新都从最新的 metadata 中获取 brokers
+func (m *KafkaMonitor) refreshBrokers() {
+	if err := m.kafkaClient.RefreshMetadata(); err != nil {
+		logrus.Warnf("refresh metadata error: %v", err)
+		m.metrics.fail(collectBrokers)
+	}
+
+	bs := make(map[string]*sarama.Broker)
+	for _, broker := range m.kafkaClient.Brokers() {
+		bs[broker.Addr()] = broker
+	}
+	m.brokers = bs
+
 	brokers := make([]string, 0)
-	for k := range m.brokers {
+	nodes := make([]BrokerNode, 0)
+	for k, broker := range m.brokers {
 		brokers = append(brokers, k)
+		nodes = append(nodes, BrokerNode{ID: broker.ID(), Addr: broker.Addr(), Rack: broker.Rack()})
 	}
+	sort.Strings(brokers)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
 
-	m.metrics = NewMetrics()
 	m.metrics.Brokers.Members = brokers
+	m.metrics.Brokers.Nodes = nodes
+}
+
+// Refresh 完成一次采集，找不到 controller 或主题列表时放弃本次采集，currentMetrics 保持上一次的结果
+func (m *KafkaMonitor) Refresh() error {
+	// 以上一次完整采集的结果作为对比基准
+	m.lastMetrics = currentMetrics
+	m.metrics = NewMetrics()
+	m.refreshBrokers()
 
 	controller, err := m.kafkaClient.Controller()
 	if err != nil {
-		logrus.Fatalf("could not find controller: %v", err)
+		return fmt.Errorf("could not find controller: %v", err)
 	}
 	m.metrics.Brokers.Controller = controller.Addr()
+	m.refreshBrokerConfigs()
 
 	topics, err := m.kafkaClient.Topics()
 	if err != nil {
-		logrus.Warnf("could not find topics: %v", err)
-		return
+		return fmt.Errorf("could not find topics: %v", err)
 	}
 
 	for i := 0; i < len(topics); i++ {
@@ -217,17 +366,44 @@ func (m *KafkaMonitor) Refresh() {
 		partitions, err := m.kafkaClient.Partitions(topics[i])
 		if err != nil {
 			logrus.Warnf("could not find partition: %v", err)
+			m.metrics.fail(collectTopics)
 			continue
 		}
 
+		replicationFactor := 0
+		if len(partitions) > 0 {
+			replicas, err := m.kafkaClient.Replicas(topics[i], partitions[0])
+			if err != nil {
+				logrus.Warnf("could not find replicas: %v", err)
+			}
+			replicationFactor = len(replicas)
+		}
+
+		leaders := make([]int32, 0, len(partitions))
+		isr := make([][]int32, 0, len(partitions))
+		for _, partition := range partitions {
+			leaderID := int32(-1)
+			if leader, err := m.kafkaClient.Leader(topics[i], partition); err == nil {
+				leaderID = leader.ID()
+			}
+			leaders = append(leaders, leaderID)
+
+			replicas, _ := m.kafkaClient.InSyncReplicas(topics[i], partition)
+			isr = append(isr, replicas)
+		}
+
 		m.metrics.Topics.AddItem(&Topic{
-			Name:        topics[i],
-			Partitions:  partitions,
-			Subscribers: []*TopicSubscriber{},
+			Name:              topics[i],
+			Partitions:        partitions,
+			ReplicationFactor: replicationFactor,
+			Leaders:           leaders,
+			ISR:               isr,
+			Subscribers:       []*TopicSubscriber{},
 		})
 	}
 
 	m.refreshConsumerGroups()
+	return nil
 }
 
 // refreshConsumerGroups
@@ -236,12 +412,14 @@ func (m *KafkaMonitor) refreshConsumerGroups() {
 
 	for _, broker := range m.brokers {
 		if err := m.reconnectBroker(broker); err != nil {
+			m.metrics.fail(collectSubscribers)
 			continue
 		}
 
 		resp, err := broker.ListGroups(&sarama.ListGroupsRequest{})
 		if err != nil {
 			logrus.Warnf("listGroups error : %v", err)
+			m.metrics.fail(collectSubscribers)
 			continue
 		}
 
@@ -259,22 +437,27 @@ func (m *KafkaMonitor) refreshConsumerGroups() {
 
 // refreshAvailableOffsets
 // AvailableOffset 表示一个主题的消息总量
+// LogStartOffset 表示分区中尚未被 retention 删除的最早消息的 offset
 func (m *KafkaMonitor) refreshAvailableOffsets() {
 	for i := 0; i < len(m.metrics.Topics.Items); i++ {
 		topic := m.metrics.Topics.Items[i]
 		for j := 0; j < len(topic.Partitions); j++ {
-			offset, _ := m.kafkaClient.GetOffset(topic.Name, topic.Partitions[j], -1)
+			offset, _ := m.kafkaClient.GetOffset(topic.Name, topic.Partitions[j], sarama.OffsetNewest)
 			topic.AvailableOffsets = append(topic.AvailableOffsets, offset)
+
+			start, _ := m.kafkaClient.GetOffset(topic.Name, topic.Partitions[j], sarama.OffsetOldest)
+			topic.LogStartOffsets = append(topic.LogStartOffsets, start)
 		}
 	}
 
-	m.refreshSubscriber()
+	m.refreshTopicConfigs()
 }
 
 // refreshSubscriber
 func (m *KafkaMonitor) refreshSubscriber() {
 	for _, broker := range m.brokers {
 		if err := m.reconnectBroker(broker); err != nil {
+			m.metrics.fail(collectSubscribers)
 			continue
 		}
 
@@ -285,11 +468,23 @@ func (m *KafkaMonitor) refreshSubscriber() {
 
 		if err != nil {
 			logrus.Warnf("describe groups error: %v", err)
+			m.metrics.fail(collectSubscribers)
 			continue
 		}
 
 		// broker: groups -> 1 : N
 		for i := 0; i < len(resp.Groups); i++ {
+			members := make([]GroupMember, 0, len(resp.Groups[i].Members))
+			for memberID, member := range resp.Groups[i].Members {
+				members = append(members, GroupMember{
+					MemberID:   memberID,
+					ClientID:   member.ClientId,
+					ClientHost: member.ClientHost,
+				})
+			}
+			sort.Slice(members, func(a, b int) bool { return members[a].MemberID < members[b].MemberID })
+			m.metrics.Subscribers.SetGroup(resp.Groups[i].GroupId, resp.Groups[i].State, members, m.generations.Get(resp.Groups[i].GroupId))
+
 			// group: members -> 1 : N
 			for _, member := range resp.Groups[i].Members {
 				metadata, err := member.GetMemberMetadata()
@@ -353,19 +548,32 @@ func (m *KafkaMonitor) summary() {
 		m.metrics.Topics.Items[i].LogSize = sumAvailable
 
 		// topic Next offset -> Offset
+		// Available offset - Next offset -> Lag
 		for _, item := range topic.Subscribers {
 			sumNext := int64(0)
+			sumLag := int64(0)
 
 			for j := 0; j < len(item.NextOffsets); j++ {
 				if item.NextOffsets[j] != -1 {
 					sumNext += item.NextOffsets[j]
+					if len(item.NextOffsets) == len(topic.AvailableOffsets) {
+						sumLag += topic.AvailableOffsets[j] - item.NextOffsets[j]
+					}
 				}
 			}
 			item.Offset = sumNext
+			item.Lag = sumLag
 		}
 	}
 
+	m.detectDataLoss()
+	m.detectTopicChanges()
+	m.detectEvents()
+
+	lagHistory.Record(m.metrics)
+	m.assessGroups()
 	currentMetrics = m.metrics
+	streamHub.Publish(streamMessage{kind: streamSnapshot, metrics: m.metrics})
 	m.saveRecords()
 }
 
@@ -383,27 +591,50 @@ func checkErr(err error) {
 	}
 }
 
-func main() {
+// serve 持续采集集群信息并启动 HTTP 服务，只有 serve 模式才会写入 MongoDB
+func serve() {
+	if mongoUri != "" {
+		mgoClient = NewMongoClient()
+		eventLog.SetLastID(mgoClient.LastEventID())
+	}
+
 	monitor := NewKafkaMonitor()
-	monitor.Refresh()
+	generations, err := watchGroupGenerations(monitor.kafkaClient)
+	if err != nil {
+		logrus.Warnf("watch group generations error, detect rebalances by members: %v", err)
+	}
+	monitor.generations = generations
+	if err := monitor.Refresh(); err != nil {
+		logrus.Fatal(err)
+	}
 
 	handleMetrics := func(w http.ResponseWriter, r *http.Request) {
-		m := struct {
-			Timestamp   int64        `json:"timestamp"`
-			Topics      []*Topic     `json:"topics"`
-			Subscribers []Subscriber `json:"subscribers"`
-			Brokers     Brokers      `json:"brokers"`
-		}{
-			Timestamp:   currentMetrics.Timestamp,
-			Topics:      currentMetrics.Topics.Items,
-			Subscribers: currentMetrics.Subscribers.Items,
-			Brokers:     currentMetrics.Brokers,
-		}
-		b, _ := json.Marshal(m)
+		b, _ := json.Marshal(NewSnapshot(currentMetrics))
+		_, _ = fmt.Fprint(w, string(b))
+	}
+
+	handleDrift := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := json.Marshal(DriftReport{Drift: currentMetrics.Brokers.Drift, FailedBrokers: currentMetrics.Brokers.FailedBrokers})
 		_, _ = fmt.Fprint(w, string(b))
 	}
 
 	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/drift", handleDrift)
+	http.HandleFunc("/api/topics/", handleTopicChanges)
+	http.HandleFunc("/api/events", handleEvents)
+	http.HandleFunc("/api/stream", handleSSE)
+	http.HandleFunc("/api/ws", handleWebSocket)
+	http.HandleFunc("/api/v1/", handleAPIv1)
+	http.HandleFunc("/api/history", handleHistory)
+	http.HandleFunc("/api/query", handleQuery)
+	http.HandleFunc("/api/messages", handleMessages(monitor.kafkaClient))
+	http.HandleFunc("/api/search", handleSearch(monitor.kafkaClient))
+	http.HandleFunc("/api/tail", handleTail(monitor.kafkaClient))
+	http.HandleFunc("/api/produce", handleProduce())
+	http.HandleFunc("/api/offsets", handleOffsets(monitor.kafkaClient))
+	http.HandleFunc("/api/admin/topics", handleAdminTopics(monitor.kafkaClient))
+	http.HandleFunc("/api/admin/topics/", handleAdminTopics(monitor.kafkaClient))
+	http.HandleFunc("/", handleDashboard)
 
 	go func() {
 		logrus.Fatal(http.ListenAndServe(":3300", nil))
@@ -414,6 +645,12 @@ func main() {
 		if IsUseMongo() {
 			mgoClient.PingMongo()
 		}
-		monitor.Refresh()
+		if err := monitor.Refresh(); err != nil {
+			logrus.Warnf("skip this tick: %v", err)
+		}
 	}
 }
+
+func main() {
+	runCommand(os.Args[1:])
+}
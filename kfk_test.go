@@ -0,0 +1,44 @@
+package main
+
+// newTestMetrics 构造一个不依赖 kafka 的快照：
+// orders 有 3 个分区，被 billing 和 audit 订阅；payments 有 1 个分区，没有订阅者
+func newTestMetrics() *Metrics {
+	metrics := NewMetrics()
+	metrics.Timestamp = 1560000000
+	metrics.Brokers.Members = []string{"broker1:9092", "broker2:9092"}
+	metrics.Brokers.Nodes = []BrokerNode{{ID: 1, Addr: "broker1:9092"}, {ID: 2, Addr: "broker2:9092"}}
+	metrics.Brokers.Controller = "broker1:9092"
+
+	metrics.Topics.AddItem(&Topic{
+		Name:              "orders",
+		Partitions:        []int32{0, 1, 2},
+		ReplicationFactor: 2,
+		Leaders:           []int32{1, 2, 1},
+		ISR:               [][]int32{{1, 2}, {2, 1}, {1}},
+		AvailableOffsets:  []int64{100, 200, 300},
+		LogStartOffsets:   []int64{0, 0, 50},
+		LogSize:           600,
+		RetentionMs:       604800000,
+		Subscribers: []*TopicSubscriber{
+			{GroupID: "billing", NextOffsets: []int64{90, 200, 250}, Offset: 540, Lag: 60, TimeToDataLoss: -1},
+			{GroupID: "audit", NextOffsets: []int64{100, 200, -1}, Offset: 300, Lag: 0, TimeToDataLoss: -1},
+		},
+	})
+	metrics.Topics.AddItem(&Topic{
+		Name:              "payments",
+		Partitions:        []int32{0},
+		ReplicationFactor: 1,
+		Leaders:           []int32{2},
+		ISR:               [][]int32{{2}},
+		AvailableOffsets:  []int64{10},
+		LogStartOffsets:   []int64{0},
+		LogSize:           10,
+		Subscribers:       []*TopicSubscriber{},
+	})
+
+	metrics.Subscribers.Add("billing", "orders")
+	metrics.Subscribers.SetGroup("billing", "Stable", []GroupMember{{MemberID: "m1", ClientID: "billing-1", ClientHost: "/10.0.0.1"}}, 3)
+	metrics.Subscribers.Add("audit", "orders")
+	metrics.Subscribers.SetGroup("audit", "Empty", []GroupMember{}, 0)
+	return metrics
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// LineEditor 提供带历史记录和 tab 补全的行编辑，stdin 不是终端时退化为逐行读取
+type LineEditor struct {
+	Prompt   string
+	History  []string
+	Complete func(line string) []string
+
+	in     *bufio.Reader
+	out    io.Writer
+	line   []rune
+	pos    int
+	hIndex int
+}
+
+func NewLineEditor(prompt string) *LineEditor {
+	return &LineEditor{Prompt: prompt, in: stdinReader, out: os.Stdout}
+}
+
+func (e *LineEditor) AddHistory(line string) {
+	if line == "" || (len(e.History) > 0 && e.History[len(e.History)-1] == line) {
+		return
+	}
+	e.History = append(e.History, line)
+}
+
+// ReadLine 读取一行输入，Ctrl-C 返回 errInterrupted，空行上的 Ctrl-D 返回 io.EOF
+func (e *LineEditor) ReadLine() (string, error) {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		_, _ = fmt.Fprint(e.out, e.Prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer holdTerminal(restore)()
+
+	e.line, e.pos, e.hIndex = nil, 0, len(e.History)
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			_, _ = fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case 3: // Ctrl-C
+			_, _ = fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				_, _ = fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos)
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.line)
+		case 2: // Ctrl-B
+			e.move(-1)
+		case 6: // Ctrl-F
+			e.move(1)
+		case 11: // Ctrl-K
+			e.line = e.line[:e.pos]
+		case 21: // Ctrl-U
+			e.line, e.pos = e.line[e.pos:], 0
+		case 23: // Ctrl-W
+			start := e.pos
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.line, e.pos = append(e.line[:start], e.line[e.pos:]...), start
+		case 12: // Ctrl-L
+			_, _ = fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			e.historyMove(-1)
+		case 14: // Ctrl-N
+			e.historyMove(1)
+		case 127, 8: // Backspace
+			if e.pos > 0 {
+				e.pos--
+				e.delete(e.pos)
+			}
+		case '\t':
+			e.complete()
+		case 27:
+			e.escape()
+		default:
+			if r >= 32 && r != utf8.RuneError {
+				e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		e.refresh()
+	}
+}
+
+// escape 处理方向键等 ESC [ 开头的控制序列
+func (e *LineEditor) escape() {
+	if b, _ := e.in.ReadByte(); b != '[' && b != 'O' {
+		return
+	}
+
+	seq := make([]byte, 0, 4)
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7E {
+			break
+		}
+	}
+
+	switch string(seq) {
+	case "A":
+		e.historyMove(-1)
+	case "B":
+		e.historyMove(1)
+	case "C":
+		e.move(1)
+	case "D":
+		e.move(-1)
+	case "H", "1~":
+		e.pos = 0
+	case "F", "4~":
+		e.pos = len(e.line)
+	case "3~":
+		e.delete(e.pos)
+	}
+}
+
+func (e *LineEditor) move(n int) {
+	if pos := e.pos + n; pos >= 0 && pos <= len(e.line) {
+		e.pos = pos
+	}
+}
+
+func (e *LineEditor) delete(pos int) {
+	if pos < len(e.line) {
+		e.line = append(e.line[:pos], e.line[pos+1:]...)
+	}
+}
+
+func (e *LineEditor) historyMove(n int) {
+	idx := e.hIndex + n
+	if idx < 0 || idx > len(e.History) {
+		return
+	}
+
+	e.hIndex = idx
+	if idx == len(e.History) {
+		e.line = nil
+	} else {
+		e.line = []rune(e.History[idx])
+	}
+	e.pos = len(e.line)
+}
+
+// complete 补全光标前的单词，有多个候选时补全公共前缀，无法继续补全时列出候选
+func (e *LineEditor) complete() {
+	if e.Complete == nil {
+		return
+	}
+
+	head := string(e.line[:e.pos])
+	candidates := e.Complete(head)
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := head[strings.LastIndex(head, " ")+1:]
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+
+	if len(prefix) > len(word) {
+		insert := []rune(prefix[len(word):])
+		e.line = append(e.line[:e.pos], append(insert, e.line[e.pos:]...)...)
+		e.pos += len(insert)
+		return
+	}
+
+	_, _ = fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+func (e *LineEditor) refresh() {
+	_, _ = fmt.Fprintf(e.out, "\r\x1b[K%s%s\r", e.Prompt, string(e.line))
+	if n := utf8.RuneCountInString(e.Prompt) + e.pos; n > 0 {
+		_, _ = fmt.Fprintf(e.out, "\x1b[%dC", n)
+	}
+}
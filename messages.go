@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	defaultRecordCount  = 20
+	maxRecordCount      = 500
+	maxRecordFieldBytes = 64 * 1024
+	maxRecordsBytes     = 4 * 1024 * 1024
+	fetchTimeout        = 3 * time.Second
+
+	decodeUTF8   = "utf8"
+	decodeJSON   = "json"
+	decodeHex    = "hex"
+	decodeBase64 = "base64"
+
+	startOffset    = "offset"
+	startTimestamp = "timestamp"
+	startGroup     = "group"
+	startTail      = "tail"
+)
+
+type RecordHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Record 是解码后的消息，key 或 value 超过 maxRecordFieldBytes 时会被截断
+type Record struct {
+	Topic       string         `json:"topic"`
+	Partition   int32          `json:"partition"`
+	Offset      int64          `json:"offset"`
+	Timestamp   time.Time      `json:"timestamp"`
+	Key         string         `json:"key"`
+	Value       string         `json:"value"`
+	Headers     []RecordHeader `json:"headers"`
+	KeySize     int            `json:"key_size"`
+	ValueSize   int            `json:"value_size"`
+	Truncated   bool           `json:"truncated,omitempty"`
+	DecodeError string         `json:"decode_error,omitempty"`
+}
+
+// FetchRequest 描述从一个分区读取消息的位置和数量
+type FetchRequest struct {
+	Topic     string
+	Partition int32
+	Start     string
+	Offset    int64
+	Timestamp int64 // 毫秒
+	Group     string
+	Count     int
+	Decode    string
+}
+
+func (req *FetchRequest) Validate() error {
+	if req.Topic == "" {
+		return fmt.Errorf("missing topic")
+	}
+	if req.Count <= 0 {
+		req.Count = defaultRecordCount
+	}
+	if req.Count > maxRecordCount {
+		return fmt.Errorf("count must not exceed %d", maxRecordCount)
+	}
+
+	if req.Decode == "" {
+		req.Decode = decodeUTF8
+	}
+	if err := validateDecoder(req.Decode); err != nil {
+		return err
+	}
+
+	switch req.Start {
+	case "":
+		req.Start = startTail
+	case startOffset, startTimestamp, startTail:
+	case startGroup:
+		if req.Group == "" {
+			return fmt.Errorf("missing group")
+		}
+	default:
+		return fmt.Errorf("unknown start %q, expected one of offset/timestamp/group/tail", req.Start)
+	}
+	return nil
+}
+
+// parseTimestamp 支持 RFC3339 和毫秒时间戳
+func parseTimestamp(s string) (int64, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q, expected RFC3339 or milliseconds", s)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+func validateDecoder(decode string) error {
+	switch decode {
+	case decodeUTF8, decodeJSON, decodeHex, decodeBase64, decodeAvro, decodeProtobuf:
+		return nil
+	}
+	if isProtobufDecoder(decode) && decode != decodeProtobuf+":" {
+		return nil
+	}
+	return fmt.Errorf("unknown decoder %q, expected one of utf8/json/hex/base64/avro/protobuf[:type]", decode)
+}
+
+// decodeBytes 按指定方式解码，json 解码失败时退化为 utf8
+func decodeBytes(b []byte, decode string) string {
+	switch decode {
+	case decodeHex:
+		return hex.EncodeToString(b)
+	case decodeBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	case decodeJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "  "); err == nil {
+			return buf.String()
+		}
+	}
+
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return string(bytes.Runes(b))
+}
+
+func newRecord(msg *sarama.ConsumerMessage, decode string) Record {
+	record := Record{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		KeySize:   len(msg.Key),
+		ValueSize: len(msg.Value),
+		Headers:   make([]RecordHeader, 0, len(msg.Headers)),
+	}
+
+	truncate := func(b []byte) []byte {
+		if len(b) > maxRecordFieldBytes {
+			record.Truncated = true
+			return b[:maxRecordFieldBytes]
+		}
+		return b
+	}
+
+	// key 和 header 通常是字符串，只有 value 使用指定的解码方式
+	record.Key = decodeBytes(truncate(msg.Key), decodeUTF8)
+	switch {
+	case isProtobufDecoder(decode):
+		value, err := decodeProtobufValue(msg, decode)
+		if err != nil {
+			record.DecodeError = err.Error()
+			value = decodeBytes(msg.Value, decodeHex)
+		}
+		record.Value = string(truncate([]byte(value)))
+	case decode == decodeAvro:
+		// Avro 需要完整的数据才能解码，解码后再截断；解码失败时以 hex 输出
+		if isAvroFramed(msg.Key) {
+			if key, err := decodeAvroBytes(msg.Key); err == nil {
+				record.Key = string(truncate([]byte(key)))
+			}
+		}
+
+		value, err := decodeAvroBytes(msg.Value)
+		if err != nil {
+			record.DecodeError = err.Error()
+			value = decodeBytes(msg.Value, decodeHex)
+		}
+		record.Value = string(truncate([]byte(value)))
+	default:
+		record.Value = decodeBytes(truncate(msg.Value), decode)
+	}
+	for _, h := range msg.Headers {
+		record.Headers = append(record.Headers, RecordHeader{Key: string(h.Key), Value: decodeBytes(truncate(h.Value), decodeUTF8)})
+	}
+	return record
+}
+
+// committedOffset 返回 group 在分区上已提交的 offset，没有提交过时返回 -1
+func committedOffset(client sarama.Client, group, topic string, partition int32) (int64, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return 0, err
+	}
+
+	req := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: group}
+	req.AddPartition(topic, partition)
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return 0, err
+	}
+
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return 0, fmt.Errorf("no offset for %s/%d in group %s", topic, partition, group)
+	}
+	if block.Err != sarama.ErrNoError {
+		return 0, block.Err
+	}
+	return block.Offset, nil
+}
+
+// startOffsetOf 返回读取的起始 offset 以及分区当前的 log end offset
+func startOffsetOf(client sarama.Client, req FetchRequest) (int64, int64, error) {
+	newest, err := client.GetOffset(req.Topic, req.Partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	oldest, err := client.GetOffset(req.Topic, req.Partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := newest - int64(req.Count)
+	switch req.Start {
+	case startOffset:
+		start = req.Offset
+	case startTimestamp:
+		// 没有不早于该时间的消息时返回 -1
+		if start, err = client.GetOffset(req.Topic, req.Partition, req.Timestamp); err != nil {
+			return 0, 0, err
+		}
+		if start == -1 {
+			start = newest
+		}
+	case startGroup:
+		if start, err = committedOffset(client, req.Group, req.Topic, req.Partition); err != nil {
+			return 0, 0, err
+		}
+		if start == -1 {
+			return 0, 0, fmt.Errorf("group %s has no committed offset on %s/%d", req.Group, req.Topic, req.Partition)
+		}
+	}
+
+	if start < oldest {
+		start = oldest
+	}
+	return start, newest, nil
+}
+
+// fetchRecords 不加入 consumer group 读取消息，读取到 log end、达到数量或总大小超过 maxRecordsBytes 时停止
+func fetchRecords(client sarama.Client, req FetchRequest) ([]Record, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	start, newest, err := startOffsetOf(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0)
+	if start >= newest {
+		return records, nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	pc, err := consumer.ConsumePartition(req.Topic, req.Partition, start)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	size := 0
+	for len(records) < req.Count && size < maxRecordsBytes {
+		select {
+		case msg := <-pc.Messages():
+			record := newRecord(msg, req.Decode)
+			records = append(records, record)
+			size += len(record.Key) + len(record.Value)
+			if msg.Offset+1 >= newest {
+				return records, nil
+			}
+		case err := <-pc.Errors():
+			return records, err
+		case <-time.After(fetchTimeout):
+			return records, nil
+		}
+	}
+	return records, nil
+}
+
+// parseFetchRequest 解析 /api/messages 的查询参数
+func parseFetchRequest(query url.Values) (FetchRequest, error) {
+	req := FetchRequest{Topic: query.Get("topic"), Decode: query.Get("decode"), Group: query.Get("group")}
+
+	if v := query.Get("partition"); v != "" {
+		p, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return req, fmt.Errorf("invalid partition: %s", v)
+		}
+		req.Partition = int32(p)
+	}
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid count: %s", v)
+		}
+		req.Count = n
+	}
+
+	switch {
+	case query.Get("offset") != "":
+		offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+		if err != nil {
+			return req, fmt.Errorf("invalid offset: %s", query.Get("offset"))
+		}
+		req.Start, req.Offset = startOffset, offset
+	case query.Get("timestamp") != "":
+		ts, err := parseTimestamp(query.Get("timestamp"))
+		if err != nil {
+			return req, err
+		}
+		req.Start, req.Timestamp = startTimestamp, ts
+	case req.Group != "":
+		req.Start = startGroup
+	}
+	return req, req.Validate()
+}
+
+// handleMessages 处理 /api/messages?topic=&partition=&offset=|timestamp=|group=&count=&decode=，不指定起始位置时读取最后 count 条
+func handleMessages(client sarama.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseFetchRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		records, err := fetchRecords(client, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, records)
+	}
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ", "\t", " ").Replace(s)
+}
+
+func printRecords(w io.Writer, format string, records []Record) error {
+	table := Table{Headers: []string{"partition", "offset", "timestamp", "key", "headers", "value"}}
+	for _, r := range records {
+		headers := make([]string, 0, len(r.Headers))
+		for _, h := range r.Headers {
+			headers = append(headers, h.Key+"="+h.Value)
+		}
+		table.Append(r.Partition, r.Offset, r.Timestamp.Format(time.RFC3339), oneLine(r.Key), oneLine(strings.Join(headers, ",")), oneLine(r.Value))
+	}
+	return printOutput(w, format, table, records)
+}
+
+// decoderFlags 添加 -registry 和 -protos 参数，默认使用 SCHEMA_REGISTRY_URL 和 PROTO_DESCRIPTORS 环境变量
+func decoderFlags(fs *cliFlags) {
+	fs.StringVar(&schemaRegistryURL, "registry", schemaRegistryURL, "schema registry URL used by -decode avro")
+	fs.StringVar(&protoDescriptorFiles, "protos", protoDescriptorFiles, "comma separated FileDescriptorSet files used by -decode protobuf")
+}
+
+// matchable 返回用于过滤的内容，Avro 数据先解码为 JSON
+func matchable(b []byte, decode string) []byte {
+	if decode == decodeAvro && isAvroFramed(b) {
+		if s, err := decodeAvroBytes(b); err == nil {
+			return []byte(s)
+		}
+	}
+	return b
+}
+
+// matchableValue 返回用于过滤的 value，protobuf 需要根据 topic 和 header 确定类型
+func matchableValue(msg *sarama.ConsumerMessage, decode string) []byte {
+	if isProtobufDecoder(decode) {
+		if s, err := decodeProtobufValue(msg, decode); err == nil {
+			return []byte(s)
+		}
+		return msg.Value
+	}
+	return matchable(msg.Value, decode)
+}
+
+// printRecordLine 逐条输出消息，json 格式每行一个对象，其他格式每行以 tab 分隔
+func printRecordLine(w io.Writer, format string, r Record) error {
+	if format == outputJSON {
+		b, _ := json.Marshal(r)
+		_, err := fmt.Fprintln(w, string(b))
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", r.Partition, r.Offset, r.Timestamp.Format(time.RFC3339), oneLine(r.Key), oneLine(r.Value))
+	return err
+}
+
+func runPeek(args []string) {
+	fs := newFlagSet("peek")
+	partition := fs.Int("p", 0, "partition")
+	offset := fs.Int64("offset", -1, "start at this offset")
+	timestamp := fs.String("time", "", "start at the first record not earlier than this time (RFC3339 or milliseconds)")
+	group := fs.String("group", "", "start at the committed offset of this group")
+	count := fs.Int("n", defaultRecordCount, fmt.Sprintf("number of records, at most %d", maxRecordCount))
+	decoderFlags(fs)
+	decode := fs.String("decode", decodeUTF8, "value decoder: utf8, json, hex, base64, avro or protobuf[:type]")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+
+	req := FetchRequest{Topic: topic, Partition: int32(*partition), Group: *group, Count: *count, Decode: *decode}
+	switch {
+	case *offset >= 0:
+		req.Start, req.Offset = startOffset, *offset
+	case *timestamp != "":
+		ts, err := parseTimestamp(*timestamp)
+		exitOnError(err)
+		req.Start, req.Timestamp = startTimestamp, ts
+	case *group != "":
+		req.Start = startGroup
+	}
+
+	monitor := NewKafkaMonitor()
+	records, err := fetchRecords(monitor.kafkaClient, req)
+	exitOnError(err)
+	exitOnError(printRecords(os.Stdout, fs.output, records))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestParseFetchRequest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  FetchRequest
+		err   string
+	}{
+		{"topic=orders", FetchRequest{Topic: "orders", Start: startTail, Count: defaultRecordCount, Decode: decodeUTF8}, ""},
+		{"topic=orders&partition=2&offset=10&count=5&decode=hex", FetchRequest{Topic: "orders", Partition: 2, Start: startOffset, Offset: 10, Count: 5, Decode: decodeHex}, ""},
+		{"topic=orders&timestamp=1560000000000", FetchRequest{Topic: "orders", Start: startTimestamp, Timestamp: 1560000000000, Count: defaultRecordCount, Decode: decodeUTF8}, ""},
+		{"topic=orders&timestamp=2019-06-08T13:20:00Z", FetchRequest{Topic: "orders", Start: startTimestamp, Timestamp: 1560000000000, Count: defaultRecordCount, Decode: decodeUTF8}, ""},
+		{"topic=orders&group=billing&decode=protobuf:shop.Order", FetchRequest{Topic: "orders", Start: startGroup, Group: "billing", Count: defaultRecordCount, Decode: "protobuf:shop.Order"}, ""},
+		{"partition=1", FetchRequest{}, "missing topic"},
+		{"topic=orders&partition=x", FetchRequest{}, "invalid partition: x"},
+		{"topic=orders&offset=x", FetchRequest{}, "invalid offset: x"},
+		{"topic=orders&count=501", FetchRequest{}, "count must not exceed 500"},
+		{"topic=orders&timestamp=yesterday", FetchRequest{}, "invalid timestamp \"yesterday\", expected RFC3339 or milliseconds"},
+		{"topic=orders&decode=xml", FetchRequest{}, "unknown decoder \"xml\", expected one of utf8/json/hex/base64/avro/protobuf[:type]"},
+		{"topic=orders&decode=protobuf:", FetchRequest{}, "unknown decoder \"protobuf:\", expected one of utf8/json/hex/base64/avro/protobuf[:type]"},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		got, err := parseFetchRequest(query)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseFetchRequest(%q) error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFetchRequest(%q): %v", tt.query, err)
+		} else if got != tt.want {
+			t.Errorf("parseFetchRequest(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFetchRequestValidate(t *testing.T) {
+	tests := []struct {
+		req FetchRequest
+		err string
+	}{
+		{FetchRequest{Topic: "orders", Start: startGroup}, "missing group"},
+		{FetchRequest{Topic: "orders", Start: "latest"}, "unknown start \"latest\", expected one of offset/timestamp/group/tail"},
+		{FetchRequest{Topic: "orders", Count: -1}, ""},
+	}
+
+	for _, tt := range tests {
+		err := tt.req.Validate()
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.req, err, tt.err)
+		}
+	}
+}
+
+func TestDecodeBytes(t *testing.T) {
+	tests := []struct {
+		input  []byte
+		decode string
+		want   string
+	}{
+		{[]byte("hello"), decodeUTF8, "hello"},
+		{[]byte{0xde, 0xad}, decodeHex, "dead"},
+		{[]byte("hi"), decodeBase64, "aGk="},
+		{[]byte(`{"a":1}`), decodeJSON, "{\n  \"a\": 1\n}"},
+		{[]byte("not json"), decodeJSON, "not json"},
+		{[]byte{'a', 0xff, 'b'}, decodeUTF8, "a�b"},
+	}
+
+	for _, tt := range tests {
+		if got := decodeBytes(tt.input, tt.decode); got != tt.want {
+			t.Errorf("decodeBytes(%q, %s) = %q, want %q", tt.input, tt.decode, got, tt.want)
+		}
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 1,
+		Offset:    42,
+		Timestamp: time.Unix(1560000000, 0),
+		Key:       []byte("k1"),
+		Value:     bytes.Repeat([]byte{'v'}, maxRecordFieldBytes+1),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}},
+	}
+
+	r := newRecord(msg, decodeUTF8)
+	if r.Key != "k1" || len(r.Value) != maxRecordFieldBytes || r.ValueSize != maxRecordFieldBytes+1 || !r.Truncated {
+		t.Errorf("record key = %q, value %d bytes of %d, truncated %v", r.Key, len(r.Value), r.ValueSize, r.Truncated)
+	}
+	if len(r.Headers) != 1 || r.Headers[0] != (RecordHeader{Key: "trace", Value: "abc"}) {
+		t.Errorf("headers = %v", r.Headers)
+	}
+
+	var buf bytes.Buffer
+	r.Value = "a\nb"
+	if err := printRecordLine(&buf, outputTable, r); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\t42\t" + r.Timestamp.Format(time.RFC3339) + "\tk1\ta b\n"; buf.String() != want {
+		t.Errorf("printRecordLine = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	_ = printRecordLine(&buf, outputJSON, r)
+	if !strings.HasPrefix(buf.String(), `{"topic":"orders","partition":1,"offset":42,`) {
+		t.Errorf("printRecordLine json = %s", buf.String())
+	}
+}
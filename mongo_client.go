@@ -12,7 +12,7 @@ var (
 )
 
 func IsUseMongo() bool {
-	return mongoUri != ""
+	return mgoClient != nil
 }
 
 type MongoClient struct {
@@ -32,12 +32,18 @@ func (m *MongoClient) SaveTopics(topics []*Topic, timestamp int64) (err error) {
 	coll := m.sess.DB(defaultDName).C(collectTopics)
 	for i := 0; i < len(topics); i++ {
 		if err = coll.Insert(bson.M{
-			"timestamp":         timestamp,
-			"name":              topics[i].Name,
-			"partitions":        topics[i].Partitions,
-			"subscribers":       topics[i].Subscribers,
-			"available_offsets": topics[i].AvailableOffsets,
-			"logsize":           topics[i].LogSize,
+			"timestamp":          timestamp,
+			"name":               topics[i].Name,
+			"partitions":         topics[i].Partitions,
+			"replication_factor": topics[i].ReplicationFactor,
+			"leaders":            topics[i].Leaders,
+			"isr":                topics[i].ISR,
+			"subscribers":        topics[i].Subscribers,
+			"available_offsets":  topics[i].AvailableOffsets,
+			"log_start_offsets":  topics[i].LogStartOffsets,
+			"logsize":            topics[i].LogSize,
+			"produce_rate":       topics[i].ProduceRate,
+			"configs":            topics[i].Configs,
 		}); err != nil {
 			return
 		}
@@ -54,6 +60,8 @@ func (m *MongoClient) SaveSubscriber(subscriber []Subscriber, timestamp int64) (
 				"timestamp": timestamp,
 				"group_id":  subscriber[i].GroupID,
 				"topics":    subscriber[i].Topic,
+				"state":     subscriber[i].State,
+				"members":   subscriber[i].Members,
 			},
 		)
 		if err != nil {
@@ -67,15 +75,115 @@ func (m *MongoClient) SaveBrokers(brokers Brokers, timestamp int64) error {
 	_, err := m.sess.DB(defaultDName).C(collectBrokers).Upsert(
 		bson.M{"_id": defaultBrokerID},
 		bson.M{
-			"_id":        defaultBrokerID,
-			"timestamp":  timestamp,
-			"members":    brokers.Members,
-			"controller": brokers.Controller,
+			"_id":            defaultBrokerID,
+			"timestamp":      timestamp,
+			"members":        brokers.Members,
+			"nodes":          brokers.Nodes,
+			"controller":     brokers.Controller,
+			"configs":        brokers.Configs,
+			"drift":          brokers.Drift,
+			"failed_brokers": brokers.FailedBrokers,
 		},
 	)
 	return err
 }
 
+func (m *MongoClient) SaveTopicChanges(changes []TopicChange) (err error) {
+	coll := m.sess.DB(defaultDName).C(collectChanges)
+	for i := 0; i < len(changes); i++ {
+		if err = coll.Insert(changes[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *MongoClient) FindTopicChanges(topic string) ([]TopicChange, error) {
+	changes := make([]TopicChange, 0)
+	err := m.sess.DB(defaultDName).C(collectChanges).
+		Find(bson.M{"topic": topic}).
+		Sort("timestamp").
+		All(&changes)
+	return changes, err
+}
+
+// FindGroupLag 汇总每次采集保存的 topics 中 group 在各个 topic 上的 lag，返回最近 limit 次采集的结果，按时间升序排列
+// TopicSubscriber 没有 bson tag，字段名为小写的 groupid/lag/offset
+func (m *MongoClient) FindGroupLag(group string, limit int) ([]LagPoint, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"subscribers.groupid": group}},
+		{"$unwind": "$subscribers"},
+		{"$match": bson.M{"subscribers.groupid": group}},
+		{"$group": bson.M{
+			"_id":    "$timestamp",
+			"lag":    bson.M{"$sum": "$subscribers.lag"},
+			"offset": bson.M{"$sum": "$subscribers.offset"},
+		}},
+		{"$sort": bson.M{"_id": -1}},
+		{"$limit": limit},
+	}
+
+	var rows []struct {
+		Timestamp int64 `bson:"_id"`
+		Lag       int64 `bson:"lag"`
+		Offset    int64 `bson:"offset"`
+	}
+	if err := m.sess.DB(defaultDName).C(collectTopics).Pipe(pipeline).All(&rows); err != nil {
+		return nil, err
+	}
+
+	points := make([]LagPoint, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		points = append(points, LagPoint{Timestamp: rows[i].Timestamp, Lag: rows[i].Lag, Offset: rows[i].Offset})
+	}
+	return points, nil
+}
+
+func (m *MongoClient) SaveEvents(events []Event) (err error) {
+	coll := m.sess.DB(defaultDName).C(collectEvents)
+	for i := 0; i < len(events); i++ {
+		if err = coll.Insert(events[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *MongoClient) FindEvents(filter EventFilter) ([]Event, error) {
+	query := bson.M{
+		"timestamp": bson.M{"$gte": filter.Since},
+		"id":        bson.M{"$gt": filter.After},
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.Topic != "" {
+		query["topic"] = filter.Topic
+	}
+	if filter.Group != "" {
+		query["group"] = filter.Group
+	}
+	if filter.Broker != "" {
+		query["broker"] = filter.Broker
+	}
+
+	events := make([]Event, 0)
+	err := m.sess.DB(defaultDName).C(collectEvents).Find(query).Sort("-id").Limit(filter.Limit).All(&events)
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, err
+}
+
+// LastEventID 返回数据库中最新的事件 ID，重启后事件 ID 继续递增
+func (m *MongoClient) LastEventID() int64 {
+	var e Event
+	if err := m.sess.DB(defaultDName).C(collectEvents).Find(nil).Sort("-id").One(&e); err != nil {
+		return 0
+	}
+	return e.ID
+}
+
 func (m *MongoClient) PingMongo() {
 	if m.sess.Ping() != nil {
 		logrus.Warnf("could not connect mongo")
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	resetEarliest  = "earliest"
+	resetLatest    = "latest"
+	resetOffset    = "offset"
+	resetTimestamp = "timestamp"
+	resetShift     = "shift"
+)
+
+// OffsetResetRequest 描述需要重置的分区和目标位置
+// Topics 为空时重置 group 已提交 offset 的所有 topic，分区列表为空时为 topic 的所有分区
+type OffsetResetRequest struct {
+	Group     string
+	Topics    map[string][]int32
+	To        string
+	Offset    int64 // 指定的 offset 或 shift 的条数
+	Timestamp int64 // 毫秒
+}
+
+// OffsetPlan 是一个分区重置前后的 offset，Current 为 -1 表示没有提交过
+type OffsetPlan struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Current   int64  `json:"current"`
+	New       int64  `json:"new"`
+	LogStart  int64  `json:"log_start"`
+	LogEnd    int64  `json:"log_end"`
+}
+
+func (req *OffsetResetRequest) Validate() error {
+	if req.Group == "" {
+		return fmt.Errorf("missing group")
+	}
+	switch req.To {
+	case resetEarliest, resetLatest, resetOffset, resetTimestamp, resetShift:
+		return nil
+	}
+	return fmt.Errorf("unknown reset target %q, expected one of earliest/latest/offset/timestamp/shift", req.To)
+}
+
+// groupOffsets 返回 group 在所有分区上已提交的 offset
+func groupOffsets(client sarama.Client, group string) (map[string]map[int32]int64, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+
+	// version 2 不指定分区时返回所有已提交的 offset
+	resp, err := coordinator.FetchOffset(&sarama.OffsetFetchRequest{Version: 2, ConsumerGroup: group})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+
+	offsets := make(map[string]map[int32]int64)
+	for topic, partitions := range resp.Blocks {
+		for partition, block := range partitions {
+			if block.Err != sarama.ErrNoError || block.Offset < 0 {
+				continue
+			}
+			if offsets[topic] == nil {
+				offsets[topic] = make(map[int32]int64)
+			}
+			offsets[topic][partition] = block.Offset
+		}
+	}
+	return offsets, nil
+}
+
+// ensureGroupInactive 通过 DescribeGroups 确认 group 没有活跃的成员，否则提交的 offset 会被消费者覆盖
+func ensureGroupInactive(client sarama.Client, group string) error {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return err
+	}
+
+	resp, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{group}})
+	if err != nil {
+		return err
+	}
+	for _, g := range resp.Groups {
+		if g.GroupId != group {
+			continue
+		}
+		if g.Err != sarama.ErrNoError {
+			return g.Err
+		}
+		if len(g.Members) > 0 {
+			return fmt.Errorf("group %s has %d active members (state %s), stop the consumers first", group, len(g.Members), g.State)
+		}
+	}
+	return nil
+}
+
+// planOffsetReset 计算每个分区的新 offset，结果会被限制在 [log start, log end] 之间
+func planOffsetReset(client sarama.Client, req OffsetResetRequest) ([]OffsetPlan, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	committed, err := groupOffsets(client, req.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := req.Topics
+	if len(topics) == 0 {
+		if len(committed) == 0 {
+			return nil, fmt.Errorf("group %s has no committed offsets, specify the topics to reset", req.Group)
+		}
+		topics = make(map[string][]int32)
+		for topic := range committed {
+			topics[topic] = nil
+		}
+	}
+
+	plans := make([]OffsetPlan, 0)
+	for topic, partitions := range topics {
+		if len(partitions) == 0 {
+			if partitions, err = client.Partitions(topic); err != nil {
+				return nil, fmt.Errorf("topic %s: %v", topic, err)
+			}
+		}
+
+		for _, partition := range partitions {
+			plan := OffsetPlan{Topic: topic, Partition: partition, Current: -1}
+			if offset, ok := committed[topic][partition]; ok {
+				plan.Current = offset
+			}
+			if plan.LogStart, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+				return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+			}
+			if plan.LogEnd, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+			}
+
+			switch req.To {
+			case resetEarliest:
+				plan.New = plan.LogStart
+			case resetLatest:
+				plan.New = plan.LogEnd
+			case resetOffset:
+				plan.New = req.Offset
+			case resetTimestamp:
+				// 没有不早于该时间的消息时返回 -1
+				if plan.New, err = client.GetOffset(topic, partition, req.Timestamp); err != nil {
+					return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+				}
+				if plan.New == -1 {
+					plan.New = plan.LogEnd
+				}
+			case resetShift:
+				if plan.Current < 0 {
+					return nil, fmt.Errorf("group %s has no committed offset on %s/%d to shift", req.Group, topic, partition)
+				}
+				plan.New = plan.Current + req.Offset
+			}
+
+			if plan.New < plan.LogStart {
+				plan.New = plan.LogStart
+			}
+			if plan.New > plan.LogEnd {
+				plan.New = plan.LogEnd
+			}
+			plans = append(plans, plan)
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].Topic != plans[j].Topic {
+			return plans[i].Topic < plans[j].Topic
+		}
+		return plans[i].Partition < plans[j].Partition
+	})
+	return plans, nil
+}
+
+// commitOffsets 以不属于任何 generation 的方式提交 offset，只能用于没有活跃成员的 group
+func commitOffsets(client sarama.Client, group string, plans []OffsetPlan) error {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return err
+	}
+
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	for _, plan := range plans {
+		req.AddBlock(plan.Topic, plan.Partition, plan.New, 0, "")
+	}
+
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		return err
+	}
+	for topic, partitions := range resp.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("commit %s/%d: %v", topic, partition, kerr)
+			}
+		}
+	}
+	return nil
+}
+
+func printOffsetPlans(w io.Writer, format string, plans []OffsetPlan) error {
+	table := Table{Headers: []string{"topic", "partition", "current", "new", "log_start", "log_end"}}
+	for _, p := range plans {
+		current := interface{}(p.Current)
+		if p.Current < 0 {
+			current = "-"
+		}
+		table.Append(p.Topic, p.Partition, current, p.New, p.LogStart, p.LogEnd)
+	}
+	return printOutput(w, format, table, plans)
+}
+
+// topicFlags 收集可以重复指定的 -topic name 或 -topic name:0,1,2 参数
+type topicFlags map[string][]int32
+
+func (t topicFlags) String() string {
+	return fmt.Sprint(map[string][]int32(t))
+}
+
+func (t topicFlags) Set(s string) error {
+	kv := strings.SplitN(s, ":", 2)
+	if kv[0] == "" {
+		return fmt.Errorf("missing topic")
+	}
+	if len(kv) == 1 {
+		t[kv[0]] = nil
+		return nil
+	}
+
+	partitions, err := parseInt32s(kv[1])
+	if err != nil {
+		return err
+	}
+	t[kv[0]] = append(t[kv[0]], partitions...)
+	return nil
+}
+
+// resetTargetFlags 添加指定重置位置的参数，只能指定其中一个
+func resetTargetFlags(fs *flag.FlagSet) func(req *OffsetResetRequest) error {
+	to := fs.String("to", "", "reset to earliest or latest")
+	offset := fs.Int64("to-offset", -1, "reset to this offset")
+	timestamp := fs.String("to-time", "", "reset to the first offset not earlier than this time (RFC3339 or milliseconds)")
+	shift := fs.Int64("shift", 0, "move the committed offsets by n, negative to go back")
+
+	return func(req *OffsetResetRequest) error {
+		targets := 0
+		if *to != "" {
+			targets++
+			req.To = *to
+			if req.To != resetEarliest && req.To != resetLatest {
+				return fmt.Errorf("-to must be earliest or latest")
+			}
+		}
+		if *offset >= 0 {
+			targets++
+			req.To, req.Offset = resetOffset, *offset
+		}
+		if *timestamp != "" {
+			targets++
+			ts, err := parseTimestamp(*timestamp)
+			if err != nil {
+				return err
+			}
+			req.To, req.Timestamp = resetTimestamp, ts
+		}
+		if *shift != 0 {
+			targets++
+			req.To, req.Offset = resetShift, *shift
+		}
+		if targets != 1 {
+			return fmt.Errorf("exactly one of -to, -to-offset, -to-time or -shift is required")
+		}
+		return nil
+	}
+}
+
+func runResetOffsets(args []string) {
+	fs := newFlagSet("reset-offsets")
+	topics := make(topicFlags)
+	fs.Var(topics, "topic", "reset this topic, or topic:0,1 for some partitions, may be repeated; all committed topics by default")
+	target := resetTargetFlags(fs.FlagSet)
+	dryRun := fs.Bool("dry-run", false, "only show the plan")
+	yes := fs.Bool("yes", false, "commit without asking for confirmation")
+	group := requireArg(fs, fs.Parse(args), "group")
+
+	req := OffsetResetRequest{Group: group, Topics: topics}
+	exitOnError(target(&req))
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	client := NewKafkaMonitor().kafkaClient
+	plans, err := planOffsetReset(client, req)
+	exitOnError(err)
+	exitOnError(printOffsetPlans(os.Stdout, fs.output, plans))
+	if *dryRun {
+		return
+	}
+
+	exitOnError(ensureGroupInactive(client, group))
+	if !*yes && !confirmStdin(fmt.Sprintf("reset %d partitions of group %s", len(plans), group)) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		os.Exit(1)
+	}
+	// 确认期间可能有消费者加入
+	exitOnError(ensureGroupInactive(client, group))
+	exitOnError(commitOffsets(client, group, plans))
+	fmt.Fprintf(os.Stderr, "reset %d partitions of group %s\n", len(plans), group)
+}
+
+type GroupOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+type GroupOffsets struct {
+	Group   string        `json:"group"`
+	Offsets []GroupOffset `json:"offsets"`
+}
+
+// OffsetSnapshot 是 export-offsets 导出的文件
+type OffsetSnapshot struct {
+	Created time.Time      `json:"created"`
+	Groups  []GroupOffsets `json:"groups"`
+}
+
+func exportOffsets(client sarama.Client, groups []string) (OffsetSnapshot, error) {
+	snapshot := OffsetSnapshot{Created: time.Now(), Groups: make([]GroupOffsets, 0, len(groups))}
+	for _, group := range groups {
+		committed, err := groupOffsets(client, group)
+		if err != nil {
+			return snapshot, fmt.Errorf("group %s: %v", group, err)
+		}
+		if len(committed) == 0 {
+			return snapshot, fmt.Errorf("group %s has no committed offsets", group)
+		}
+
+		g := GroupOffsets{Group: group, Offsets: make([]GroupOffset, 0)}
+		for topic, partitions := range committed {
+			for partition, offset := range partitions {
+				g.Offsets = append(g.Offsets, GroupOffset{Topic: topic, Partition: partition, Offset: offset})
+			}
+		}
+		sort.Slice(g.Offsets, func(i, j int) bool {
+			if g.Offsets[i].Topic != g.Offsets[j].Topic {
+				return g.Offsets[i].Topic < g.Offsets[j].Topic
+			}
+			return g.Offsets[i].Partition < g.Offsets[j].Partition
+		})
+		snapshot.Groups = append(snapshot.Groups, g)
+	}
+	return snapshot, nil
+}
+
+// planOffsetImport 将导出的 offset 与分区当前的 log start 和 log end 比较，
+// 超出范围时报错，clamp 为 true 时限制到范围内
+func planOffsetImport(client sarama.Client, group string, offsets []GroupOffset, clamp bool) ([]OffsetPlan, error) {
+	committed, err := groupOffsets(client, group)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]OffsetPlan, 0, len(offsets))
+	for _, o := range offsets {
+		plan := OffsetPlan{Topic: o.Topic, Partition: o.Partition, Current: -1, New: o.Offset}
+		if offset, ok := committed[o.Topic][o.Partition]; ok {
+			plan.Current = offset
+		}
+		if plan.LogStart, err = client.GetOffset(o.Topic, o.Partition, sarama.OffsetOldest); err != nil {
+			return nil, fmt.Errorf("%s/%d: %v", o.Topic, o.Partition, err)
+		}
+		if plan.LogEnd, err = client.GetOffset(o.Topic, o.Partition, sarama.OffsetNewest); err != nil {
+			return nil, fmt.Errorf("%s/%d: %v", o.Topic, o.Partition, err)
+		}
+
+		if plan.New < plan.LogStart || plan.New > plan.LogEnd {
+			if !clamp {
+				return nil, fmt.Errorf("offset %d of %s/%d is outside [%d, %d], use -clamp to limit it", plan.New, o.Topic, o.Partition, plan.LogStart, plan.LogEnd)
+			}
+			if plan.New < plan.LogStart {
+				plan.New = plan.LogStart
+			} else {
+				plan.New = plan.LogEnd
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// OffsetImport 是导入到一个 group 的 plan，Source 为快照中原来的 group
+type OffsetImport struct {
+	Group  string
+	Source string
+	Plans  []OffsetPlan
+}
+
+// planOffsetImports 先计算所有 group 的 plan，任何一个 group 出错时都不会提交，
+// as 不为空时导入到该 group，调用方保证此时只有一个 group
+func planOffsetImports(client sarama.Client, groups []GroupOffsets, as string, clamp bool) ([]OffsetImport, error) {
+	imports := make([]OffsetImport, 0, len(groups))
+	for _, g := range groups {
+		target := g.Group
+		if as != "" {
+			target = as
+		}
+		plans, err := planOffsetImport(client, target, g.Offsets, clamp)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", target, err)
+		}
+		imports = append(imports, OffsetImport{Group: target, Source: g.Group, Plans: plans})
+	}
+	return imports, nil
+}
+
+func runExportOffsets(args []string) {
+	fs := newFlagSet("export-offsets")
+	file := fs.String("f", "-", "write the snapshot to this file, - for stdout")
+	groups := fs.Parse(args)
+	if len(groups) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: kfk export-offsets <group>... [flags]\n")
+		os.Exit(2)
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	snapshot, err := exportOffsets(NewKafkaMonitor().kafkaClient, groups)
+	exitOnError(err)
+
+	b, _ := json.MarshalIndent(snapshot, "", "  ")
+	if *file == "-" {
+		fmt.Println(string(b))
+		return
+	}
+	exitOnError(ioutil.WriteFile(*file, append(b, '\n'), 0644))
+	for _, g := range snapshot.Groups {
+		fmt.Fprintf(os.Stderr, "exported %d offsets of group %s\n", len(g.Offsets), g.Group)
+	}
+}
+
+func runImportOffsets(args []string) {
+	fs := newFlagSet("import-offsets")
+	from := fs.String("group", "", "import this group of the snapshot, required when it has more than one group")
+	as := fs.String("as", "", "commit the offsets to this group instead of the original one")
+	clamp := fs.Bool("clamp", false, "limit offsets outside the current log start and log end instead of failing")
+	dryRun := fs.Bool("dry-run", false, "only show the plan")
+	yes := fs.Bool("yes", false, "commit without asking for confirmation")
+	file := requireArg(fs, fs.Parse(args), "file")
+
+	b, err := ioutil.ReadFile(file)
+	exitOnError(err)
+	var snapshot OffsetSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		exitOnError(fmt.Errorf("invalid snapshot %s: %v", file, err))
+	}
+
+	groups := make([]GroupOffsets, 0)
+	for _, g := range snapshot.Groups {
+		if *from == "" || g.Group == *from {
+			groups = append(groups, g)
+		}
+	}
+	switch {
+	case len(groups) == 0:
+		exitOnError(fmt.Errorf("no group %q in %s", *from, file))
+	case len(groups) > 1 && *as != "":
+		exitOnError(fmt.Errorf("%s has %d groups, use -group to choose one for -as", file, len(groups)))
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	client := NewKafkaMonitor().kafkaClient
+	imports, err := planOffsetImports(client, groups, *as, *clamp)
+	exitOnError(err)
+
+	count := 0
+	names := make([]string, 0, len(imports))
+	for _, im := range imports {
+		fmt.Fprintf(os.Stderr, "group %s (snapshot of %s at %s)\n", im.Group, im.Source, snapshot.Created.Format(time.RFC3339))
+		exitOnError(printOffsetPlans(os.Stdout, fs.output, im.Plans))
+		count += len(im.Plans)
+		names = append(names, im.Group)
+	}
+	if *dryRun {
+		return
+	}
+
+	// 所有 group 都没有活跃成员时才提交，确认期间可能有消费者加入，提交前再检查一次
+	for _, im := range imports {
+		exitOnError(ensureGroupInactive(client, im.Group))
+	}
+	if !*yes && !confirmStdin(fmt.Sprintf("import %d offsets into group %s", count, strings.Join(names, ", "))) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		os.Exit(1)
+	}
+	for _, im := range imports {
+		exitOnError(ensureGroupInactive(client, im.Group))
+	}
+	for _, im := range imports {
+		exitOnError(commitOffsets(client, im.Group, im.Plans))
+		fmt.Fprintf(os.Stderr, "imported %d offsets into group %s\n", len(im.Plans), im.Group)
+	}
+}
+
+// OffsetAtTime 是分区中第一条时间不早于指定时间的消息，没有这样的消息时 Offset 为 log end，Timestamp 为空
+type OffsetAtTime struct {
+	Partition int32      `json:"partition"`
+	Offset    int64      `json:"offset"`
+	Timestamp *time.Time `json:"timestamp"`
+	LogEnd    int64      `json:"log_end"`
+}
+
+// offsetsForTime 通过带时间戳的 OffsetRequest 查找每个分区的 offset，并读取该位置的消息得到实际的 offset 和时间戳
+func offsetsForTime(client sarama.Client, topic string, partitions []int32, ms int64) ([]OffsetAtTime, error) {
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(topic); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]OffsetAtTime, 0, len(partitions))
+	for _, partition := range partitions {
+		logEnd, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+		}
+		offset, err := client.GetOffset(topic, partition, ms)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+		}
+
+		result := OffsetAtTime{Partition: partition, Offset: offset, LogEnd: logEnd}
+		if offset == -1 || offset >= logEnd {
+			result.Offset = logEnd
+			results = append(results, result)
+			continue
+		}
+
+		records, err := fetchRecords(client, FetchRequest{Topic: topic, Partition: partition, Start: startOffset, Offset: offset, Count: 1})
+		if err != nil {
+			return nil, fmt.Errorf("%s/%d: %v", topic, partition, err)
+		}
+		// 该 offset 的消息可能已被压缩或者是事务的控制消息，读到的是之后的第一条消息
+		if len(records) > 0 {
+			result.Offset = records[0].Offset
+			result.Timestamp = &records[0].Timestamp
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// handleOffsets 处理 /api/offsets?topic=&time=，time 为 RFC3339 或毫秒时间戳
+func handleOffsets(client sarama.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		topic := query.Get("topic")
+		if topic == "" || query.Get("time") == "" {
+			http.Error(w, "missing topic or time", http.StatusBadRequest)
+			return
+		}
+		ms, err := parseTimestamp(query.Get("time"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var partitions []int32
+		if v := query.Get("partitions"); v != "" {
+			if partitions, err = parseInt32s(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		results, err := offsetsForTime(client, topic, partitions, ms)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, results)
+	}
+}
+
+func runOffsets(args []string) {
+	fs := newFlagSet("offsets")
+	partitions := fs.String("p", "", "comma separated partitions, all partitions by default")
+	at := fs.String("time", "", "find the first record not earlier than this time (RFC3339 or milliseconds)")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+	if *at == "" {
+		exitOnError(fmt.Errorf("missing -time"))
+	}
+
+	ms, err := parseTimestamp(*at)
+	exitOnError(err)
+	var ids []int32
+	if *partitions != "" {
+		ids, err = parseInt32s(*partitions)
+		exitOnError(err)
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	results, err := offsetsForTime(NewKafkaMonitor().kafkaClient, topic, ids, ms)
+	exitOnError(err)
+
+	table := Table{Headers: []string{"partition", "offset", "timestamp", "log_end"}}
+	for _, r := range results {
+		timestamp := "-"
+		if r.Timestamp != nil {
+			timestamp = r.Timestamp.Format(time.RFC3339Nano)
+		}
+		table.Append(r.Partition, r.Offset, timestamp, r.LogEnd)
+	}
+	exitOnError(printOutput(os.Stdout, fs.output, table, results))
+}
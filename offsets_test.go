@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// newOffsetsClient 返回连接到 MockBroker 的客户端，orders 有 0、1 两个分区，
+// log start 为 10 和 0，log end 为 100 和 50，时间 1000 对应的 offset 为 60 和 -1，
+// billing 只在 orders/0 上提交过 offset 40。setup 可以替换默认的响应
+func newOffsetsClient(t *testing.T, setup func(broker *sarama.MockBroker, handlers map[string]sarama.MockResponse)) (sarama.Client, func()) {
+	broker := sarama.NewMockBroker(t, 1)
+
+	committed := &sarama.OffsetFetchResponse{Version: 2}
+	committed.AddBlock("orders", 0, &sarama.OffsetFetchResponseBlock{Offset: 40, Err: sarama.ErrNoError})
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "billing-copy", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "audit", broker),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetOldest, 10).
+			SetOffset("orders", 0, sarama.OffsetNewest, 100).
+			SetOffset("orders", 0, 1000, 60).
+			SetOffset("orders", 1, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, sarama.OffsetNewest, 50).
+			SetOffset("orders", 1, 1000, -1),
+		"OffsetFetchRequest": sarama.NewMockWrapper(committed),
+	}
+	if setup != nil {
+		setup(broker, handlers)
+	}
+	broker.SetHandlerByMap(handlers)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		broker.Close()
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		broker.Close()
+	}
+}
+
+func TestPlanOffsetReset(t *testing.T) {
+	client, done := newOffsetsClient(t, nil)
+	defer done()
+
+	plans := func(p0, p1 int64) []OffsetPlan {
+		return []OffsetPlan{
+			{Topic: "orders", Partition: 0, Current: 40, New: p0, LogStart: 10, LogEnd: 100},
+			{Topic: "orders", Partition: 1, Current: -1, New: p1, LogStart: 0, LogEnd: 50},
+		}
+	}
+	orders := map[string][]int32{"orders": nil}
+	tests := []struct {
+		name string
+		req  OffsetResetRequest
+		want []OffsetPlan
+		err  string
+	}{
+		{"committed topics by default", OffsetResetRequest{To: resetEarliest}, plans(10, 0), ""},
+		{"latest", OffsetResetRequest{Topics: orders, To: resetLatest}, plans(100, 50), ""},
+		{"offset below log start", OffsetResetRequest{Topics: orders, To: resetOffset, Offset: 5}, plans(10, 5), ""},
+		{"offset above log end", OffsetResetRequest{Topics: orders, To: resetOffset, Offset: 80}, plans(80, 50), ""},
+		{"timestamp after the last record", OffsetResetRequest{Topics: orders, To: resetTimestamp, Timestamp: 1000}, plans(60, 50), ""},
+		{"shift back", OffsetResetRequest{Topics: map[string][]int32{"orders": {0}}, To: resetShift, Offset: -100}, plans(10, 0)[:1], ""},
+		{"shift forward", OffsetResetRequest{Topics: map[string][]int32{"orders": {0}}, To: resetShift, Offset: 20}, plans(60, 0)[:1], ""},
+		{"shift without committed offset", OffsetResetRequest{Topics: orders, To: resetShift, Offset: 1}, nil, "group billing has no committed offset on orders/1 to shift"},
+		{"unknown target", OffsetResetRequest{Topics: orders, To: "now"}, nil, `unknown reset target "now"`},
+	}
+
+	for _, tt := range tests {
+		tt.req.Group = "billing"
+		got, err := planOffsetReset(client, tt.req)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResetTargetFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want OffsetResetRequest
+		err  string
+	}{
+		{[]string{"-to", "earliest"}, OffsetResetRequest{To: resetEarliest}, ""},
+		{[]string{"-to-offset", "0"}, OffsetResetRequest{To: resetOffset}, ""},
+		{[]string{"-to-time", "1560000000000"}, OffsetResetRequest{To: resetTimestamp, Timestamp: 1560000000000}, ""},
+		{[]string{"-shift", "-5"}, OffsetResetRequest{To: resetShift, Offset: -5}, ""},
+		{[]string{"-to", "now"}, OffsetResetRequest{}, "-to must be earliest or latest"},
+		{[]string{"-to-time", "yesterday"}, OffsetResetRequest{}, "yesterday"},
+		{nil, OffsetResetRequest{}, "exactly one of -to, -to-offset, -to-time or -shift is required"},
+		{[]string{"-to", "latest", "-shift", "3"}, OffsetResetRequest{}, "exactly one of -to, -to-offset, -to-time or -shift is required"},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("reset-offsets", flag.ContinueOnError)
+		target := resetTargetFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		var req OffsetResetRequest
+		err := target(&req)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error = %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(req, tt.want) {
+			t.Errorf("%q: got %+v, %v, want %+v", tt.args, req, err, tt.want)
+		}
+	}
+}
+
+func TestEnsureGroupInactive(t *testing.T) {
+	client, done := newOffsetsClient(t, func(broker *sarama.MockBroker, handlers map[string]sarama.MockResponse) {
+		handlers["DescribeGroupsRequest"] = sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("billing", &sarama.GroupDescription{GroupId: "billing", State: "Stable", Members: map[string]*sarama.GroupMemberDescription{"m1": {ClientId: "billing-1"}}}).
+			AddGroupDescription("audit", &sarama.GroupDescription{GroupId: "audit", State: "Empty"})
+	})
+	defer done()
+
+	want := "group billing has 1 active members (state Stable), stop the consumers first"
+	if err := ensureGroupInactive(client, "billing"); err == nil || err.Error() != want {
+		t.Errorf("billing: error = %v, want %q", err, want)
+	}
+	if err := ensureGroupInactive(client, "audit"); err != nil {
+		t.Errorf("audit: %v", err)
+	}
+	// 不存在的 group 返回 Dead 状态，没有成员
+	if err := ensureGroupInactive(client, "billing-copy"); err != nil {
+		t.Errorf("billing-copy: %v", err)
+	}
+}
+
+// 快照中 orders/0 的 offset 120 超出了 log end 100，billing-copy 的 coordinator 是另一个没有提交记录的 broker
+func TestPlanOffsetImports(t *testing.T) {
+	copyBroker := sarama.NewMockBroker(t, 2)
+	defer copyBroker.Close()
+	copyBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"OffsetFetchRequest": sarama.NewMockWrapper(&sarama.OffsetFetchResponse{Version: 2}),
+	})
+	client, done := newOffsetsClient(t, func(broker *sarama.MockBroker, handlers map[string]sarama.MockResponse) {
+		handlers["FindCoordinatorRequest"] = sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "audit", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "billing-copy", copyBroker)
+	})
+	defer done()
+
+	billing := GroupOffsets{Group: "billing", Offsets: []GroupOffset{{"orders", 0, 120}, {"orders", 1, 20}}}
+	audit := GroupOffsets{Group: "audit", Offsets: []GroupOffset{{"orders", 1, 30}}}
+	plans := func(current int64) []OffsetPlan {
+		return []OffsetPlan{
+			{Topic: "orders", Partition: 0, Current: current, New: 100, LogStart: 10, LogEnd: 100},
+			{Topic: "orders", Partition: 1, Current: -1, New: 20, LogStart: 0, LogEnd: 50},
+		}
+	}
+	tests := []struct {
+		name   string
+		groups []GroupOffsets
+		as     string
+		clamp  bool
+		want   []OffsetImport
+		err    string
+	}{
+		{"out of range", []GroupOffsets{billing}, "", false, nil, "group billing: offset 120 of orders/0 is outside [10, 100], use -clamp to limit it"},
+		{"clamp", []GroupOffsets{billing}, "", true, []OffsetImport{{"billing", "billing", plans(40)}}, ""},
+		{"as", []GroupOffsets{billing}, "billing-copy", true, []OffsetImport{{"billing-copy", "billing", plans(-1)}}, ""},
+		{"valid group before an invalid one", []GroupOffsets{audit, billing}, "", false, nil, "group billing: offset 120"},
+		{"all groups", []GroupOffsets{audit, billing}, "", true, []OffsetImport{
+			{"audit", "audit", []OffsetPlan{{Topic: "orders", Partition: 1, Current: -1, New: 30, LogStart: 0, LogEnd: 50}}},
+			{"billing", "billing", plans(40)},
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := planOffsetImports(client, tt.groups, tt.as, tt.clamp)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// orders/0 上时间 1000 对应的 offset 60 已被压缩，读到的第一条消息是 62；orders/1 没有更晚的消息
+func TestOffsetsForTime(t *testing.T) {
+	at := time.Unix(1, 500*int64(time.Millisecond))
+	resp := &sarama.FetchResponse{Version: 4}
+	resp.AddError("orders", 0, sarama.ErrNoError)
+	block := resp.GetBlock("orders", 0)
+	block.HighWaterMarkOffset = 100
+	block.LastStableOffset = 100
+	batch := recordBatch(62, false)
+	batch.RecordBatch.FirstTimestamp = at
+	block.RecordsSet = []*sarama.Records{batch}
+
+	client, done := newOffsetsClient(t, func(broker *sarama.MockBroker, handlers map[string]sarama.MockResponse) {
+		handlers["FetchRequest"] = sarama.NewMockWrapper(resp)
+	})
+	defer done()
+
+	results, err := offsetsForTime(client, "orders", nil, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %+v", results)
+	}
+	if r := results[0]; r.Offset != 62 || r.Timestamp == nil || !r.Timestamp.Equal(at) || r.LogEnd != 100 {
+		t.Errorf("orders/0: got offset %d timestamp %v log end %d, want 62 %v 100", r.Offset, r.Timestamp, r.LogEnd, at)
+	}
+	if r := results[1]; r.Offset != 50 || r.Timestamp != nil || r.LogEnd != 50 {
+		t.Errorf("orders/1: got offset %d timestamp %v log end %d, want 50 <nil> 50", r.Offset, r.Timestamp, r.LogEnd)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+)
+
+// Table 是命令行以表格或 CSV 输出时的数据
+type Table struct {
+	Headers []string
+	Rows    [][]string
+}
+
+func (t *Table) Append(cells ...interface{}) {
+	row := make([]string, 0, len(cells))
+	for _, cell := range cells {
+		row = append(row, fmt.Sprint(cell))
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+// printOutput 按指定格式输出，table/csv 使用 table，json/yaml 使用 v
+func printOutput(w io.Writer, format string, table Table, v interface{}) error {
+	switch format {
+	case outputTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		headers := make([]string, 0, len(table.Headers))
+		for _, h := range table.Headers {
+			headers = append(headers, strings.ToUpper(h))
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, row := range table.Rows {
+			_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write(table.Headers)
+		_ = cw.WriteAll(table.Rows)
+		return cw.Error()
+
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+
+	case outputYAML:
+		b, err := yaml.Marshal(toGeneric(v))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q, expected one of table/json/yaml/csv", format)
+}
+
+// toGeneric 将任意值转换为 JSON 对应的 map/slice/标量，字段名与 JSON 输出一致，
+// 数字转换为 int64 或 float64，否则 YAML 会把 json.Number 当作字符串输出
+func toGeneric(v interface{}) interface{} {
+	b, _ := json.Marshal(v)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var generic interface{}
+	_ = decoder.Decode(&generic)
+	return convertNumbers(generic)
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = convertNumbers(item)
+		}
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	}
+	return v
+}
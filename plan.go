@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	planCreate        = "create"
+	planAddPartitions = "add-partitions"
+	planAlterConfigs  = "alter-configs"
+	planUnmanaged     = "unmanaged"
+	planUnsupported   = "unsupported" // 无法自动处理的差异，例如减少分区、修改副本数、受保护的 topic
+)
+
+// TopicsFile 是 plan/apply 使用的声明式配置，Ignore 中的 topic 不会被报告为未管理，支持通配符
+type TopicsFile struct {
+	Topics []TopicSpec `yaml:"topics"`
+	Ignore []string    `yaml:"ignore"`
+}
+
+// parseTopicsFile 解析如下格式的 YAML 文件，未知的字段和重复的 key 会报错。
+// 配置值按文件中的原文保存，方便与集群中的值比较，例如 1.0 不会变成 1：
+//
+//	ignore: ["_schemas", "connect-*"]
+//	topics:
+//	  - name: orders
+//	    partitions: 12
+//	    replication_factor: 3
+//	    configs:
+//	      retention.ms: 604800000
+func parseTopicsFile(b []byte) (*TopicsFile, error) {
+	file := &TopicsFile{}
+	if err := yaml.UnmarshalStrict(b, file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for i, spec := range file.Topics {
+		if err := spec.Validate(); err != nil {
+			if spec.Name != "" {
+				err = fmt.Errorf("topic %s: %v", spec.Name, err)
+			}
+			return nil, fmt.Errorf("topics[%d]: %v", i, err)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("topic %s is declared twice", spec.Name)
+		}
+		names[spec.Name] = true
+	}
+	for _, pattern := range file.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q", pattern)
+		}
+	}
+	return file, nil
+}
+
+// UnmarshalYAML 检查分区数和副本数是整数，yaml.v2 会把 1.5 截断为 1
+func (spec *TopicSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fields map[string]interface{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	for _, key := range []string{"partitions", "replication_factor"} {
+		if _, ok := fields[key].(float64); ok {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+	}
+
+	// 字段相同但没有 UnmarshalYAML 方法，避免递归调用
+	type topic TopicSpec
+	return unmarshal((*topic)(spec))
+}
+
+func (f *TopicsFile) ignored(topic string) bool {
+	for _, pattern := range f.Ignore {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanChange 是配置文件与集群之间的一处差异
+type PlanChange struct {
+	Action  string `json:"action"`
+	Topic   string `json:"topic"`
+	Detail  string `json:"detail"`
+	Warning string `json:"warning,omitempty"`
+
+	spec    TopicSpec
+	configs map[string]*string // 修改后完整的覆盖配置
+}
+
+func (c PlanChange) applicable() bool {
+	return c.Action == planCreate || c.Action == planAddPartitions || c.Action == planAlterConfigs
+}
+
+func formatConfigs(configs map[string]string) string {
+	keys := make([]string, 0, len(configs))
+	for k := range configs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+configs[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// planTopics 对比配置文件和 collect 得到的集群状态，按配置文件的顺序返回差异，最后是未管理的 topic。
+// 配置文件中没有列出的覆盖配置保持不变，不会被重置为默认值
+func planTopics(client sarama.Client, metrics *Metrics, file *TopicsFile) ([]PlanChange, error) {
+	live := make(map[string]*Topic)
+	for _, topic := range metrics.Topics.Items {
+		live[topic.Name] = topic
+	}
+
+	changes := make([]PlanChange, 0)
+	declared := make(map[string]bool)
+	for _, spec := range file.Topics {
+		declared[spec.Name] = true
+		topic, ok := live[spec.Name]
+		if !ok {
+			change := PlanChange{Action: planCreate, Topic: spec.Name, spec: spec}
+			change.Detail = fmt.Sprintf("partitions=%d replication_factor=%d", spec.Partitions, spec.ReplicationFactor)
+			if len(spec.Configs) > 0 {
+				change.Detail += " " + formatConfigs(spec.Configs)
+			}
+			if isProtectedTopic(spec.Name) {
+				change.Action, change.Detail = planUnsupported, "protected topic does not exist, create it manually"
+			}
+			changes = append(changes, change)
+			continue
+		}
+
+		diffs, err := diffTopicSpec(client, topic, spec)
+		if err != nil {
+			return nil, err
+		}
+		if len(diffs) > 0 && isProtectedTopic(spec.Name) {
+			details := make([]string, 0, len(diffs))
+			for _, d := range diffs {
+				details = append(details, d.Detail)
+			}
+			diffs = []PlanChange{{Action: planUnsupported, Topic: spec.Name, Detail: "protected topic differs: " + strings.Join(details, "; ")}}
+		}
+		changes = append(changes, diffs...)
+	}
+
+	unmanaged := make([]*Topic, 0)
+	for _, topic := range metrics.Topics.Items {
+		if !declared[topic.Name] && !strings.HasPrefix(topic.Name, "__") && !file.ignored(topic.Name) {
+			unmanaged = append(unmanaged, topic)
+		}
+	}
+	sort.Slice(unmanaged, func(i, j int) bool { return unmanaged[i].Name < unmanaged[j].Name })
+	for _, topic := range unmanaged {
+		changes = append(changes, PlanChange{
+			Action: planUnmanaged,
+			Topic:  topic.Name,
+			Detail: fmt.Sprintf("partitions=%d replication_factor=%d", len(topic.Partitions), topic.ReplicationFactor),
+		})
+	}
+	return changes, nil
+}
+
+func diffTopicSpec(client sarama.Client, topic *Topic, spec TopicSpec) ([]PlanChange, error) {
+	changes := make([]PlanChange, 0)
+
+	partitions := int32(len(topic.Partitions))
+	switch {
+	case spec.Partitions > partitions:
+		changes = append(changes, PlanChange{
+			Action:  planAddPartitions,
+			Topic:   spec.Name,
+			Detail:  fmt.Sprintf("partitions %d -> %d", partitions, spec.Partitions),
+			Warning: addPartitionsWarning(client, spec.Name),
+			spec:    spec,
+		})
+	case spec.Partitions < partitions:
+		changes = append(changes, PlanChange{
+			Action: planUnsupported,
+			Topic:  spec.Name,
+			Detail: fmt.Sprintf("partitions can not be decreased from %d to %d", partitions, spec.Partitions),
+		})
+	}
+
+	if int(spec.ReplicationFactor) != topic.ReplicationFactor {
+		changes = append(changes, PlanChange{
+			Action: planUnsupported,
+			Topic:  spec.Name,
+			Detail: fmt.Sprintf("replication factor %d -> %d needs a partition reassignment", topic.ReplicationFactor, spec.ReplicationFactor),
+		})
+	}
+
+	if len(spec.Configs) == 0 {
+		return changes, nil
+	}
+	// 没有取到配置时无法保留现有的覆盖配置
+	if topic.Configs == nil {
+		return nil, fmt.Errorf("could not describe the configs of topic %s", spec.Name)
+	}
+
+	current := make(map[string]ConfigEntry)
+	for _, entry := range topic.Configs {
+		current[entry.Name] = entry
+	}
+	keys := make([]string, 0, len(spec.Configs))
+	for k := range spec.Configs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	diffs := make([]string, 0)
+	for _, k := range keys {
+		entry, ok := current[k]
+		switch {
+		case ok && entry.Override && entry.Value == spec.Configs[k]:
+		case ok && entry.Override:
+			diffs = append(diffs, fmt.Sprintf("%s %s -> %s", k, entry.Value, spec.Configs[k]))
+		case ok:
+			diffs = append(diffs, fmt.Sprintf("%s %s (default) -> %s", k, entry.Value, spec.Configs[k]))
+		default:
+			diffs = append(diffs, fmt.Sprintf("%s (default) -> %s", k, spec.Configs[k]))
+		}
+	}
+	if len(diffs) == 0 {
+		return changes, nil
+	}
+
+	// AlterConfigs 会替换 topic 的全部覆盖配置，需要带上配置文件中没有列出的覆盖配置
+	change := PlanChange{Action: planAlterConfigs, Topic: spec.Name, Detail: strings.Join(diffs, "; "), configs: make(map[string]*string)}
+	for _, entry := range topic.Configs {
+		if !entry.Override {
+			continue
+		}
+		if entry.Sensitive {
+			change.Action, change.configs = planUnsupported, nil
+			change.Detail += "; sensitive config " + entry.Name + " can not be preserved"
+			return append(changes, change), nil
+		}
+		value := entry.Value
+		change.configs[entry.Name] = &value
+	}
+	for k, v := range spec.Configs {
+		value := v
+		change.configs[k] = &value
+	}
+	return append(changes, change), nil
+}
+
+func alterTopicConfigs(client sarama.Client, topic string, configs map[string]*string) error {
+	if err := checkProtected(topic); err != nil {
+		return err
+	}
+
+	admin, err := newClusterAdmin(client)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	return admin.AlterConfig(sarama.TopicResource, topic, configs, false)
+}
+
+// applyPlan 依次执行可以自动处理的差异，遇到错误时停止
+func applyPlan(client sarama.Client, changes []PlanChange, applied func(PlanChange)) error {
+	for _, c := range changes {
+		var err error
+		switch c.Action {
+		case planCreate:
+			err = createTopic(client, c.spec)
+		case planAddPartitions:
+			err = addPartitions(client, c.Topic, c.spec.Partitions)
+		case planAlterConfigs:
+			err = alterTopicConfigs(client, c.Topic, c.configs)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", c.Action, c.Topic, err)
+		}
+		applied(c)
+	}
+	return nil
+}
+
+// confirmPlanWarnings 与 add-partitions 命令一样，带警告的增加分区需要输入 topic 名称确认，
+// confirm 是 -confirm 指定的逗号分隔的 topic 列表，列出的 topic 不再询问
+func confirmPlanWarnings(changes []PlanChange, confirm string) error {
+	confirmed := make(map[string]bool)
+	for _, topic := range strings.Split(confirm, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			confirmed[topic] = true
+		}
+	}
+
+	for _, c := range changes {
+		if c.Action != planAddPartitions || c.Warning == "" {
+			continue
+		}
+		given := confirm
+		if confirmed[c.Topic] {
+			given = c.Topic
+		}
+		if given == "" {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", c.Topic, c.Warning)
+		}
+		if !confirmTopicName(fmt.Sprintf("add partitions to %s", c.Topic), c.Topic, given) {
+			return fmt.Errorf("add partitions to %s is not confirmed", c.Topic)
+		}
+	}
+	return nil
+}
+
+func printPlan(w io.Writer, format string, changes []PlanChange) error {
+	table := Table{Headers: []string{"action", "topic", "detail", "warning"}}
+	for _, c := range changes {
+		table.Append(c.Action, c.Topic, c.Detail, c.Warning)
+	}
+	return printOutput(w, format, table, changes)
+}
+
+func planSummary(changes []PlanChange) (applicable int, summary string) {
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Action]++
+		if c.applicable() {
+			applicable++
+		}
+	}
+	summary = fmt.Sprintf("%d to create, %d to add partitions, %d to alter configs, %d unsupported, %d unmanaged",
+		counts[planCreate], counts[planAddPartitions], counts[planAlterConfigs], counts[planUnsupported], counts[planUnmanaged])
+	return applicable, summary
+}
+
+// loadPlan 读取配置文件并与集群当前的状态对比
+func loadPlan(file string) (sarama.Client, []PlanChange) {
+	b, err := ioutil.ReadFile(file)
+	exitOnError(err)
+	spec, err := parseTopicsFile(b)
+	if err != nil {
+		exitOnError(fmt.Errorf("%s: %v", file, err))
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	changes, err := planTopics(monitor.kafkaClient, refreshMetrics(monitor), spec)
+	exitOnError(err)
+	return monitor.kafkaClient, changes
+}
+
+func runPlan(args []string) {
+	fs := newFlagSet("plan")
+	file := requireArg(fs, fs.Parse(args), "file")
+
+	_, changes := loadPlan(file)
+	exitOnError(printPlan(os.Stdout, fs.output, changes))
+	_, summary := planSummary(changes)
+	fmt.Fprintln(os.Stderr, summary)
+}
+
+func runApply(args []string) {
+	fs := newFlagSet("apply")
+	adminFlag(fs)
+	yes := fs.Bool("yes", false, "apply without asking for confirmation")
+	confirm := fs.String("confirm", "", "comma separated topic names to confirm adding partitions to keyed topics without prompting")
+	file := requireArg(fs, fs.Parse(args), "file")
+	if !adminEnabled {
+		exitOnError(errAdminDisabled)
+	}
+
+	client, changes := loadPlan(file)
+	exitOnError(printPlan(os.Stdout, fs.output, changes))
+	applicable, summary := planSummary(changes)
+	fmt.Fprintln(os.Stderr, summary)
+	if applicable == 0 {
+		fmt.Fprintln(os.Stderr, "nothing to apply")
+		return
+	}
+
+	if !*yes && !confirmStdin(fmt.Sprintf("apply %d changes", applicable)) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		os.Exit(1)
+	}
+	exitOnError(confirmPlanWarnings(changes, *confirm))
+	exitOnError(applyPlan(client, changes, func(c PlanChange) {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", c.Action, c.Topic, c.Detail)
+	}))
+	fmt.Fprintf(os.Stderr, "applied %d changes\n", applicable)
+}
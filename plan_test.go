@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTopicsFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *TopicsFile
+		err   string
+	}{
+		{"full", `ignore: ["_schemas", "connect-*"]
+topics:
+  - name: orders
+    partitions: 12
+    replication_factor: 3
+    configs:
+      retention.ms: 604800000
+      min.cleanable.dirty.ratio: 1.0
+      cleanup.policy: compact
+      preallocate: false
+  - name: payments
+    partitions: 1
+    replication_factor: 1
+    configs:
+`, &TopicsFile{
+			Topics: []TopicSpec{
+				{Name: "orders", Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{
+					"retention.ms": "604800000", "min.cleanable.dirty.ratio": "1.0", "cleanup.policy": "compact", "preallocate": "false",
+				}},
+				{Name: "payments", Partitions: 1, ReplicationFactor: 1},
+			},
+			Ignore: []string{"_schemas", "connect-*"},
+		}, ""},
+		{"empty topics", "topics: []\n", &TopicsFile{Topics: []TopicSpec{}}, ""},
+		{"empty file", "# no topics\n", &TopicsFile{}, ""},
+		{"not a map", "- orders\n", nil, "cannot unmarshal !!seq into main.TopicsFile"},
+		{"unknown field", "topic: []\n", nil, "field topic not found in type main.TopicsFile"},
+		{"topics not a list", "topics: orders\n", nil, "cannot unmarshal !!str `orders` into []main.TopicSpec"},
+		{"bad ignore pattern", "ignore: [\"[a\"]\n", nil, `invalid ignore pattern "[a"`},
+		{"duplicate topic", "topics:\n  - {name: a, partitions: 1, replication_factor: 1}\n  - {name: a, partitions: 2, replication_factor: 1}\n", nil, "topic a is declared twice"},
+		{"duplicate key", "topics:\n  - {name: a, name: b, partitions: 1, replication_factor: 1}\n", nil, "already set"},
+		{"unknown topic field", "topics:\n  - name: a\n    replicas: 1\n", nil, "field replicas not found in type main.topic"},
+		{"float partitions", "topics:\n  - {name: a, partitions: 1.5, replication_factor: 1}\n", nil, "partitions must be an integer"},
+		{"missing partitions", "topics:\n  - {name: a, replication_factor: 1}\n", nil, "topics[0]: topic a: partitions must be positive"},
+		{"missing name", "topics:\n  - {partitions: 1, replication_factor: 1}\n", nil, "topics[0]: missing topic name"},
+		{"list config", "topics:\n  - name: a\n    partitions: 1\n    replication_factor: 1\n    configs:\n      x: [1]\n", nil, "cannot unmarshal !!seq into string"},
+		{"yaml error", "topics:\n\t- a\n", nil, "line 2: found character that cannot start any token"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTopicsFile([]byte(tt.input))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmPlanWarnings(t *testing.T) {
+	last := stdinReader
+	defer func() { stdinReader = last }()
+
+	changes := []PlanChange{
+		{Action: planAddPartitions, Topic: "orders", Warning: "orders is keyed"},
+		{Action: planAddPartitions, Topic: "payments"},
+		{Action: planCreate, Topic: "audit", Warning: "ignored"},
+		{Action: planAddPartitions, Topic: "events", Warning: "events is keyed"},
+	}
+	tests := []struct {
+		name    string
+		confirm string
+		stdin   string
+		err     string
+	}{
+		{"typed", "", "orders\nevents\n", ""},
+		{"wrong name", "", "orders\npayments\n", "add partitions to events is not confirmed"},
+		{"flag", "orders, events", "", ""},
+		{"flag misses a topic", "orders", "events\n", "add partitions to events is not confirmed"},
+	}
+
+	for _, tt := range tests {
+		stdinReader = bufio.NewReader(strings.NewReader(tt.stdin))
+		err := confirmPlanWarnings(changes, tt.confirm)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// planDetails 只比较动作、topic 和说明
+func planDetails(changes []PlanChange) []string {
+	summary := make([]string, 0, len(changes))
+	for _, c := range changes {
+		summary = append(summary, c.Action+" "+c.Topic+": "+c.Detail)
+	}
+	return summary
+}
+
+func TestDiffTopicSpec(t *testing.T) {
+	configs := func(extra ...ConfigEntry) []ConfigEntry {
+		return append([]ConfigEntry{
+			{Name: "retention.ms", Value: "1000", Override: true},
+			{Name: "segment.bytes", Value: "2048", Override: true},
+			{Name: "cleanup.policy", Value: "delete"},
+			{Name: "sasl.jaas.config", Sensitive: true},
+		}, extra...)
+	}
+	spec := func(partitions int32, replication int16, configs map[string]string) TopicSpec {
+		return TopicSpec{Name: "orders", Partitions: partitions, ReplicationFactor: replication, Configs: configs}
+	}
+
+	tests := []struct {
+		name    string
+		configs []ConfigEntry
+		spec    TopicSpec
+		want    []string
+		alter   map[string]string
+		err     string
+	}{
+		{"unchanged", configs(), spec(3, 2, map[string]string{"retention.ms": "1000"}), []string{}, nil, ""},
+		{"fewer partitions", configs(), spec(2, 2, nil), []string{"unsupported orders: partitions can not be decreased from 3 to 2"}, nil, ""},
+		{"replication factor", configs(), spec(3, 3, nil), []string{"unsupported orders: replication factor 2 -> 3 needs a partition reassignment"}, nil, ""},
+		{"alter configs", configs(), spec(3, 2, map[string]string{"retention.ms": "2000", "cleanup.policy": "compact", "max.message.bytes": "10"}), []string{
+			"alter-configs orders: cleanup.policy delete (default) -> compact; max.message.bytes (default) -> 10; retention.ms 1000 -> 2000",
+		}, map[string]string{"retention.ms": "2000", "segment.bytes": "2048", "cleanup.policy": "compact", "max.message.bytes": "10"}, ""},
+		{"sensitive override", configs(ConfigEntry{Name: "ssl.key.password", Override: true, Sensitive: true}), spec(3, 2, map[string]string{"retention.ms": "2000"}), []string{
+			"unsupported orders: retention.ms 1000 -> 2000; sensitive config ssl.key.password can not be preserved",
+		}, nil, ""},
+		{"configs not described", nil, spec(3, 2, map[string]string{"retention.ms": "1000"}), nil, nil, "could not describe the configs of topic orders"},
+		{"configs not needed", nil, spec(3, 2, nil), []string{}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		topic := &Topic{Name: "orders", Partitions: []int32{0, 1, 2}, ReplicationFactor: 2, Configs: tt.configs}
+		changes, err := diffTopicSpec(nil, topic, tt.spec)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := planDetails(changes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+
+		var alter map[string]string
+		for _, c := range changes {
+			if c.configs == nil {
+				continue
+			}
+			alter = make(map[string]string)
+			for k, v := range c.configs {
+				alter[k] = *v
+			}
+		}
+		if !reflect.DeepEqual(alter, tt.alter) {
+			t.Errorf("%s: alter configs %v, want %v", tt.name, alter, tt.alter)
+		}
+	}
+}
+
+func TestPlanTopics(t *testing.T) {
+	last := protectedTopics
+	defer func() { protectedTopics = last }()
+	protectedTopics = "payments, audit-*"
+
+	metrics := newTestMetrics()
+	for _, name := range []string{"__consumer_offsets", "_schemas", "connect-offsets", "legacy"} {
+		metrics.Topics.AddItem(&Topic{Name: name, Partitions: []int32{0}, ReplicationFactor: 1})
+	}
+	file := &TopicsFile{
+		Topics: []TopicSpec{
+			{Name: "orders", Partitions: 3, ReplicationFactor: 2},
+			{Name: "payments", Partitions: 1, ReplicationFactor: 3},
+			{Name: "reports", Partitions: 1, ReplicationFactor: 1, Configs: map[string]string{"cleanup.policy": "compact"}},
+			{Name: "audit-log", Partitions: 1, ReplicationFactor: 1},
+		},
+		Ignore: []string{"_schemas", "connect-*"},
+	}
+
+	changes, err := planTopics(nil, metrics, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"unsupported payments: protected topic differs: replication factor 1 -> 3 needs a partition reassignment",
+		"create reports: partitions=1 replication_factor=1 cleanup.policy=compact",
+		"unsupported audit-log: protected topic does not exist, create it manually",
+		"unmanaged legacy: partitions=1 replication_factor=1",
+	}
+	if got := planDetails(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
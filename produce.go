@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// ProduceRecord 是需要发送的一条消息，key 或 value 为 null 时不设置，Partition 为空时按 key 分区
+type ProduceRecord struct {
+	Key       *string        `json:"key"`
+	Value     *string        `json:"value"`
+	Headers   []RecordHeader `json:"headers,omitempty"`
+	Partition *int32         `json:"partition,omitempty"`
+}
+
+type ProduceResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Error     string `json:"error,omitempty"`
+}
+
+// explicitPartition 放在 ProducerMessage.Metadata 中，表示消息指定了分区
+type explicitPartition int32
+
+// producePartitioner 对指定了分区的消息使用该分区，其余按 key 分区
+type producePartitioner struct {
+	hash sarama.Partitioner
+}
+
+func newProducePartitioner(topic string) sarama.Partitioner {
+	return &producePartitioner{hash: sarama.NewHashPartitioner(topic)}
+}
+
+func (p *producePartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if partition, ok := msg.Metadata.(explicitPartition); ok {
+		if int32(partition) < 0 || int32(partition) >= numPartitions {
+			return -1, sarama.ErrInvalidPartition
+		}
+		return int32(partition), nil
+	}
+	return p.hash.Partition(msg, numPartitions)
+}
+
+func (p *producePartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (r ProduceRecord) message(topic string) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{Topic: topic}
+	if r.Key != nil {
+		msg.Key = sarama.StringEncoder(*r.Key)
+	}
+	if r.Value != nil {
+		msg.Value = sarama.StringEncoder(*r.Value)
+	}
+	for _, h := range r.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: []byte(h.Value)})
+	}
+	if r.Partition != nil {
+		msg.Metadata = explicitPartition(*r.Partition)
+	}
+	return msg
+}
+
+// produceRecords 一次发送所有消息，按顺序返回每条消息的分区和 offset，部分失败时对应的结果中带有错误
+func produceRecords(producer sarama.SyncProducer, topic string, records []ProduceRecord) ([]ProduceResult, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("missing topic")
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no records to produce")
+	}
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(records))
+	for _, r := range records {
+		msgs = append(msgs, r.message(topic))
+	}
+
+	failed := make(map[*sarama.ProducerMessage]error)
+	if err := producer.SendMessages(msgs); err != nil {
+		errs, ok := err.(sarama.ProducerErrors)
+		if !ok {
+			return nil, err
+		}
+		for _, e := range errs {
+			failed[e.Msg] = e.Err
+		}
+	}
+
+	results := make([]ProduceResult, 0, len(msgs))
+	for _, msg := range msgs {
+		result := ProduceResult{Partition: msg.Partition, Offset: msg.Offset}
+		if err, ok := failed[msg]; ok {
+			result.Partition, result.Offset, result.Error = -1, -1, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// parseProduceRecords 请求体可以是一条消息或消息数组
+func parseProduceRecords(b []byte) ([]ProduceRecord, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var records []ProduceRecord
+		err := json.Unmarshal(b, &records)
+		return records, err
+	}
+
+	var record ProduceRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+	return []ProduceRecord{record}, nil
+}
+
+// handleProduce 处理 POST /api/produce?topic=，与管理接口一样需要 ADMIN_ENABLED，producer 在第一次请求时创建
+func handleProduce() http.HandlerFunc {
+	var mu sync.Mutex
+	var producer sarama.SyncProducer
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled {
+			http.Error(w, errAdminDisabled.Error(), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRecordsBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records, err := parseProduceRecords(b)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid records: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		if producer == nil {
+			if producer, err = newSyncProducer(brokerAddr, newProducePartitioner); err != nil {
+				mu.Unlock()
+				logrus.Warnf("new producer error: %v", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		mu.Unlock()
+
+		results, err := produceRecords(producer, r.URL.Query().Get("topic"), records)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, results)
+	}
+}
+
+// headerFlags 收集可以重复指定的 -H key=value 参数
+type headerFlags []RecordHeader
+
+func (h *headerFlags) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *headerFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("header must be key=value")
+	}
+	*h = append(*h, RecordHeader{Key: kv[0], Value: kv[1]})
+	return nil
+}
+
+// readProduceInput 读取 -f 指定的文件或 stdin，默认每行一条消息，whole 时整个文件为一条消息，
+// asJSON 时每行是一条 ProduceRecord
+func readProduceInput(r io.Reader, whole, asJSON bool, template ProduceRecord) ([]ProduceRecord, error) {
+	if whole {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		value := string(b)
+		template.Value = &value
+		return []ProduceRecord{template}, nil
+	}
+
+	records := make([]ProduceRecord, 0)
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) > 0 {
+			record := template
+			if asJSON {
+				// 解码到新的变量中，避免写入 template 中指针指向的值
+				var r ProduceRecord
+				if err := json.Unmarshal(b, &r); err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				record.Value = r.Value
+				if r.Key != nil {
+					record.Key = r.Key
+				}
+				if r.Headers != nil {
+					record.Headers = r.Headers
+				}
+				if r.Partition != nil {
+					record.Partition = r.Partition
+				}
+			} else {
+				value := string(b)
+				record.Value = &value
+			}
+			records = append(records, record)
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
+
+func printProduceResults(w io.Writer, format string, results []ProduceResult) error {
+	table := Table{Headers: []string{"partition", "offset", "error"}}
+	for _, r := range results {
+		table.Append(r.Partition, r.Offset, r.Error)
+	}
+	return printOutput(w, format, table, results)
+}
+
+func runProduce(args []string) {
+	fs := newFlagSet("produce")
+	key := fs.String("key", "", "record key, no key by default")
+	partition := fs.Int("p", -1, "produce to this partition, partition by key by default")
+	file := fs.String("f", "", "read values from this file, one record per line, - for stdin")
+	whole := fs.Bool("whole", false, "send the whole file as a single record")
+	asJSON := fs.Bool("json", false, "every input line is a JSON record with key, value, headers and partition")
+	var headers headerFlags
+	fs.Var(&headers, "H", "record header as key=value, may be repeated")
+	positional := fs.Parse(args)
+	if len(positional) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: kfk produce <topic> [value...] [flags]\n")
+		os.Exit(2)
+	}
+	topic, values := positional[0], positional[1:]
+
+	template := ProduceRecord{Headers: headers}
+	if *key != "" {
+		template.Key = key
+	}
+	if *partition >= 0 {
+		p := int32(*partition)
+		template.Partition = &p
+	}
+
+	// 没有指定值和文件时从 stdin 读取
+	records := make([]ProduceRecord, 0, len(values))
+	for i := range values {
+		record := template
+		record.Value = &values[i]
+		records = append(records, record)
+	}
+	if *file != "" || len(values) == 0 {
+		in := os.Stdin
+		if *file != "" && *file != "-" {
+			var err error
+			in, err = os.Open(*file)
+			exitOnError(err)
+			defer in.Close()
+		}
+		input, err := readProduceInput(in, *whole, *asJSON, template)
+		exitOnError(err)
+		records = append(records, input...)
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	producer, err := newSyncProducer(brokerAddr, newProducePartitioner)
+	exitOnError(err)
+	defer producer.Close()
+
+	results, err := produceRecords(producer, topic, records)
+	exitOnError(err)
+	exitOnError(printProduceResults(os.Stdout, fs.output, results))
+}
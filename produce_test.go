@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleProduceRequiresAdmin(t *testing.T) {
+	last := adminEnabled
+	defer func() { adminEnabled = last }()
+
+	tests := []struct {
+		admin  bool
+		method string
+		body   string
+		status int
+	}{
+		{false, http.MethodPost, `{"value": "hello"}`, http.StatusForbidden},
+		{false, http.MethodGet, "", http.StatusForbidden},
+		{true, http.MethodGet, "", http.StatusMethodNotAllowed},
+		{true, http.MethodPost, `{"value": `, http.StatusBadRequest},
+	}
+
+	handler := handleProduce()
+	for _, tt := range tests {
+		adminEnabled = tt.admin
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(tt.method, "/api/produce?topic=orders", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("admin=%v %s %q: status = %d, want %d", tt.admin, tt.method, tt.body, w.Code, tt.status)
+		}
+	}
+}
+
+func TestParseProduceRecords(t *testing.T) {
+	tests := []struct {
+		body  string
+		count int
+		err   bool
+	}{
+		{`{"key": "k", "value": "v"}`, 1, false},
+		{` [{"value": "a"}, {"value": null, "partition": 2}] `, 2, false},
+		{`[]`, 0, false},
+		{`"text"`, 0, true},
+		{`[{"value": 1}]`, 0, true},
+	}
+
+	for _, tt := range tests {
+		records, err := parseProduceRecords([]byte(tt.body))
+		if (err != nil) != tt.err || (!tt.err && len(records) != tt.count) {
+			t.Errorf("parseProduceRecords(%s) = %d records, %v", tt.body, len(records), err)
+		}
+	}
+
+	records, _ := parseProduceRecords([]byte(`{"key": "k", "value": null, "partition": 3, "headers": [{"key": "h", "value": "1"}]}`))
+	msg := records[0].message("orders")
+	if msg.Topic != "orders" || msg.Value != nil || msg.Metadata != explicitPartition(3) || len(msg.Headers) != 1 {
+		t.Errorf("message = %+v", msg)
+	}
+}
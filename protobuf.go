@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	decodeProtobuf = "protobuf"
+
+	defaultProtoTypeHeader = "proto-type"
+	maxProtoDepth          = 64
+)
+
+var (
+	protoDescriptorFiles string // 逗号分隔的 FileDescriptorSet 文件
+	protoTopicTypes      string // topic=type，逗号分隔
+	protoTypeHeader      = defaultProtoTypeHeader
+)
+
+// FieldDescriptorProto 中的类型和 label
+const (
+	protoDouble   = 1
+	protoFloat    = 2
+	protoInt64    = 3
+	protoUint64   = 4
+	protoInt32    = 5
+	protoFixed64  = 6
+	protoFixed32  = 7
+	protoBool     = 8
+	protoString   = 9
+	protoGroup    = 10
+	protoMessage  = 11
+	protoBytes    = 12
+	protoUint32   = 13
+	protoEnum     = 14
+	protoSfixed32 = 15
+	protoSfixed64 = 16
+	protoSint32   = 17
+	protoSint64   = 18
+
+	protoRepeated = 3
+)
+
+// 编码格式中的 wire type
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoValue 是一个字段的原始值，varint 和定长类型存在 u 中，length-delimited 存在 b 中
+type protoValue struct {
+	wire int
+	u    uint64
+	b    []byte
+}
+
+var errProtoShort = fmt.Errorf("protobuf data too short")
+
+func protoVarint(b []byte, pos int) (uint64, int, error) {
+	var u uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if pos >= len(b) {
+			return 0, pos, errProtoShort
+		}
+		c := b[pos]
+		pos++
+		u |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return u, pos, nil
+		}
+	}
+	return 0, pos, fmt.Errorf("invalid protobuf varint")
+}
+
+func protoFixed(b []byte, pos, n int) (uint64, int, error) {
+	if pos+n > len(b) {
+		return 0, pos, errProtoShort
+	}
+	var u uint64
+	for i := n - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[pos+i])
+	}
+	return u, pos + n, nil
+}
+
+// eachProtoField 依次读取消息中的字段，不支持已废弃的 group
+func eachProtoField(b []byte, fn func(num int32, v protoValue) error) error {
+	for pos := 0; pos < len(b); {
+		tag, next, err := protoVarint(b, pos)
+		if err != nil {
+			return err
+		}
+		num, v := int32(tag>>3), protoValue{wire: int(tag & 7)}
+		if num <= 0 {
+			return fmt.Errorf("invalid protobuf field number %d", num)
+		}
+
+		switch v.wire {
+		case wireVarint:
+			v.u, pos, err = protoVarint(b, next)
+		case wireFixed64:
+			v.u, pos, err = protoFixed(b, next, 8)
+		case wireFixed32:
+			v.u, pos, err = protoFixed(b, next, 4)
+		case wireBytes:
+			var n uint64
+			if n, pos, err = protoVarint(b, next); err == nil {
+				if n > uint64(len(b)-pos) {
+					return errProtoShort
+				}
+				v.b, pos = b[pos:pos+int(n)], pos+int(n)
+			}
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d of field %d", v.wire, num)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type protoField struct {
+	name     string // JSON 名称
+	number   int32
+	label    int
+	typ      int
+	typeName string
+	message  *protoMessageType
+	enum     *protoEnumType
+}
+
+type protoMessageType struct {
+	name     string
+	fields   []*protoField
+	numbers  map[int32]*protoField
+	mapEntry bool
+}
+
+type protoEnumType struct {
+	name   string
+	values map[int32]string
+}
+
+// ProtoDescriptors 是从 FileDescriptorSet 中解析出的所有消息和枚举类型，以全名索引
+type ProtoDescriptors struct {
+	messages map[string]*protoMessageType
+	enums    map[string]*protoEnumType
+}
+
+func parseFileDescriptorSet(b []byte, into *ProtoDescriptors) error {
+	return eachProtoField(b, func(num int32, v protoValue) error {
+		if num == 1 && v.wire == wireBytes {
+			return into.parseFile(v.b)
+		}
+		return nil
+	})
+}
+
+func (p *ProtoDescriptors) parseFile(b []byte) error {
+	var pkg string
+	var messages, enums [][]byte
+	err := eachProtoField(b, func(num int32, v protoValue) error {
+		switch num {
+		case 2:
+			pkg = string(v.b)
+		case 4:
+			messages = append(messages, v.b)
+		case 5:
+			enums = append(enums, v.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if err := p.parseMessage(m, pkg); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := p.parseEnum(e, pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func protoFullName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *ProtoDescriptors) parseMessage(b []byte, scope string) error {
+	m := &protoMessageType{numbers: make(map[int32]*protoField)}
+	var fields, nested, enums [][]byte
+	err := eachProtoField(b, func(num int32, v protoValue) error {
+		switch num {
+		case 1:
+			m.name = string(v.b)
+		case 2:
+			fields = append(fields, v.b)
+		case 3:
+			nested = append(nested, v.b)
+		case 4:
+			enums = append(enums, v.b)
+		case 7:
+			// MessageOptions.map_entry
+			return eachProtoField(v.b, func(num int32, v protoValue) error {
+				if num == 7 {
+					m.mapEntry = v.u != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.name = protoFullName(scope, m.name)
+	p.messages[m.name] = m
+
+	for _, b := range fields {
+		f := &protoField{}
+		var name string
+		err := eachProtoField(b, func(num int32, v protoValue) error {
+			switch num {
+			case 1:
+				name = string(v.b)
+			case 3:
+				f.number = int32(v.u)
+			case 4:
+				f.label = int(v.u)
+			case 5:
+				f.typ = int(v.u)
+			case 6:
+				f.typeName = strings.TrimPrefix(string(v.b), ".")
+			case 10:
+				f.name = string(v.b)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if f.name == "" {
+			f.name = protoJSONName(name)
+		}
+		m.fields = append(m.fields, f)
+		m.numbers[f.number] = f
+	}
+
+	for _, b := range nested {
+		if err := p.parseMessage(b, m.name); err != nil {
+			return err
+		}
+	}
+	for _, b := range enums {
+		if err := p.parseEnum(b, m.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ProtoDescriptors) parseEnum(b []byte, scope string) error {
+	e := &protoEnumType{values: make(map[int32]string)}
+	err := eachProtoField(b, func(num int32, v protoValue) error {
+		switch num {
+		case 1:
+			e.name = string(v.b)
+		case 2:
+			var name string
+			var number int32
+			err := eachProtoField(v.b, func(num int32, v protoValue) error {
+				switch num {
+				case 1:
+					name = string(v.b)
+				case 2:
+					number = int32(v.u)
+				}
+				return nil
+			})
+			if _, ok := e.values[number]; !ok {
+				e.values[number] = name
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.name = protoFullName(scope, e.name)
+	p.enums[e.name] = e
+	return nil
+}
+
+// resolve 关联字段引用的消息和枚举类型，找不到的类型解码时按原始值输出
+func (p *ProtoDescriptors) resolve() {
+	for _, m := range p.messages {
+		for _, f := range m.fields {
+			switch f.typ {
+			case protoMessage:
+				f.message = p.messages[f.typeName]
+			case protoEnum:
+				f.enum = p.enums[f.typeName]
+			}
+		}
+	}
+}
+
+// protoJSONName 与 protoc 生成 json_name 的规则相同：去掉下划线并将后一个字母大写
+func protoJSONName(name string) string {
+	var buf bytes.Buffer
+	upper := false
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// LoadProtoDescriptors 读取 protoc --descriptor_set_out 生成的文件，
+// 生成时应加上 --include_imports，否则引用的其他文件中的类型无法解码
+func LoadProtoDescriptors(files []string) (*ProtoDescriptors, error) {
+	p := &ProtoDescriptors{messages: make(map[string]*protoMessageType), enums: make(map[string]*protoEnumType)}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseFileDescriptorSet(b, p); err != nil {
+			return nil, fmt.Errorf("%s: invalid descriptor set: %v", file, err)
+		}
+	}
+	p.resolve()
+	return p, nil
+}
+
+// protoDecoder 将 protobuf 二进制数据按 proto3 的 JSON 映射写为 JSON，字段按定义的顺序输出
+type protoDecoder struct {
+	out bytes.Buffer
+}
+
+func (d *protoDecoder) writeJSON(v interface{}) {
+	b, _ := json.Marshal(v)
+	d.out.Write(b)
+}
+
+func (d *protoDecoder) writeFloat(f float64) {
+	switch {
+	case math.IsNaN(f):
+		d.out.WriteString(`"NaN"`)
+	case math.IsInf(f, 1):
+		d.out.WriteString(`"Infinity"`)
+	case math.IsInf(f, -1):
+		d.out.WriteString(`"-Infinity"`)
+	default:
+		d.out.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+}
+
+func zigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// unpack 展开 packed 编码的 repeated 标量字段
+func unpack(f *protoField, v protoValue) ([]protoValue, error) {
+	if v.wire != wireBytes {
+		return []protoValue{v}, nil
+	}
+
+	var values []protoValue
+	for pos := 0; pos < len(v.b); {
+		item := protoValue{}
+		var err error
+		switch f.typ {
+		case protoDouble, protoFixed64, protoSfixed64:
+			item.u, pos, err = protoFixed(v.b, pos, 8)
+		case protoFloat, protoFixed32, protoSfixed32:
+			item.u, pos, err = protoFixed(v.b, pos, 4)
+		default:
+			item.u, pos, err = protoVarint(v.b, pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, item)
+	}
+	return values, nil
+}
+
+func (d *protoDecoder) scalar(f *protoField, v protoValue) error {
+	switch f.typ {
+	case protoDouble:
+		d.writeFloat(math.Float64frombits(v.u))
+	case protoFloat:
+		d.writeFloat(float64(math.Float32frombits(uint32(v.u))))
+	case protoInt64, protoSfixed64:
+		d.writeJSON(strconv.FormatInt(int64(v.u), 10))
+	case protoUint64, protoFixed64:
+		d.writeJSON(strconv.FormatUint(v.u, 10))
+	case protoSint64:
+		d.writeJSON(strconv.FormatInt(zigzag(v.u), 10))
+	case protoInt32, protoSfixed32:
+		d.out.WriteString(strconv.FormatInt(int64(int32(v.u)), 10))
+	case protoUint32, protoFixed32:
+		d.out.WriteString(strconv.FormatUint(uint64(uint32(v.u)), 10))
+	case protoSint32:
+		d.out.WriteString(strconv.FormatInt(int64(int32(zigzag(v.u))), 10))
+	case protoBool:
+		d.writeJSON(v.u != 0)
+	case protoEnum:
+		if f.enum != nil {
+			if name, ok := f.enum.values[int32(v.u)]; ok {
+				d.writeJSON(name)
+				return nil
+			}
+		}
+		d.out.WriteString(strconv.FormatInt(int64(int32(v.u)), 10))
+	case protoString:
+		d.writeJSON(string(v.b))
+	case protoBytes:
+		d.writeJSON(base64.StdEncoding.EncodeToString(v.b))
+	default:
+		return fmt.Errorf("unsupported protobuf type %d of field %s", f.typ, f.name)
+	}
+	return nil
+}
+
+func (d *protoDecoder) value(f *protoField, v protoValue, depth int) error {
+	if f.typ != protoMessage {
+		return d.scalar(f, v)
+	}
+	if v.wire != wireBytes {
+		return fmt.Errorf("field %s: unexpected wire type %d", f.name, v.wire)
+	}
+	if f.message == nil {
+		// descriptor set 中没有这个类型
+		d.writeJSON(base64.StdEncoding.EncodeToString(v.b))
+		return nil
+	}
+	return d.message(f.message, v.b, depth+1)
+}
+
+// mapEntry 将 map 的一项写为 JSON 对象的一个成员，JSON 的 key 总是字符串
+func (d *protoDecoder) mapEntry(m *protoMessageType, b []byte, depth int) error {
+	key, value := m.numbers[1], m.numbers[2]
+	if key == nil || value == nil {
+		return fmt.Errorf("invalid map entry %s", m.name)
+	}
+
+	var k, v *protoValue
+	err := eachProtoField(b, func(num int32, item protoValue) error {
+		switch num {
+		case 1:
+			k = &item
+		case 2:
+			v = &item
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if k == nil {
+		k = &protoValue{wire: wireBytes}
+	}
+	kd := &protoDecoder{}
+	if err := kd.scalar(key, *k); err != nil {
+		return err
+	}
+	if key.typ == protoString {
+		d.out.Write(kd.out.Bytes())
+	} else {
+		d.writeJSON(strings.Trim(kd.out.String(), `"`))
+	}
+	d.out.WriteByte(':')
+
+	if v == nil {
+		// 缺少的 value 为该类型的默认值
+		v = &protoValue{}
+		if value.typ == protoString || value.typ == protoBytes || value.typ == protoMessage {
+			v.wire = wireBytes
+		}
+	}
+	return d.value(value, *v, depth)
+}
+
+func (d *protoDecoder) message(m *protoMessageType, b []byte, depth int) error {
+	if depth > maxProtoDepth {
+		return fmt.Errorf("protobuf message nested too deeply")
+	}
+
+	values := make(map[int32][]protoValue)
+	err := eachProtoField(b, func(num int32, v protoValue) error {
+		f, ok := m.numbers[num]
+		if !ok {
+			return nil
+		}
+		if f.label == protoRepeated && f.typ != protoMessage && f.typ != protoString && f.typ != protoBytes {
+			items, err := unpack(f, v)
+			values[num] = append(values[num], items...)
+			return err
+		}
+		values[num] = append(values[num], v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.out.WriteByte('{')
+	first := true
+	for _, f := range m.fields {
+		items := values[f.number]
+		if len(items) == 0 {
+			continue
+		}
+		if !first {
+			d.out.WriteByte(',')
+		}
+		first = false
+		d.writeJSON(f.name)
+		d.out.WriteByte(':')
+
+		switch {
+		case f.label == protoRepeated && f.message != nil && f.message.mapEntry:
+			d.out.WriteByte('{')
+			for i, item := range items {
+				if i > 0 {
+					d.out.WriteByte(',')
+				}
+				if err := d.mapEntry(f.message, item.b, depth+1); err != nil {
+					return err
+				}
+			}
+			d.out.WriteByte('}')
+
+		case f.label == protoRepeated:
+			d.out.WriteByte('[')
+			for i, item := range items {
+				if i > 0 {
+					d.out.WriteByte(',')
+				}
+				if err := d.value(f, item, depth); err != nil {
+					return err
+				}
+			}
+			d.out.WriteByte(']')
+
+		case f.typ == protoMessage && len(items) > 1:
+			// 同一个消息字段出现多次时需要合并，拼接后再解码的效果相同
+			merged := protoValue{wire: wireBytes}
+			for _, item := range items {
+				merged.b = append(merged.b, item.b...)
+			}
+			if err := d.value(f, merged, depth); err != nil {
+				return err
+			}
+
+		default:
+			if err := d.value(f, items[len(items)-1], depth); err != nil {
+				return err
+			}
+		}
+	}
+	d.out.WriteByte('}')
+	return nil
+}
+
+var (
+	protoMu          sync.Mutex
+	protoDescriptors *ProtoDescriptors
+	protoLoadError   error
+	protoLoadedFiles string
+
+	protoTopicMap    map[string]string
+	protoTopicSource string
+)
+
+// currentProtoDescriptors 在第一次使用时加载 protoDescriptorFiles，文件列表变化时重新加载
+func currentProtoDescriptors() (*ProtoDescriptors, error) {
+	protoMu.Lock()
+	defer protoMu.Unlock()
+
+	if protoDescriptorFiles == "" {
+		return nil, fmt.Errorf("protobuf descriptors are not configured, set %s or -protos", envProtoDescriptors)
+	}
+	if protoDescriptors == nil && protoLoadError == nil || protoLoadedFiles != protoDescriptorFiles {
+		protoDescriptors, protoLoadError = LoadProtoDescriptors(strings.Split(protoDescriptorFiles, ","))
+		protoLoadedFiles = protoDescriptorFiles
+	}
+	return protoDescriptors, protoLoadError
+}
+
+// currentProtoTopicTypes 返回 protoTopicTypes 解析后的映射，只在设置变化时重新解析
+func currentProtoTopicTypes() map[string]string {
+	protoMu.Lock()
+	defer protoMu.Unlock()
+
+	if protoTopicMap == nil || protoTopicSource != protoTopicTypes {
+		protoTopicMap = parseProtoTopicTypes(protoTopicTypes)
+		protoTopicSource = protoTopicTypes
+	}
+	return protoTopicMap
+}
+
+// parseProtoTopicTypes 解析 topic=type 形式的映射
+func parseProtoTopicTypes(s string) map[string]string {
+	types := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if kv := strings.SplitN(strings.TrimSpace(item), "=", 2); len(kv) == 2 {
+			types[strings.TrimSpace(kv[0])] = strings.TrimPrefix(strings.TrimSpace(kv[1]), ".")
+		}
+	}
+	return types
+}
+
+// isProtobufDecoder 判断是否为 protobuf 或 protobuf:<type>
+func isProtobufDecoder(decode string) bool {
+	return decode == decodeProtobuf || strings.HasPrefix(decode, decodeProtobuf+":")
+}
+
+// protoTypeOf 按以下顺序确定消息类型：protobuf:<type> 指定的类型、protoTypeHeader header 的值、topic 的映射
+func protoTypeOf(msg *sarama.ConsumerMessage, decode string) (string, error) {
+	if name := strings.TrimPrefix(decode, decodeProtobuf+":"); name != decode {
+		return strings.TrimPrefix(name, "."), nil
+	}
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == protoTypeHeader && len(h.Value) > 0 {
+			return strings.TrimPrefix(string(h.Value), "."), nil
+		}
+	}
+	if name, ok := currentProtoTopicTypes()[msg.Topic]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("no protobuf type for topic %s, use -decode protobuf:<type>, the %s header or %s", msg.Topic, protoTypeHeader, envProtoTopics)
+}
+
+// stripProtoFraming 去掉 Confluent 格式的 magic byte、schema id 和 message index，
+// protobuf 数据的第一个字节不可能为 0，因此不会误判
+func stripProtoFraming(b []byte) ([]byte, error) {
+	if len(b) < 5 || b[0] != 0 {
+		return b, nil
+	}
+
+	count, pos, err := protoVarint(b, 5)
+	if err != nil {
+		return nil, err
+	}
+	for i := zigzag(count); i > 0; i-- {
+		if _, pos, err = protoVarint(b, pos); err != nil {
+			return nil, err
+		}
+	}
+	return b[pos:], nil
+}
+
+// decodeProtobufValue 将消息的 value 解码为格式化的 JSON
+func decodeProtobufValue(msg *sarama.ConsumerMessage, decode string) (string, error) {
+	name, err := protoTypeOf(msg, decode)
+	if err != nil {
+		return "", err
+	}
+	descriptors, err := currentProtoDescriptors()
+	if err != nil {
+		return "", err
+	}
+	m, ok := descriptors.messages[name]
+	if !ok {
+		return "", fmt.Errorf("protobuf type %s not found in descriptors", name)
+	}
+
+	b, err := stripProtoFraming(msg.Value)
+	if err != nil {
+		return "", err
+	}
+	d := &protoDecoder{}
+	if err := d.message(m, b, 0); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, d.out.Bytes(), "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
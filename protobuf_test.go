@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func pbVarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+func pbInt(num int, v uint64) []byte {
+	return append(pbVarint(uint64(num<<3|wireVarint)), pbVarint(v)...)
+}
+
+func pbBytes(num int, b []byte) []byte {
+	out := append(pbVarint(uint64(num<<3|wireBytes)), pbVarint(uint64(len(b)))...)
+	return append(out, b...)
+}
+
+func pbDouble(num int, f float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	return append(pbVarint(uint64(num<<3|wireFixed64)), b...)
+}
+
+func pbCat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// useShopDescriptors 使用 testdata/shop.pb，测试结束后恢复原来的设置
+func useShopDescriptors(topics string) func() {
+	lastFiles, lastTopics := protoDescriptorFiles, protoTopicTypes
+	protoDescriptorFiles, protoTopicTypes = "testdata/shop.pb", topics
+	return func() { protoDescriptorFiles, protoTopicTypes = lastFiles, lastTopics }
+}
+
+func TestLoadProtoDescriptors(t *testing.T) {
+	p, err := LoadProtoDescriptors([]string{"testdata/shop.pb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for name := range p.messages {
+		names = append(names, name)
+	}
+	for _, want := range []string{"shop.Order", "shop.Order.Item", "shop.Order.CountsEntry"} {
+		if _, ok := p.messages[want]; !ok {
+			t.Errorf("message %s not found in %v", want, names)
+		}
+	}
+	if !p.messages["shop.Order.CountsEntry"].mapEntry {
+		t.Error("CountsEntry is not a map entry")
+	}
+	if f := p.messages["shop.Order"].numbers[6]; f.message != p.messages["shop.Order.Item"] {
+		t.Error("field item is not resolved to shop.Order.Item")
+	}
+	if e := p.enums["shop.Status"]; e == nil || e.values[1] != "PAID" {
+		t.Errorf("enum shop.Status = %v", e)
+	}
+
+	if _, err := LoadProtoDescriptors([]string{"testdata/shop.proto"}); err == nil {
+		t.Error("loading a .proto source file did not fail")
+	}
+}
+
+func TestDecodeProtobufValue(t *testing.T) {
+	defer useShopDescriptors("orders = shop.Order, items=.shop.Order.Item")()
+
+	item := pbCat(pbBytes(1, []byte("A-1")), pbDouble(2, 9.5))
+	order := pbCat(
+		pbInt(1, 42),
+		pbBytes(2, []byte("ann")),
+		pbBytes(3, pbCat(pbVarint(1), pbVarint(2))),
+		pbInt(3, 3),
+		pbInt(4, 1),
+		pbBytes(5, pbCat(pbBytes(1, []byte("a")), pbInt(2, 7))),
+		pbBytes(5, pbBytes(1, []byte("b"))),
+		pbBytes(6, item),
+		pbBytes(7, []byte("vip")),
+		pbBytes(7, []byte("new")),
+		pbInt(8, 1),
+		pbInt(9, 3),
+		pbBytes(10, []byte{0xff}),
+		pbBytes(11, pbInt(4, 9)),
+		pbInt(99, 1),
+	)
+	const orderJSON = `{"id":"42","customerName":"ann","quantities":[1,2,3],"status":"PAID","counts":{"a":"7","b":"0"},` +
+		`"item":{"sku":"A-1","price":9.5},"tags":["vip","new"],"paid":true,"delta":-2,"blob":"/w==","parent":{"status":9}}`
+
+	tests := []struct {
+		name    string
+		topic   string
+		decode  string
+		headers []*sarama.RecordHeader
+		value   []byte
+		want    string
+		err     string
+	}{
+		{"topic mapping", "orders", decodeProtobuf, nil, order, orderJSON, ""},
+		{"explicit type", "other", "protobuf:.shop.Order", nil, order, orderJSON, ""},
+		{"header type", "other", decodeProtobuf, []*sarama.RecordHeader{{Key: []byte("proto-type"), Value: []byte("shop.Order.Item")}}, item, `{"sku":"A-1","price":9.5}`, ""},
+		{"confluent framing", "items", decodeProtobuf, nil, pbCat([]byte{0, 0, 0, 0, 7}, pbVarint(0), item), `{"sku":"A-1","price":9.5}`, ""},
+		{"confluent framing with indexes", "items", decodeProtobuf, nil, pbCat([]byte{0, 0, 0, 0, 7}, pbVarint(4), pbVarint(0), pbVarint(0), item), `{"sku":"A-1","price":9.5}`, ""},
+		{"repeated item merges", "orders", decodeProtobuf, nil, pbCat(pbBytes(6, pbBytes(1, []byte("A-1"))), pbBytes(6, pbDouble(2, 1))), `{"item":{"sku":"A-1","price":1}}`, ""},
+		{"empty message", "orders", decodeProtobuf, nil, nil, `{}`, ""},
+		{"no type", "other", decodeProtobuf, nil, order, "", "no protobuf type for topic other"},
+		{"unknown type", "other", "protobuf:shop.Missing", nil, order, "", "protobuf type shop.Missing not found in descriptors"},
+		{"truncated", "orders", decodeProtobuf, nil, order[:len(order)-4], "", "protobuf data too short"},
+	}
+
+	for _, tt := range tests {
+		msg := &sarama.ConsumerMessage{Topic: tt.topic, Value: tt.value, Headers: tt.headers}
+		got, err := decodeProtobufValue(msg, tt.decode)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(got)); err != nil || compact.String() != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProtoTopicTypes(t *testing.T) {
+	defer useShopDescriptors("orders=shop.Order")()
+
+	if got := currentProtoTopicTypes(); !reflect.DeepEqual(got, map[string]string{"orders": "shop.Order"}) {
+		t.Errorf("currentProtoTopicTypes() = %v", got)
+	}
+	protoTopicTypes = "items=shop.Order.Item"
+	if got := currentProtoTopicTypes(); !reflect.DeepEqual(got, map[string]string{"items": "shop.Order.Item"}) {
+		t.Errorf("currentProtoTopicTypes() after change = %v", got)
+	}
+
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{"a=x.A", map[string]string{"a": "x.A"}},
+		{" a = .x.A , b=B,broken", map[string]string{"a": "x.A", "b": "B"}},
+	}
+	for _, tt := range tests {
+		if got := parseProtoTopicTypes(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProtoTopicTypes(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestProtoJSONName(t *testing.T) {
+	tests := map[string]string{
+		"id":            "id",
+		"customer_name": "customerName",
+		"ship_to_2nd":   "shipTo2nd",
+		"_private":      "Private",
+		"already_Camel": "alreadyCamel",
+		"trailing_":     "trailing",
+		"double__under": "doubleUnder",
+	}
+	for name, want := range tests {
+		if got := protoJSONName(name); got != want {
+			t.Errorf("protoJSONName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
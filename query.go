@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// queryTable 是查询语言中的一张虚拟表，数据来自当前的快照
+type queryTable struct {
+	columns []string
+	rows    func(metrics *Metrics) [][]interface{}
+}
+
+var queryTables map[string]queryTable
+
+func init() {
+	queryTables = map[string]queryTable{
+		"brokers": {[]string{"id", "addr", "rack", "controller", "leaders"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, b := range brokerResources(metrics) {
+				rows = append(rows, []interface{}{b.ID, b.Addr, b.Rack, b.Controller, b.Leaders})
+			}
+			return rows
+		}},
+		"topics": {[]string{"topic", "partitions", "replication_factor", "logsize", "produce_rate", "max_lag", "subscribers", "retention_ms", "retention_bytes"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, t := range topicResources(metrics) {
+				rows = append(rows, []interface{}{t.Name, len(t.Partitions), t.ReplicationFactor, t.LogSize, t.ProduceRate, t.Lag, len(t.Subscribers), t.RetentionMs, t.RetentionBytes})
+			}
+			return rows
+		}},
+		"partitions": {[]string{"topic", "partition", "leader", "isr", "isr_count", "under_replicated", "log_start", "log_end", "messages"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, t := range metrics.Topics.Items {
+				for j, partition := range t.Partitions {
+					row := []interface{}{t.Name, partition, nil, nil, nil, nil, nil, nil, nil}
+					if j < len(t.Leaders) {
+						row[2] = t.Leaders[j]
+					}
+					if j < len(t.ISR) {
+						row[3], row[4], row[5] = formatInt32s(t.ISR[j]), len(t.ISR[j]), len(t.ISR[j]) < t.ReplicationFactor
+					}
+					if j < len(t.LogStartOffsets) && j < len(t.AvailableOffsets) {
+						row[6], row[7], row[8] = t.LogStartOffsets[j], t.AvailableOffsets[j], t.AvailableOffsets[j]-t.LogStartOffsets[j]
+					}
+					rows = append(rows, row)
+				}
+			}
+			return rows
+		}},
+		"groups": {[]string{"group", "state", "members", "topics", "lag", "health"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, g := range groupResources(metrics) {
+				rows = append(rows, []interface{}{g.GroupID, g.State, len(g.Members), strings.Join(g.Topic, ","), g.Lag, g.Health})
+			}
+			return rows
+		}},
+		"members": {[]string{"group", "member_id", "client_id", "client_host"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, g := range metrics.Subscribers.Items {
+				for _, member := range g.Members {
+					rows = append(rows, []interface{}{g.GroupID, member.MemberID, member.ClientID, member.ClientHost})
+				}
+			}
+			return rows
+		}},
+		"configs": {[]string{"type", "resource", "name", "value", "source", "override", "read_only", "sensitive"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, t := range metrics.Topics.Items {
+				for _, c := range t.Configs {
+					rows = append(rows, []interface{}{"topic", t.Name, c.Name, c.Value, c.Source, c.Override, c.ReadOnly, c.Sensitive})
+				}
+			}
+			for _, b := range metrics.Brokers.Configs {
+				for _, c := range b.Configs {
+					rows = append(rows, []interface{}{"broker", b.Broker, c.Name, c.Value, c.Source, c.Override, c.ReadOnly, c.Sensitive})
+				}
+			}
+			return rows
+		}},
+		"lag": {[]string{"group", "topic", "partition", "offset", "log_end", "lag"}, func(metrics *Metrics) [][]interface{} {
+			rows := make([][]interface{}, 0)
+			for _, g := range metrics.Subscribers.Items {
+				for _, p := range partitionLags(metrics, g.GroupID) {
+					rows = append(rows, []interface{}{g.GroupID, p.Topic, p.Partition, p.Offset, p.LogEndOffset, p.Lag})
+				}
+			}
+			return rows
+		}},
+	}
+}
+
+// sqlValue 将数字统一转换为 json.Number，以便复用 compareValues
+func sqlValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(x))
+	case int16:
+		return json.Number(strconv.Itoa(int(x)))
+	case int32:
+		return json.Number(strconv.Itoa(int(x)))
+	case int64:
+		return json.Number(strconv.FormatInt(x, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(x, 'f', -1, 64))
+	}
+	return v
+}
+
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenQuoted
+	tokenNumber
+	tokenString
+	tokenSymbol
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func tokenize(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i])})
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+
+		case r == '\'' || r == '"' || r == '`':
+			// 单引号是字符串，双引号和反引号是标识符，连续两个引号表示引号本身
+			var text []rune
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated %c", r)
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						text = append(text, r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text = append(text, runes[i])
+				i++
+			}
+			kind := tokenQuoted
+			if r == '\'' {
+				kind = tokenString
+			}
+			tokens = append(tokens, token{kind, string(text)})
+
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<=", ">=", "!=", "<>":
+					op = two
+				}
+			}
+			if !strings.Contains("=<>!(),*;-", string(r)) {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, token{tokenSymbol, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// queryExpr 是 WHERE 中的表达式，比较的结果为 bool
+type queryExpr interface {
+	eval(row map[string]interface{}) interface{}
+}
+
+type exprColumn string
+
+func (e exprColumn) eval(row map[string]interface{}) interface{} { return row[string(e)] }
+
+type exprLiteral struct{ value interface{} }
+
+func (e exprLiteral) eval(map[string]interface{}) interface{} { return e.value }
+
+type exprNot struct{ expr queryExpr }
+
+func (e exprNot) eval(row map[string]interface{}) interface{} { return e.expr.eval(row) != true }
+
+type exprBinary struct {
+	op          string
+	left, right queryExpr
+}
+
+func (e exprBinary) eval(row map[string]interface{}) interface{} {
+	switch e.op {
+	case "AND":
+		return e.left.eval(row) == true && e.right.eval(row) == true
+	case "OR":
+		return e.left.eval(row) == true || e.right.eval(row) == true
+	}
+
+	l, r := e.left.eval(row), e.right.eval(row)
+	if l == nil || r == nil {
+		return false
+	}
+
+	c := compareValues(l, r)
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+type exprIn struct {
+	expr queryExpr
+	list []queryExpr
+}
+
+func (e exprIn) eval(row map[string]interface{}) interface{} {
+	v := e.expr.eval(row)
+	for _, item := range e.list {
+		if compareValues(v, item.eval(row)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type exprLike struct {
+	expr    queryExpr
+	pattern *regexp.Regexp
+}
+
+func (e exprLike) eval(row map[string]interface{}) interface{} {
+	v := e.expr.eval(row)
+	return v != nil && e.pattern.MatchString(fmt.Sprint(v))
+}
+
+type exprIsNull struct{ expr queryExpr }
+
+func (e exprIsNull) eval(row map[string]interface{}) interface{} { return e.expr.eval(row) == nil }
+
+// likePattern 将 LIKE 的 % 和 _ 转换为正则表达式
+func likePattern(pattern string) *regexp.Regexp {
+	var buf bytes.Buffer
+	buf.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			buf.WriteString(".*")
+		case '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile(buf.String())
+}
+
+type queryOrder struct {
+	column string
+	desc   bool
+}
+
+// Query 是解析后的 SELECT 语句
+type Query struct {
+	Columns []string
+	Table   string
+	Where   queryExpr
+	OrderBy []queryOrder
+	Limit   int
+	Offset  int
+}
+
+type queryParser struct {
+	tokens  []token
+	pos     int
+	table   string
+	columns map[string]bool
+}
+
+func (p *queryParser) peek() token { return p.tokens[p.pos] }
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (p *queryParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return nil
+}
+
+func (p *queryParser) acceptSymbol(symbol string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) unexpected(expected string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("expected %s, got end of query", expected)
+	}
+	return fmt.Errorf("expected %s, got %q", expected, t.text)
+}
+
+func (p *queryParser) column() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent && t.kind != tokenQuoted {
+		return "", p.unexpected("column name")
+	}
+	p.pos++
+
+	name := strings.ToLower(t.text)
+	if t.kind == tokenQuoted {
+		name = t.text
+	}
+	if !p.columns[name] {
+		return "", fmt.Errorf("unknown column %q in table %s, expected one of %s", name, p.table, strings.Join(queryTables[p.table].columns, ", "))
+	}
+	return name, nil
+}
+
+func (p *queryParser) integer() (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokenNumber || err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative integer, got %q", t.text)
+	}
+	return n, nil
+}
+
+func (p *queryParser) expr() (queryExpr, error) {
+	left, err := p.andExpr()
+	for err == nil && p.acceptKeyword("OR") {
+		var right queryExpr
+		if right, err = p.andExpr(); err == nil {
+			left = exprBinary{"OR", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) andExpr() (queryExpr, error) {
+	left, err := p.notExpr()
+	for err == nil && p.acceptKeyword("AND") {
+		var right queryExpr
+		if right, err = p.notExpr(); err == nil {
+			left = exprBinary{"AND", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) notExpr() (queryExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.notExpr()
+		return exprNot{expr}, err
+	}
+	return p.predicate()
+}
+
+func (p *queryParser) predicate() (queryExpr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == tokenSymbol {
+		switch t.text {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.pos++
+			right, err := p.operand()
+			return exprBinary{t.text, left, right}, err
+		}
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		if not {
+			return exprNot{exprIsNull{left}}, nil
+		}
+		return exprIsNull{left}, nil
+	}
+
+	not := p.acceptKeyword("NOT")
+	var expr queryExpr
+	switch {
+	case p.acceptKeyword("LIKE"):
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, fmt.Errorf("LIKE pattern must be a string, got %q", t.text)
+		}
+		expr = exprLike{left, likePattern(t.text)}
+
+	case p.acceptKeyword("IN"):
+		if !p.acceptSymbol("(") {
+			return nil, p.unexpected("(")
+		}
+		in := exprIn{expr: left}
+		for {
+			item, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if p.acceptSymbol(")") {
+				break
+			}
+			if !p.acceptSymbol(",") {
+				return nil, p.unexpected(", or )")
+			}
+		}
+		expr = in
+
+	default:
+		if not {
+			return nil, p.unexpected("LIKE or IN")
+		}
+		return left, nil
+	}
+
+	if not {
+		return exprNot{expr}, nil
+	}
+	return expr, nil
+}
+
+func (p *queryParser) operand() (queryExpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber:
+		p.pos++
+		if _, err := strconv.ParseFloat(t.text, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return exprLiteral{json.Number(t.text)}, nil
+
+	case t.kind == tokenSymbol && t.text == "-":
+		p.pos++
+		if n := p.next(); n.kind == tokenNumber {
+			return exprLiteral{json.Number("-" + n.text)}, nil
+		}
+		return nil, fmt.Errorf("expected a number after -")
+
+	case t.kind == tokenString:
+		p.pos++
+		return exprLiteral{t.text}, nil
+
+	case t.kind == tokenSymbol && t.text == "(":
+		p.pos++
+		expr, err := p.expr()
+		if err == nil && !p.acceptSymbol(")") {
+			err = p.unexpected(")")
+		}
+		return expr, err
+
+	case p.acceptKeyword("TRUE"):
+		return exprLiteral{true}, nil
+	case p.acceptKeyword("FALSE"):
+		return exprLiteral{false}, nil
+	case p.acceptKeyword("NULL"):
+		return exprLiteral{nil}, nil
+	}
+
+	name, err := p.column()
+	return exprColumn(name), err
+}
+
+// ParseQuery 解析 SELECT <columns> FROM <table> [WHERE ...] [ORDER BY ...] [LIMIT n [OFFSET m]]
+func ParseQuery(input string) (*Query, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	q := &Query{Limit: -1}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	// 列名要在知道表名之后才能校验，先记录下位置
+	columnsPos := p.pos
+	for p.peek().kind != tokenEOF && !p.isKeyword("FROM") {
+		p.pos++
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	table, ok := queryTables[strings.ToLower(t.text)]
+	if (t.kind != tokenIdent && t.kind != tokenQuoted) || !ok {
+		return nil, fmt.Errorf("unknown table %q, expected one of %s", t.text, strings.Join(queryTableNames(), ", "))
+	}
+	q.Table = strings.ToLower(t.text)
+	p.table = q.Table
+
+	p.columns = make(map[string]bool)
+	for _, c := range table.columns {
+		p.columns[c] = true
+	}
+
+	fromPos := p.pos
+	p.pos = columnsPos
+	if p.acceptSymbol("*") {
+		q.Columns = table.columns
+	} else {
+		for {
+			name, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.Columns = append(q.Columns, name)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if !p.isKeyword("FROM") {
+		return nil, p.unexpected("FROM")
+	}
+	p.pos = fromPos
+
+	if p.acceptKeyword("WHERE") {
+		if q.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			order := queryOrder{column: name}
+			if p.acceptKeyword("DESC") {
+				order.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			q.OrderBy = append(q.OrderBy, order)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		if q.Limit, err = p.integer(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if q.Offset, err = p.integer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p.acceptSymbol(";")
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected("end of query")
+	}
+	return q, nil
+}
+
+func queryTableNames() []string {
+	names := make([]string, 0, len(queryTables))
+	for name := range queryTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type QueryResult struct {
+	Columns []string                 `json:"columns"`
+	Items   []map[string]interface{} `json:"items"`
+}
+
+// Table 返回命令行以表格或 CSV 输出时的数据
+func (r QueryResult) Table() Table {
+	table := Table{Headers: r.Columns}
+	for _, item := range r.Items {
+		row := make([]interface{}, 0, len(r.Columns))
+		for _, c := range r.Columns {
+			if item[c] == nil {
+				row = append(row, "-")
+			} else {
+				row = append(row, item[c])
+			}
+		}
+		table.Append(row...)
+	}
+	return table
+}
+
+// Execute 在快照上执行查询
+func (q *Query) Execute(metrics *Metrics) QueryResult {
+	table := queryTables[q.Table]
+
+	rows := make([]map[string]interface{}, 0)
+	for _, values := range table.rows(metrics) {
+		row := make(map[string]interface{}, len(values))
+		for i, v := range values {
+			row[table.columns[i]] = sqlValue(v)
+		}
+		if q.Where == nil || q.Where.eval(row) == true {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, order := range q.OrderBy {
+			c := compareValues(rows[i][order.column], rows[j][order.column])
+			if c == 0 {
+				continue
+			}
+			if order.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+
+	if q.Offset > len(rows) {
+		q.Offset = len(rows)
+	}
+	rows = rows[q.Offset:]
+	if q.Limit >= 0 && q.Limit < len(rows) {
+		rows = rows[:q.Limit]
+	}
+
+	result := QueryResult{Columns: q.Columns, Items: make([]map[string]interface{}, 0, len(rows))}
+	for _, row := range rows {
+		item := make(map[string]interface{}, len(q.Columns))
+		for _, c := range q.Columns {
+			item[c] = row[c]
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result
+}
+
+// handleQuery 处理 /api/query，查询语句通过 q 参数或 POST 请求体传入
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	metrics := currentMetrics
+	if metrics == nil {
+		http.Error(w, "metrics not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	sql := r.URL.Query().Get("q")
+	if r.Method == http.MethodPost {
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sql = string(b)
+	}
+
+	q, err := ParseQuery(sql)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, q.Execute(metrics))
+}
+
+func runQuery(args []string) {
+	fs := newFlagSet("query")
+	positional := fs.Parse(args)
+	if len(positional) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: kfk query <sql> [flags]\n\ntables:\n")
+		for _, name := range queryTableNames() {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, strings.Join(queryTables[name].columns, ", "))
+		}
+		os.Exit(2)
+	}
+
+	q, err := ParseQuery(strings.Join(positional, " "))
+	exitOnError(err)
+
+	result := q.Execute(collect())
+	exitOnError(printOutput(os.Stdout, fs.output, result.Table(), result.Items))
+}
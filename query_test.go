@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []token
+		err   string
+	}{
+		{"select * from topics", []token{{tokenIdent, "select"}, {tokenSymbol, "*"}, {tokenIdent, "from"}, {tokenIdent, "topics"}}, ""},
+		{"lag>=10.5", []token{{tokenIdent, "lag"}, {tokenSymbol, ">="}, {tokenNumber, "10.5"}}, ""},
+		{"a<>b != c", []token{{tokenIdent, "a"}, {tokenSymbol, "<>"}, {tokenIdent, "b"}, {tokenSymbol, "!="}, {tokenIdent, "c"}}, ""},
+		{"'it''s'", []token{{tokenString, "it's"}}, ""},
+		{"\"max lag\" `x`", []token{{tokenQuoted, "max lag"}, {tokenQuoted, "x"}}, ""},
+		{"t.name_1 (-1);", []token{{tokenIdent, "t.name_1"}, {tokenSymbol, "("}, {tokenSymbol, "-"}, {tokenNumber, "1"}, {tokenSymbol, ")"}, {tokenSymbol, ";"}}, ""},
+		{"'open", nil, "unterminated '"},
+		{"lag + 1", nil, "unexpected character '+'"},
+	}
+
+	for _, tt := range tests {
+		got, err := tokenize(tt.input)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("tokenize(%q) error = %v, want %q", tt.input, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.input, err)
+			continue
+		}
+		want := append(tt.want, token{kind: tokenEOF})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tokenize(%q) = %v, want %v", tt.input, got, want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"SELECT * FROM topics", ""},
+		{"select topic, max_lag from TOPICS where max_lag > 0 order by max_lag desc, topic limit 5 offset 1;", ""},
+		{"SELECT \"group\" FROM groups WHERE state IN ('Stable', 'Empty') AND NOT lag IS NULL", ""},
+		{"SELECT * FROM partitions WHERE (leader = 1 OR leader = -1) AND topic NOT LIKE '\\_%'", ""},
+		{"", "expected SELECT, got end of query"},
+		{"SELECT *", "expected FROM, got end of query"},
+		{"SELECT * FROM nothing", "unknown table \"nothing\", expected one of brokers, configs, groups, lag, members, partitions, topics"},
+		{"SELECT name FROM brokers", "unknown column \"name\" in table brokers, expected one of id, addr, rack, controller, leaders"},
+		{"SELECT id addr FROM brokers", "expected FROM, got \"addr\""},
+		{"SELECT * FROM groups WHERE lag >", "expected column name, got end of query"},
+		{"SELECT * FROM groups WHERE state NOT = 'x'", "expected LIKE or IN, got \"=\""},
+		{"SELECT * FROM groups WHERE state LIKE state", "LIKE pattern must be a string, got \"state\""},
+		{"SELECT * FROM groups WHERE state IN ('a' 'b')", "expected , or ), got \"b\""},
+		{"SELECT * FROM groups WHERE lag IS 1", "expected NULL, got \"1\""},
+		{"SELECT * FROM groups LIMIT -1", "expected a non-negative integer, got \"-\""},
+		{"SELECT * FROM groups ORDER lag", "expected BY, got \"lag\""},
+		{"SELECT * FROM groups LIMIT 1 extra", "expected end of query, got \"extra\""},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.input)
+		if tt.err == "" && err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.input, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("ParseQuery(%q) error = %v, want %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestQueryExecute(t *testing.T) {
+	metrics := newTestMetrics()
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"SELECT \"group\", lag FROM groups ORDER BY lag DESC", `[{"group":"billing","lag":60},{"group":"audit","lag":0}]`},
+		{"SELECT topic FROM topics WHERE subscribers = 0", `[{"topic":"payments"}]`},
+		{"SELECT topic, partition FROM partitions WHERE under_replicated = true", `[{"partition":2,"topic":"orders"}]`},
+		{"SELECT partition, messages FROM partitions WHERE topic LIKE 'ord%' AND messages >= 200 ORDER BY messages", `[{"messages":200,"partition":1},{"messages":250,"partition":2}]`},
+		{"SELECT partition FROM partitions WHERE topic = 'orders' AND partition NOT IN (0, 2)", `[{"partition":1}]`},
+		{"SELECT topic, partition FROM partitions ORDER BY topic DESC, partition LIMIT 2 OFFSET 1", `[{"partition":0,"topic":"orders"},{"partition":1,"topic":"orders"}]`},
+		{"SELECT id FROM brokers WHERE rack IS NULL OR rack = ''", `[{"id":1},{"id":2}]`},
+		{"SELECT member_id FROM members WHERE client_host LIKE '/10.%'", `[{"member_id":"m1"}]`},
+		{"SELECT topic FROM topics LIMIT 0", `[]`},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.input, err)
+			continue
+		}
+		b, _ := json.Marshal(q.Execute(metrics).Items)
+		if got := string(b); got != tt.want {
+			t.Errorf("%s\n got %s\nwant %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"ord%", "orders", true},
+		{"ORD%", "orders", true},
+		{"order_", "orders", true},
+		{"order_", "order", false},
+		{"a.b", "axb", false},
+		{"%-dlq", "orders-dlq", true},
+	}
+
+	for _, tt := range tests {
+		if got := likePattern(tt.pattern).MatchString(tt.value); got != tt.want {
+			t.Errorf("%q LIKE %q = %v, want %v", tt.value, tt.pattern, got, tt.want)
+		}
+	}
+	if strings.Contains(likePattern("a.b").String(), "a.b") {
+		t.Error("likePattern does not escape regexp characters")
+	}
+}
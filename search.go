@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSearchRecords = 100
+	maxSearchRecords     = 1000
+	defaultSearchBytes   = 256 * 1024 * 1024
+	maxSearchBytes       = 4 * 1024 * 1024 * 1024
+
+	fieldAny    = "any"
+	fieldKey    = "key"
+	fieldValue  = "value"
+	fieldHeader = "header"
+
+	stoppedMaxRecords = "max_records"
+	stoppedMaxBytes   = "max_bytes"
+	stoppedCancelled  = "cancelled"
+)
+
+// jsonPredicate 是形如 $.user.id == 42 的条件，只有路径时判断路径是否存在
+type jsonPredicate struct {
+	path  []interface{}
+	op    string
+	value interface{}
+}
+
+var jsonPredicatePattern = regexp.MustCompile(`^\s*(\$?[^\s=!<>]*)\s*(?:(==|=|!=|>=|<=|>|<)\s*(.+?))?\s*$`)
+
+func parseJSONPredicate(expr string) (*jsonPredicate, error) {
+	m := jsonPredicatePattern.FindStringSubmatch(expr)
+	if m == nil || m[1] == "" {
+		return nil, fmt.Errorf("invalid json path predicate %q, expected e.g. $.user.id == 42", expr)
+	}
+
+	p := &jsonPredicate{op: m[2]}
+	path := strings.TrimPrefix(strings.TrimPrefix(m[1], "$"), ".")
+	for _, seg := range strings.FieldsFunc(path, func(r rune) bool { return r == '.' || r == '[' }) {
+		if strings.HasSuffix(seg, "]") {
+			idx, err := strconv.Atoi(strings.TrimSuffix(seg, "]"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid array index in %q", m[1])
+			}
+			p.path = append(p.path, idx)
+			continue
+		}
+		p.path = append(p.path, seg)
+	}
+
+	// 值按 JSON 解析，单引号括起来或不是合法 JSON 时作为字符串
+	if v := m[3]; len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		p.value = v[1 : len(v)-1]
+	} else if p.op != "" {
+		decoder := json.NewDecoder(strings.NewReader(m[3]))
+		decoder.UseNumber()
+		if err := decoder.Decode(&p.value); err != nil {
+			p.value = m[3]
+		}
+	}
+	return p, nil
+}
+
+func (p *jsonPredicate) Match(b []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return false
+	}
+
+	for _, seg := range p.path {
+		switch key := seg.(type) {
+		case string:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if v, ok = obj[key]; !ok {
+				return false
+			}
+		case int:
+			arr, ok := v.([]interface{})
+			if !ok || key < 0 || key >= len(arr) {
+				return false
+			}
+			v = arr[key]
+		}
+	}
+
+	if p.op == "" {
+		return true
+	}
+
+	c := compareValues(v, p.value)
+	switch p.op {
+	case "=", "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// SearchRequest 描述扫描的范围、匹配条件和预算，offset 为 -1、时间为 0 表示不限制
+type SearchRequest struct {
+	Topic      string
+	Partitions []int32
+	FromOffset int64
+	ToOffset   int64 // 不包含
+	FromTime   int64 // 毫秒
+	ToTime     int64 // 毫秒，不包含
+	Field      string
+	Contains   string
+	Regex      *regexp.Regexp
+	JSONPath   *jsonPredicate
+	MaxRecords int
+	MaxBytes   int64
+	Decode     string
+}
+
+func NewSearchRequest(topic string) SearchRequest {
+	return SearchRequest{Topic: topic, FromOffset: -1, ToOffset: -1, Field: fieldAny, MaxRecords: defaultSearchRecords, MaxBytes: defaultSearchBytes, Decode: decodeUTF8}
+}
+
+func (req *SearchRequest) Validate() error {
+	if req.Topic == "" {
+		return fmt.Errorf("missing topic")
+	}
+
+	conditions := 0
+	if req.Contains != "" {
+		conditions++
+	}
+	if req.Regex != nil {
+		conditions++
+	}
+	if req.JSONPath != nil {
+		conditions++
+	}
+	if conditions != 1 {
+		return fmt.Errorf("exactly one of contains, regex or jsonpath is required")
+	}
+
+	switch req.Field {
+	case fieldAny, fieldKey, fieldValue, fieldHeader:
+	default:
+		return fmt.Errorf("unknown field %q, expected one of any/key/value/header", req.Field)
+	}
+	if err := validateDecoder(req.Decode); err != nil {
+		return err
+	}
+
+	if req.MaxRecords <= 0 || req.MaxRecords > maxSearchRecords {
+		return fmt.Errorf("max records must be between 1 and %d", maxSearchRecords)
+	}
+	if req.MaxBytes <= 0 || req.MaxBytes > maxSearchBytes {
+		return fmt.Errorf("max bytes must be between 1 and %d", int64(maxSearchBytes))
+	}
+	return nil
+}
+
+func (req *SearchRequest) match(b []byte) bool {
+	switch {
+	case req.Regex != nil:
+		return req.Regex.Match(b)
+	case req.JSONPath != nil:
+		return req.JSONPath.Match(b)
+	}
+	return bytes.Contains(b, []byte(req.Contains))
+}
+
+func (req *SearchRequest) Match(msg *sarama.ConsumerMessage) bool {
+	if (req.Field == fieldAny || req.Field == fieldKey) && req.match(matchable(msg.Key, req.Decode)) {
+		return true
+	}
+	if (req.Field == fieldAny || req.Field == fieldValue) && req.match(matchableValue(msg, req.Decode)) {
+		return true
+	}
+	if req.Field == fieldAny || req.Field == fieldHeader {
+		for _, h := range msg.Headers {
+			if req.match(matchable(h.Value, req.Decode)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scanRange 返回分区上需要扫描的 [start, end)
+func (req *SearchRequest) scanRange(client sarama.Client, partition int32) (int64, int64, error) {
+	oldest, err := client.GetOffset(req.Topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	newest, err := client.GetOffset(req.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 按时间查找 offset 时，没有不早于该时间的消息会返回 -1
+	offsetOf := func(ms int64) (int64, error) {
+		offset, err := client.GetOffset(req.Topic, partition, ms)
+		if err == nil && offset == -1 {
+			offset = newest
+		}
+		return offset, err
+	}
+
+	start, end := oldest, newest
+	if req.FromOffset > start {
+		start = req.FromOffset
+	}
+	if req.FromTime > 0 {
+		offset, err := offsetOf(req.FromTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset > start {
+			start = offset
+		}
+	}
+	if req.ToOffset >= 0 && req.ToOffset < end {
+		end = req.ToOffset
+	}
+	if req.ToTime > 0 {
+		offset, err := offsetOf(req.ToTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset < end {
+			end = offset
+		}
+	}
+	return start, end, nil
+}
+
+// SearchStats 中 Incomplete 是没有扫描到范围末尾的分区及原因，这些分区中可能还有没有找到的消息
+type SearchStats struct {
+	Partitions   int      `json:"partitions"`
+	Scanned      int64    `json:"scanned"`
+	ScannedBytes int64    `json:"scanned_bytes"`
+	Matched      int      `json:"matched"`
+	Stopped      string   `json:"stopped,omitempty"`
+	Incomplete   []string `json:"incomplete,omitempty"`
+}
+
+// searchTopic 并行扫描各个分区，每找到一条匹配的消息调用一次 found
+// 达到 MaxRecords、扫描字节数超过 MaxBytes、found 返回错误或 done 关闭时停止
+func searchTopic(client sarama.Client, req SearchRequest, done <-chan struct{}, found func(Record) error) (SearchStats, error) {
+	var stats SearchStats
+	if err := req.Validate(); err != nil {
+		return stats, err
+	}
+
+	partitions := req.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(req.Topic); err != nil {
+			return stats, err
+		}
+	}
+	stats.Partitions = len(partitions)
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return stats, err
+	}
+	defer consumer.Close()
+
+	type scan struct {
+		pc         sarama.PartitionConsumer
+		partition  int32
+		start, end int64
+	}
+	scans := make([]scan, 0, len(partitions))
+	closeAll := func() {
+		for _, sc := range scans {
+			_ = sc.pc.Close()
+		}
+	}
+
+	for _, partition := range partitions {
+		start, end, err := req.scanRange(client, partition)
+		if err == nil && start < end {
+			var pc sarama.PartitionConsumer
+			if pc, err = consumer.ConsumePartition(req.Topic, partition, start); err == nil {
+				scans = append(scans, scan{pc, partition, start, end})
+			}
+		}
+		if err != nil {
+			closeAll()
+			return stats, err
+		}
+	}
+
+	stop := make(chan struct{})
+	var once sync.Once
+	var stopped atomic.Value
+	stopWith := func(reason string) {
+		once.Do(func() {
+			stopped.Store(reason)
+			close(stop)
+		})
+	}
+
+	var scanned, scannedBytes int64
+	results := make(chan Record)
+	// 没有扫描到范围末尾的分区在这里报告，停止扫描（预算用完或取消）不算
+	failures := make(chan error, len(scans))
+	var wg sync.WaitGroup
+
+	for _, sc := range scans {
+		wg.Add(1)
+		go func(sc scan) {
+			defer wg.Done()
+			defer sc.pc.Close()
+
+			next := sc.start
+			for {
+				select {
+				case <-stop:
+					return
+				case err := <-sc.pc.Errors():
+					failures <- fmt.Errorf("%s/%d: %v", err.Topic, err.Partition, err.Err)
+					return
+				case msg := <-sc.pc.Messages():
+					if msg.Offset >= sc.end {
+						return
+					}
+
+					size := int64(len(msg.Key) + len(msg.Value))
+					for _, h := range msg.Headers {
+						size += int64(len(h.Key) + len(h.Value))
+					}
+					atomic.AddInt64(&scanned, 1)
+					if atomic.AddInt64(&scannedBytes, size) > req.MaxBytes {
+						stopWith(stoppedMaxBytes)
+						return
+					}
+
+					if req.Match(msg) {
+						select {
+						case results <- newRecord(msg, req.Decode):
+						case <-stop:
+							return
+						}
+					}
+					next = msg.Offset + 1
+					if next >= sc.end {
+						return
+					}
+				case <-time.After(fetchTimeout):
+					// 事务的控制消息不会返回，末尾的 offset 可能永远读不到，其余情况说明分区没有扫描完
+					if err := checkPartitionEnd(client, req.Topic, sc.partition, next, sc.end); err != nil {
+						failures <- err
+					}
+					return
+				}
+			}
+		}(sc)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for {
+		select {
+		case <-done:
+			stopWith(stoppedCancelled)
+			done = nil
+		case record, ok := <-results:
+			if !ok {
+				stats.Scanned, stats.ScannedBytes = atomic.LoadInt64(&scanned), atomic.LoadInt64(&scannedBytes)
+				if reason, ok := stopped.Load().(string); ok {
+					stats.Stopped = reason
+				}
+				close(failures)
+				for err := range failures {
+					stats.Incomplete = append(stats.Incomplete, err.Error())
+				}
+				sort.Strings(stats.Incomplete)
+				return stats, nil
+			}
+			if stats.Matched >= req.MaxRecords {
+				continue
+			}
+
+			stats.Matched++
+			if err := found(record); err != nil {
+				stopWith(stoppedCancelled)
+			} else if stats.Matched >= req.MaxRecords {
+				stopWith(stoppedMaxRecords)
+			}
+		}
+	}
+}
+
+func parseInt32s(s string) ([]int32, error) {
+	ids := make([]int32, 0)
+	for _, item := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(item), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition %q", item)
+		}
+		ids = append(ids, int32(id))
+	}
+	return ids, nil
+}
+
+// parseSearchRequest 解析 /api/search 的查询参数
+func parseSearchRequest(query url.Values) (SearchRequest, error) {
+	req := NewSearchRequest(query.Get("topic"))
+	req.Contains = query.Get("contains")
+
+	var err error
+	if v := query.Get("partitions"); v != "" {
+		if req.Partitions, err = parseInt32s(v); err != nil {
+			return req, err
+		}
+	}
+	for name, dst := range map[string]*int64{"from_offset": &req.FromOffset, "to_offset": &req.ToOffset, "max_bytes": &req.MaxBytes} {
+		if v := query.Get(name); v != "" {
+			if *dst, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return req, fmt.Errorf("invalid %s: %s", name, v)
+			}
+		}
+	}
+	for name, dst := range map[string]*int64{"from_time": &req.FromTime, "to_time": &req.ToTime} {
+		if v := query.Get(name); v != "" {
+			if *dst, err = parseTimestamp(v); err != nil {
+				return req, err
+			}
+		}
+	}
+	if v := query.Get("max_records"); v != "" {
+		if req.MaxRecords, err = strconv.Atoi(v); err != nil {
+			return req, fmt.Errorf("invalid max_records: %s", v)
+		}
+	}
+	if v := query.Get("field"); v != "" {
+		req.Field = v
+	}
+	if v := query.Get("decode"); v != "" {
+		req.Decode = v
+	}
+	if v := query.Get("regex"); v != "" {
+		if req.Regex, err = regexp.Compile(v); err != nil {
+			return req, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	if v := query.Get("jsonpath"); v != "" {
+		if req.JSONPath, err = parseJSONPredicate(v); err != nil {
+			return req, err
+		}
+	}
+	return req, req.Validate()
+}
+
+// handleSearch 处理 /api/search，通过 Server-Sent Events 推送匹配的消息(record)，结束时推送统计信息(done)
+func handleSearch(client sarama.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseSearchRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		send, ok := newEventWriter(w)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		stats, err := searchTopic(client, req, r.Context().Done(), func(record Record) error {
+			return send("record", record)
+		})
+		if err != nil {
+			_ = send("error", map[string]string{"error": err.Error()})
+			return
+		}
+		_ = send("done", stats)
+	}
+}
+
+func runSearch(args []string) {
+	fs := newFlagSet("search")
+	partitions := fs.String("p", "", "comma separated partitions, all partitions by default")
+	fromOffset := fs.Int64("from-offset", -1, "start at this offset")
+	toOffset := fs.Int64("to-offset", -1, "stop before this offset")
+	fromTime := fs.String("from-time", "", "start at this time (RFC3339 or milliseconds)")
+	toTime := fs.String("to-time", "", "stop before this time (RFC3339 or milliseconds)")
+	field := fs.String("field", fieldAny, "where to match: any, key, value or header")
+	contains := fs.String("contains", "", "match records containing this substring")
+	pattern := fs.String("regex", "", "match records matching this regular expression")
+	jsonPath := fs.String("jsonpath", "", "match JSON records by a predicate such as '$.user.id == 42'")
+	maxRecords := fs.Int("max-records", defaultSearchRecords, "stop after this many matches")
+	maxBytes := fs.Int64("max-bytes", defaultSearchBytes, "stop after scanning this many bytes")
+	decoderFlags(fs)
+	decode := fs.String("decode", decodeUTF8, "value decoder: utf8, json, hex, base64, avro or protobuf[:type]")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+
+	req := NewSearchRequest(topic)
+	req.FromOffset, req.ToOffset, req.Field, req.Contains = *fromOffset, *toOffset, *field, *contains
+	req.MaxRecords, req.MaxBytes, req.Decode = *maxRecords, *maxBytes, *decode
+
+	var err error
+	if *partitions != "" {
+		req.Partitions, err = parseInt32s(*partitions)
+		exitOnError(err)
+	}
+	if *fromTime != "" {
+		req.FromTime, err = parseTimestamp(*fromTime)
+		exitOnError(err)
+	}
+	if *toTime != "" {
+		req.ToTime, err = parseTimestamp(*toTime)
+		exitOnError(err)
+	}
+	if *pattern != "" {
+		req.Regex, err = regexp.Compile(*pattern)
+		exitOnError(err)
+	}
+	if *jsonPath != "" {
+		req.JSONPath, err = parseJSONPredicate(*jsonPath)
+		exitOnError(err)
+	}
+	exitOnError(req.Validate())
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	stats, err := searchTopic(monitor.kafkaClient, req, nil, func(record Record) error {
+		return printRecordLine(os.Stdout, fs.output, record)
+	})
+	exitOnError(err)
+	fmt.Fprintf(os.Stderr, "scanned %d records (%d bytes) in %d partitions, %d matched", stats.Scanned, stats.ScannedBytes, stats.Partitions, stats.Matched)
+	if stats.Stopped != "" {
+		fmt.Fprintf(os.Stderr, ", stopped by %s", stats.Stopped)
+	}
+	fmt.Fprintln(os.Stderr)
+	if len(stats.Incomplete) > 0 {
+		fmt.Fprintf(os.Stderr, "%d partitions were not scanned to the end, records there may be missing:\n", len(stats.Incomplete))
+		for _, reason := range stats.Incomplete {
+			fmt.Fprintf(os.Stderr, "  %s\n", reason)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestJSONPredicate(t *testing.T) {
+	const order = `{"id": 42, "user": {"name": "ann", "tags": ["vip", "new"]}, "total": 9.5, "paid": true, "note": null}`
+	tests := []struct {
+		expr  string
+		input string
+		want  bool
+	}{
+		{"$.id == 42", order, true},
+		{"$.id = 42", order, true},
+		{"$.id != 42", order, false},
+		{"$.id > 41.5", order, true},
+		{"$.total <= 9", order, false},
+		{"$.user.name == 'ann'", order, true},
+		{"$.user.name == \"ann\"", order, true},
+		{"user.name == ann", order, true},
+		{"$.user.tags[0] == vip", order, true},
+		{"$.user.tags[2]", order, false},
+		{"$.user.tags[1]", order, true},
+		{"$.paid == true", order, true},
+		{"$.note", order, true},
+		{"$.missing", order, false},
+		{"$.id.inner", order, false},
+		{"$.id == 42", "not json", false},
+		{"$[1].id == 2", `[{"id": 1}, {"id": 2}]`, true},
+	}
+
+	for _, tt := range tests {
+		p, err := parseJSONPredicate(tt.expr)
+		if err != nil {
+			t.Errorf("parseJSONPredicate(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := p.Match([]byte(tt.input)); got != tt.want {
+			t.Errorf("%q.Match(%s) = %v, want %v", tt.expr, tt.input, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "== 1", "$.a[x] == 1"} {
+		if _, err := parseJSONPredicate(expr); err == nil {
+			t.Errorf("parseJSONPredicate(%q) did not fail", expr)
+		}
+	}
+}
+
+func TestParseInt32s(t *testing.T) {
+	ids, err := parseInt32s("0, 2,5")
+	if err != nil || len(ids) != 3 || ids[0] != 0 || ids[1] != 2 || ids[2] != 5 {
+		t.Errorf("parseInt32s = %v, %v", ids, err)
+	}
+	for _, s := range []string{"", "1,,2", "a", "4294967296"} {
+		if _, err := parseInt32s(s); err == nil {
+			t.Errorf("parseInt32s(%q) did not fail", s)
+		}
+	}
+}
+
+func TestParseSearchRequest(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"topic=orders&contains=abc", ""},
+		{"topic=orders&regex=^a.*z$&field=key&partitions=0,1&from_offset=10&to_offset=20", ""},
+		{"topic=orders&jsonpath=$.id==1&from_time=2019-06-08T13:20:00Z&max_records=1000", ""},
+		{"contains=abc", "missing topic"},
+		{"topic=orders", "exactly one of contains, regex or jsonpath is required"},
+		{"topic=orders&contains=a&regex=b", "exactly one of contains, regex or jsonpath is required"},
+		{"topic=orders&regex=(", "invalid regex: error parsing regexp: missing closing ): `(`"},
+		{"topic=orders&contains=a&field=body", "unknown field \"body\", expected one of any/key/value/header"},
+		{"topic=orders&contains=a&max_records=1001", "max records must be between 1 and 1000"},
+		{"topic=orders&contains=a&max_bytes=0", "max bytes must be between 1 and 4294967296"},
+		{"topic=orders&contains=a&from_offset=x", "invalid from_offset: x"},
+		{"topic=orders&contains=a&partitions=x", "invalid partition \"x\""},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		_, err := parseSearchRequest(query)
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("parseSearchRequest(%q) = %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestSearchRequestMatch(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Key:     []byte("order-1"),
+		Value:   []byte(`{"status": "paid"}`),
+		Headers: []*sarama.RecordHeader{{Key: []byte("source"), Value: []byte("checkout")}},
+	}
+	tests := []struct {
+		field    string
+		contains string
+		want     bool
+	}{
+		{fieldAny, "order-1", true},
+		{fieldAny, "checkout", true},
+		{fieldKey, "paid", false},
+		{fieldValue, "paid", true},
+		{fieldHeader, "checkout", true},
+		{fieldHeader, "order", false},
+	}
+
+	for _, tt := range tests {
+		req := NewSearchRequest("orders")
+		req.Field, req.Contains = tt.field, tt.contains
+		if got := req.Match(msg); got != tt.want {
+			t.Errorf("Match(field=%s, contains=%q) = %v, want %v", tt.field, tt.contains, got, tt.want)
+		}
+	}
+}
+
+// orders/0 的范围是 [0, 3)，但只能读到 0 和 1，空闲后检查发现 offset 2 不是控制消息，分区没有扫描完；
+// orders/1 的 [0, 2) 全部读到
+func TestSearchTopicIncomplete(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	resp := &sarama.FetchResponse{Version: 4}
+	for _, partition := range []int32{0, 1} {
+		resp.AddError("orders", partition, sarama.ErrNoError)
+		block := resp.GetBlock("orders", partition)
+		block.HighWaterMarkOffset = 3 - int64(partition)
+		block.LastStableOffset = block.HighWaterMarkOffset
+		block.RecordsSet = []*sarama.Records{recordBatch(0, false), recordBatch(1, false)}
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 0, sarama.OffsetNewest, 3).
+			SetOffset("orders", 1, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, sarama.OffsetNewest, 2),
+		"FetchRequest": sarama.NewMockWrapper(resp),
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_2_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	req := NewSearchRequest("orders")
+	req.Contains = "missing"
+	stats, err := searchTopic(client, req, nil, func(Record) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"orders/0: no records after offset 2 before the end offset 3"}
+	if stats.Scanned != 4 || !reflect.DeepEqual(stats.Incomplete, want) {
+		t.Errorf("scanned %d, incomplete %q, want 4 and %q", stats.Scanned, stats.Incomplete, want)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	shellHistoryFile = ".kfk_history"
+	maxShellHistory  = 1000
+	defaultPeekCount = 10
+)
+
+type shellCommand struct {
+	name     string
+	usage    string
+	desc     string
+	complete string // 第一个参数的补全类型：topic 或 group
+	admin    bool
+	run      func(s *Shell, args []string) error
+}
+
+var shellCommands []shellCommand
+
+func init() {
+	shellCommands = []shellCommand{
+		{"brokers", "brokers", "list brokers", "", false, func(s *Shell, args []string) error {
+			return printBrokers(os.Stdout, s.output, s.metrics())
+		}},
+		{"topics", "topics", "list topics", "", false, func(s *Shell, args []string) error {
+			return printTopics(os.Stdout, s.output, s.metrics())
+		}},
+		{"topic", "topic <name>", "describe the partitions of a topic", "topic", false, func(s *Shell, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			return printTopic(os.Stdout, s.output, s.metrics(), args[0])
+		}},
+		{"groups", "groups", "list consumer groups", "", false, func(s *Shell, args []string) error {
+			return printGroups(os.Stdout, s.output, s.metrics())
+		}},
+		{"group", "group <id>", "describe the partition lag of a consumer group", "group", false, func(s *Shell, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			return printGroup(os.Stdout, s.output, s.metrics(), args[0])
+		}},
+		{"lag", "lag [min-lag]", "list the lag of every group on every partition", "", false, func(s *Shell, args []string) error {
+			minLag := int64(0)
+			if len(args) > 0 {
+				var err error
+				if minLag, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+					return errUsage
+				}
+			}
+			return printLag(os.Stdout, s.output, s.metrics(), minLag)
+		}},
+		{"query", "query <sql>", "run a SQL-like query against the cluster snapshot", "", false, func(s *Shell, args []string) error {
+			if len(args) == 0 {
+				return errUsage
+			}
+			q, err := ParseQuery(strings.Join(args, " "))
+			if err != nil {
+				return err
+			}
+			result := q.Execute(s.metrics())
+			return printOutput(os.Stdout, s.output, result.Table(), result.Items)
+		}},
+		{"peek", "peek <topic> [partition] [offset] [count]", "print records of a partition, from the tail by default", "topic", false, (*Shell).peek},
+		{"refresh", "refresh", "collect the cluster metrics again", "", false, func(s *Shell, args []string) error {
+			return s.refresh()
+		}},
+		{"output", "output <table|json|yaml|csv>", "change the output format", "", false, func(s *Shell, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			s.output = args[0]
+			return nil
+		}},
+		{"create-topic", "create-topic <name> <partitions> <replicas> [k=v...]", "create a topic with optional configs", "", true, (*Shell).createTopic},
+		{"add-partitions", "add-partitions <topic> <count>", "increase the partition count of a topic", "topic", true, (*Shell).addPartitions},
+		{"delete-topic", "delete-topic <name>", "delete a topic", "topic", true, (*Shell).deleteTopic},
+		{"delete-group", "delete-group <id>", "delete an inactive consumer group", "group", true, (*Shell).deleteGroup},
+	}
+}
+
+var errUsage = fmt.Errorf("invalid arguments")
+
+// Shell 是交互式的 kafka shell，查询命令使用最近一次采集的结果，超过 TICK_INTERVAL 后自动重新采集
+type Shell struct {
+	monitor   *KafkaMonitor
+	editor    *LineEditor
+	output    string
+	refreshed time.Time
+}
+
+func NewShell(output string) *Shell {
+	s := &Shell{
+		monitor: NewKafkaMonitor(),
+		editor:  NewLineEditor("kfk> "),
+		output:  output,
+	}
+	s.editor.Complete = s.complete
+	s.loadHistory()
+	if err := s.refresh(); err != nil {
+		logrus.Fatal(err)
+	}
+	if currentMetrics == nil {
+		logrus.Fatal("could not collect cluster metrics")
+	}
+	return s
+}
+
+func (s *Shell) refresh() error {
+	if err := s.monitor.Refresh(); err != nil {
+		return err
+	}
+	s.refreshed = time.Now()
+	return nil
+}
+
+// metrics 采集失败时继续使用上一次的快照，下一条命令会重试
+func (s *Shell) metrics() *Metrics {
+	if time.Since(s.refreshed) > time.Duration(tickInterval)*time.Second {
+		if err := s.refresh(); err != nil {
+			fmt.Printf("warning: %v, showing the snapshot from %s\n", err, s.refreshed.Format("15:04:05"))
+		}
+	}
+	return currentMetrics
+}
+
+func historyPath() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, shellHistoryFile)
+}
+
+func (s *Shell) loadHistory() {
+	b, err := ioutil.ReadFile(historyPath())
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		s.editor.AddHistory(line)
+	}
+}
+
+func (s *Shell) saveHistory() {
+	history := s.editor.History
+	if len(history) > maxShellHistory {
+		history = history[len(history)-maxShellHistory:]
+	}
+	if path := historyPath(); path != "" {
+		_ = ioutil.WriteFile(path, []byte(strings.Join(history, "\n")+"\n"), 0600)
+	}
+}
+
+// complete 第一个单词补全命令名，之后根据命令补全 topic 或 group
+func (s *Shell) complete(line string) []string {
+	words := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || len(words) == 0 {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	candidates := make([]string, 0)
+	if len(words) == 1 {
+		for _, cmd := range shellCommands {
+			if !cmd.admin || adminEnabled {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+		candidates = append(candidates, "help", "exit")
+	} else if len(words) == 2 && currentMetrics != nil {
+		for _, cmd := range shellCommands {
+			if cmd.name != words[0] {
+				continue
+			}
+			switch cmd.complete {
+			case "topic":
+				for _, topic := range currentMetrics.Topics.Items {
+					candidates = append(candidates, topic.Name)
+				}
+			case "group":
+				for _, group := range currentMetrics.Subscribers.Items {
+					candidates = append(candidates, group.GroupID)
+				}
+			}
+		}
+	}
+
+	matched := make([]string, 0)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matched = append(matched, c)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// help 只在 -admin 时列出管理命令
+func (s *Shell) help() {
+	for _, cmd := range shellCommands {
+		if cmd.admin && !adminEnabled {
+			continue
+		}
+		fmt.Printf("  %-55s %s\n", cmd.usage, cmd.desc)
+	}
+	fmt.Printf("  %-55s %s\n  %-55s %s\n", "help", "show this help", "exit", "leave the shell")
+}
+
+// confirm 执行管理操作前要求输入 word 确认
+func (s *Shell) confirm(action, word string) bool {
+	prompt := s.editor.Prompt
+	defer func() { s.editor.Prompt = prompt }()
+
+	s.editor.Prompt = fmt.Sprintf("%s, type '%s' to confirm: ", action, word)
+	answer, err := s.editor.ReadLine()
+	return err == nil && strings.TrimSpace(answer) == word
+}
+
+var errCancelled = fmt.Errorf("cancelled")
+
+// confirmAdmin 在管理命令的参数检查通过之后要求确认，word 是需要输入的内容：
+// 删除 topic 和向按 key 分区的 topic 增加分区需要输入 topic 名称，其余输入 yes
+func (s *Shell) confirmAdmin(name string, args []string, word string) error {
+	if !s.confirm(name+" "+strings.Join(args, " "), word) {
+		return errCancelled
+	}
+	return nil
+}
+
+func (s *Shell) execute(line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return true
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return false
+	case "help", "?":
+		s.help()
+		return true
+	}
+
+	for _, cmd := range shellCommands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		// 管理命令总是注册的，没有 -admin 时拒绝执行
+		if cmd.admin && !adminEnabled {
+			fmt.Printf("error: %v\n", errAdminDisabled)
+			return true
+		}
+
+		err := cmd.run(s, args[1:])
+		if err == errUsage {
+			fmt.Printf("usage: %s\n", cmd.usage)
+		} else if err == errCancelled {
+			fmt.Println("cancelled")
+		} else if err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+		return true
+	}
+
+	fmt.Printf("unknown command %q, type 'help' for a list of commands\n", args[0])
+	return true
+}
+
+func (s *Shell) Run() {
+	fmt.Printf("connected to %s, type 'help' for a list of commands\n", brokerAddr)
+	for {
+		line, err := s.editor.ReadLine()
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		s.editor.AddHistory(strings.TrimSpace(line))
+		if !s.execute(line) {
+			break
+		}
+	}
+	s.saveHistory()
+}
+
+func runShell(args []string) {
+	fs := newFlagSet("shell")
+	adminFlag(fs)
+	fs.Parse(args)
+
+	// 采集过程中的警告会打乱交互界面
+	logrus.SetLevel(logrus.ErrorLevel)
+	NewShell(fs.output).Run()
+}
+
+// peek 读取分区中的消息，不指定 offset 时读取最后 count 条
+func (s *Shell) peek(args []string) error {
+	if len(args) == 0 || len(args) > 4 {
+		return errUsage
+	}
+
+	ints := make([]int64, 0, 3)
+	for _, arg := range args[1:] {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		ints = append(ints, n)
+	}
+
+	req := FetchRequest{Topic: args[0], Count: defaultPeekCount}
+	if len(ints) > 0 {
+		req.Partition = int32(ints[0])
+	}
+	if len(ints) > 1 {
+		req.Start, req.Offset = startOffset, ints[1]
+	}
+	if len(ints) > 2 {
+		req.Count = int(ints[2])
+	}
+
+	records, err := fetchRecords(s.monitor.kafkaClient, req)
+	if err != nil {
+		return err
+	}
+	return printRecords(os.Stdout, s.output, records)
+}
+
+func (s *Shell) createTopic(args []string) error {
+	if len(args) < 3 {
+		return errUsage
+	}
+	partitions, err1 := strconv.Atoi(args[1])
+	replication, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errUsage
+	}
+
+	configs := make(configFlags)
+	for _, arg := range args[3:] {
+		if err := configs.Set(arg); err != nil {
+			return errUsage
+		}
+	}
+
+	if err := s.confirmAdmin("create-topic", args, "yes"); err != nil {
+		return err
+	}
+	return s.done(createTopic(s.monitor.kafkaClient, TopicSpec{
+		Name:              args[0],
+		Partitions:        int32(partitions),
+		ReplicationFactor: int16(replication),
+		Configs:           configs,
+	}))
+}
+
+func (s *Shell) addPartitions(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errUsage
+	}
+	if err := checkProtected(args[0]); err != nil {
+		return err
+	}
+
+	word := "yes"
+	if warning := addPartitionsWarning(s.monitor.kafkaClient, args[0]); warning != "" {
+		fmt.Printf("warning: %s\n", warning)
+		word = args[0]
+	}
+	if err := s.confirmAdmin("add-partitions", args, word); err != nil {
+		return err
+	}
+	return s.done(addPartitions(s.monitor.kafkaClient, args[0], int32(count)))
+}
+
+func (s *Shell) deleteTopic(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := checkProtected(args[0]); err != nil {
+		return err
+	}
+	if err := s.confirmAdmin("delete-topic", args, args[0]); err != nil {
+		return err
+	}
+	return s.done(deleteTopic(s.monitor.kafkaClient, args[0]))
+}
+
+func (s *Shell) deleteGroup(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := s.confirmAdmin("delete-group", args, "yes"); err != nil {
+		return err
+	}
+
+	coordinator, err := s.monitor.kafkaClient.Coordinator(args[0])
+	if err != nil {
+		return err
+	}
+
+	resp, err := coordinator.DeleteGroups(&sarama.DeleteGroupsRequest{Groups: args})
+	if err != nil {
+		return err
+	}
+	if kerr := resp.GroupErrorCodes[args[0]]; kerr != sarama.ErrNoError {
+		return kerr
+	}
+	return s.done(nil)
+}
+
+// done 管理操作成功后重新采集，使后续查询能看到变化
+func (s *Shell) done(err error) error {
+	if err != nil {
+		return err
+	}
+	fmt.Println("ok")
+	return s.refresh()
+}
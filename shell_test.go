@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellComplete(t *testing.T) {
+	last, lastAdmin := currentMetrics, adminEnabled
+	currentMetrics, adminEnabled = newTestMetrics(), true
+	defer func() { currentMetrics, adminEnabled = last, lastAdmin }()
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"gr", []string{"group", "groups"}},
+		{"delete-", []string{"delete-group", "delete-topic"}},
+		{"ex", []string{"exit"}},
+		{"topic ", []string{"orders", "payments"}},
+		{"topic o", []string{"orders"}},
+		{"group b", []string{"billing"}},
+		{"delete-group ", []string{"audit", "billing"}},
+		{"brokers ", []string{}},
+		{"topic orders ", []string{}},
+		{"unknown o", []string{}},
+	}
+
+	s := &Shell{}
+	for _, tt := range tests {
+		if got := s.complete(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+
+	// 没有 -admin 时不补全管理命令
+	adminEnabled = false
+	if got := s.complete("delete-"); len(got) != 0 {
+		t.Errorf("complete without admin = %v", got)
+	}
+}
+
+// 参数错误的管理命令直接提示用法，不要求确认；取消确认后不执行
+func TestShellAdminConfirm(t *testing.T) {
+	lastAdmin := adminEnabled
+	defer func() { adminEnabled = lastAdmin }()
+
+	in := bufio.NewReader(strings.NewReader("no\nrest\n"))
+	s := &Shell{editor: &LineEditor{in: in, out: ioutil.Discard}}
+
+	adminEnabled = false
+	s.execute("delete-topic orders")
+	adminEnabled = true
+	for _, line := range []string{"delete-topic", "add-partitions orders", "add-partitions orders many", "create-topic a x 1", "create-topic a 1 1 bad", "delete-group"} {
+		s.execute(line)
+	}
+	s.execute("delete-group billing")
+
+	if rest, _ := in.ReadString('\n'); rest != "rest\n" {
+		t.Errorf("remaining input %q, want %q", rest, "rest\n")
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	e := &LineEditor{}
+	for _, line := range []string{"topics", "", "topics", "groups", "topics"} {
+		e.AddHistory(line)
+	}
+	if want := []string{"topics", "groups", "topics"}; !reflect.DeepEqual(e.History, want) {
+		t.Errorf("history = %v, want %v", e.History, want)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	streamSnapshot = "snapshot"
+	streamEvent    = "event"
+
+	streamBufferSize  = 64
+	heartbeatInterval = 15 * time.Second
+)
+
+type streamMessage struct {
+	kind    string
+	metrics *Metrics
+	event   Event
+}
+
+// StreamHub 将每次采集的结果和集群变更事件推送给所有订阅的连接
+type StreamHub struct {
+	sync.Mutex
+	clients map[chan streamMessage]bool
+}
+
+func (h *StreamHub) Subscribe() chan streamMessage {
+	h.Lock()
+	defer h.Unlock()
+
+	ch := make(chan streamMessage, streamBufferSize)
+	h.clients[ch] = true
+	return ch
+}
+
+func (h *StreamHub) Unsubscribe(ch chan streamMessage) {
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.clients, ch)
+}
+
+// Publish 不会阻塞采集流程，消费过慢的连接会丢失消息
+func (h *StreamHub) Publish(msg streamMessage) {
+	h.Lock()
+	defer h.Unlock()
+
+	for ch := range h.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+var streamHub = &StreamHub{clients: make(map[chan streamMessage]bool)}
+
+// streamOptions 对应推送接口的查询参数
+type streamOptions struct {
+	topic       string
+	group       string
+	delta       bool
+	snapshots   bool
+	events      bool
+	lastEventID int64
+	filter      EventFilter
+}
+
+func parseStreamOptions(r *http.Request) streamOptions {
+	query := r.URL.Query()
+	opts := streamOptions{
+		topic:     query.Get("topic"),
+		group:     query.Get("group"),
+		delta:     query.Get("delta") == "true" || query.Get("delta") == "1",
+		snapshots: query.Get("snapshots") != "false" && query.Get("snapshots") != "0",
+		events:    query.Get("events") != "false" && query.Get("events") != "0",
+		filter:    parseEventFilter(r),
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	opts.lastEventID, _ = strconv.ParseInt(lastEventID, 10, 64)
+
+	opts.filter.Topic = opts.topic
+	opts.filter.Group = opts.group
+	opts.filter.Limit = maxEvents
+	return opts
+}
+
+// Filter 只保留指定 topic 或 group 相关的数据
+func (s Snapshot) Filter(topic, group string) Snapshot {
+	if topic == "" && group == "" {
+		return s
+	}
+
+	filtered := Snapshot{Timestamp: s.Timestamp, Brokers: s.Brokers, Topics: []*Topic{}, Subscribers: []Subscriber{}}
+	for _, t := range s.Topics {
+		if topic != "" && t.Name != topic {
+			continue
+		}
+		if group != "" && !hasSubscriber(t, group) {
+			continue
+		}
+		filtered.Topics = append(filtered.Topics, t)
+	}
+
+	for _, sub := range s.Subscribers {
+		if group != "" && sub.GroupID != group {
+			continue
+		}
+		if topic != "" && !containsString(sub.Topic, topic) {
+			continue
+		}
+		filtered.Subscribers = append(filtered.Subscribers, sub)
+	}
+	return filtered
+}
+
+func hasSubscriber(topic *Topic, group string) bool {
+	for _, sub := range topic.Subscribers {
+		if sub.GroupID == group {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotDelta 只包含与上一次推送相比发生变化的部分
+type SnapshotDelta struct {
+	Timestamp          int64        `json:"timestamp"`
+	Topics             []*Topic     `json:"topics"`
+	Subscribers        []Subscriber `json:"subscribers"`
+	Brokers            *Brokers     `json:"brokers,omitempty"`
+	RemovedTopics      []string     `json:"removed_topics"`
+	RemovedSubscribers []string     `json:"removed_subscribers"`
+}
+
+// deltaState 记录每个连接上一次推送的内容
+type deltaState struct {
+	topics      map[string]string
+	subscribers map[string]string
+	brokers     string
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{
+		topics:      make(map[string]string),
+		subscribers: make(map[string]string),
+	}
+}
+
+func (d *deltaState) Diff(s Snapshot) SnapshotDelta {
+	delta := SnapshotDelta{
+		Timestamp:          s.Timestamp,
+		Topics:             []*Topic{},
+		Subscribers:        []Subscriber{},
+		RemovedTopics:      []string{},
+		RemovedSubscribers: []string{},
+	}
+
+	topics := make(map[string]string)
+	for _, t := range s.Topics {
+		b, _ := json.Marshal(t)
+		topics[t.Name] = string(b)
+		if d.topics[t.Name] != topics[t.Name] {
+			delta.Topics = append(delta.Topics, t)
+		}
+	}
+	for name := range d.topics {
+		if _, ok := topics[name]; !ok {
+			delta.RemovedTopics = append(delta.RemovedTopics, name)
+		}
+	}
+
+	subscribers := make(map[string]string)
+	for _, sub := range s.Subscribers {
+		b, _ := json.Marshal(sub)
+		subscribers[sub.GroupID] = string(b)
+		if d.subscribers[sub.GroupID] != subscribers[sub.GroupID] {
+			delta.Subscribers = append(delta.Subscribers, sub)
+		}
+	}
+	for id := range d.subscribers {
+		if _, ok := subscribers[id]; !ok {
+			delta.RemovedSubscribers = append(delta.RemovedSubscribers, id)
+		}
+	}
+
+	b, _ := json.Marshal(s.Brokers)
+	if string(b) != d.brokers {
+		brokers := s.Brokers
+		delta.Brokers = &brokers
+	}
+
+	d.topics, d.subscribers, d.brokers = topics, subscribers, string(b)
+	return delta
+}
+
+// payload 是推送给客户端的一条消息，id 仅对事件有效，用于断线重连
+type payload struct {
+	id   int64
+	kind string
+	data []byte
+}
+
+// serveStream 持续推送快照和事件直到连接断开
+func serveStream(opts streamOptions, done <-chan struct{}, send func(payload) error, heartbeat func() error) {
+	ch := streamHub.Subscribe()
+	defer streamHub.Unsubscribe(ch)
+
+	state := newDeltaState()
+	sendSnapshot := func(metrics *Metrics) error {
+		if !opts.snapshots || metrics == nil {
+			return nil
+		}
+
+		snapshot := NewSnapshot(metrics).Filter(opts.topic, opts.group)
+		var b []byte
+		if opts.delta {
+			b, _ = json.Marshal(state.Diff(snapshot))
+		} else {
+			b, _ = json.Marshal(snapshot)
+		}
+		return send(payload{kind: streamSnapshot, data: b})
+	}
+	sendEvent := func(e Event) error {
+		if !opts.events || !opts.filter.Match(e) {
+			return nil
+		}
+
+		b, _ := json.Marshal(e)
+		opts.filter.After = e.ID
+		return send(payload{id: e.ID, kind: streamEvent, data: b})
+	}
+
+	// 断线重连时补发 Last-Event-ID 之后的事件
+	if opts.lastEventID > 0 {
+		replay := opts.filter
+		replay.After = opts.lastEventID
+		for _, e := range eventLog.Query(replay) {
+			if sendEvent(e) != nil {
+				return
+			}
+		}
+	}
+
+	if sendSnapshot(currentMetrics) != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err = heartbeat()
+		case msg := <-ch:
+			switch msg.kind {
+			case streamSnapshot:
+				err = sendSnapshot(msg.metrics)
+			case streamEvent:
+				err = sendEvent(msg.event)
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// newEventWriter 返回以 Server-Sent Events 逐条发送 JSON 的函数，event 为空时发送心跳
+func newEventWriter(w http.ResponseWriter) (func(event string, v interface{}) error, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	return func(event string, v interface{}) error {
+		var err error
+		if event == "" {
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		} else {
+			b, _ := json.Marshal(v)
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		}
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, true
+}
+
+// handleSSE 处理 /api/stream，通过 Server-Sent Events 推送
+// 支持 topic/group/delta/snapshots/events 以及事件过滤参数
+func handleSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	send := func(p payload) error {
+		if p.id > 0 {
+			if _, err := fmt.Fprintf(w, "id: %d\n", p.id); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", p.kind, p.data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	serveStream(parseStreamOptions(r), r.Context().Done(), send, heartbeat)
+}
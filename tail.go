@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTailRate = 50
+	maxTailRate     = 1000
+)
+
+// TailRequest 描述跟踪的分区、过滤条件和每秒最多输出的消息数，Rate 为 0 表示不限制
+type TailRequest struct {
+	Topic      string
+	Partitions []int32
+	Key        *regexp.Regexp
+	Value      *regexp.Regexp
+	Decode     string
+	Rate       int
+	Last       int64 // 每个分区先输出最后 Last 条
+}
+
+func (req *TailRequest) Validate() error {
+	if req.Topic == "" {
+		return fmt.Errorf("missing topic")
+	}
+	if req.Rate < 0 {
+		return fmt.Errorf("rate must not be negative")
+	}
+	if req.Last < 0 || req.Last > maxRecordCount {
+		return fmt.Errorf("last must be between 0 and %d", maxRecordCount)
+	}
+
+	if req.Decode == "" {
+		req.Decode = decodeUTF8
+	}
+	return validateDecoder(req.Decode)
+}
+
+func (req *TailRequest) Match(msg *sarama.ConsumerMessage) bool {
+	return (req.Key == nil || req.Key.Match(matchable(msg.Key, req.Decode))) &&
+		(req.Value == nil || req.Value.Match(matchableValue(msg, req.Decode)))
+}
+
+// tailTopic 不加入 consumer group 跟踪所有分区的新消息，直到 done 关闭或回调返回错误
+// 超过每秒 Rate 条的消息会被丢弃，每秒调用一次 tick 报告这一秒丢弃的数量
+func tailTopic(client sarama.Client, req TailRequest, done <-chan struct{}, found func(Record) error, tick func(skipped int) error) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	partitions := req.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(req.Topic); err != nil {
+			return err
+		}
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	messages := make(chan *sarama.ConsumerMessage)
+	for _, partition := range partitions {
+		start := sarama.OffsetNewest
+		if req.Last > 0 {
+			newest, err := client.GetOffset(req.Topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return err
+			}
+			oldest, err := client.GetOffset(req.Topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return err
+			}
+			if start = newest - req.Last; start < oldest {
+				start = oldest
+			}
+		}
+
+		pc, err := consumer.ConsumePartition(req.Topic, partition, start)
+		if err != nil {
+			return err
+		}
+
+		go func(pc sarama.PartitionConsumer) {
+			defer pc.Close()
+			for {
+				select {
+				case <-stop:
+					return
+				case err := <-pc.Errors():
+					logrus.Warnf("tail %s/%d error: %v", err.Topic, err.Partition, err.Err)
+				case msg := <-pc.Messages():
+					select {
+					case messages <- msg:
+					case <-stop:
+						return
+					}
+				}
+			}
+		}(pc)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	sent, dropped := 0, 0
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := tick(dropped); err != nil {
+				return nil
+			}
+			sent, dropped = 0, 0
+		case msg := <-messages:
+			if !req.Match(msg) {
+				continue
+			}
+			if req.Rate > 0 && sent >= req.Rate {
+				dropped++
+				continue
+			}
+			sent++
+			if err := found(newRecord(msg, req.Decode)); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// parseTailRequest 解析 /api/tail 的查询参数，HTTP 接口的速率最大为 maxTailRate
+func parseTailRequest(query url.Values) (TailRequest, error) {
+	req := TailRequest{Topic: query.Get("topic"), Decode: query.Get("decode"), Rate: defaultTailRate}
+
+	var err error
+	if v := query.Get("partitions"); v != "" {
+		if req.Partitions, err = parseInt32s(v); err != nil {
+			return req, err
+		}
+	}
+	if v := query.Get("key"); v != "" {
+		if req.Key, err = regexp.Compile(v); err != nil {
+			return req, fmt.Errorf("invalid key pattern: %v", err)
+		}
+	}
+	if v := query.Get("value"); v != "" {
+		if req.Value, err = regexp.Compile(v); err != nil {
+			return req, fmt.Errorf("invalid value pattern: %v", err)
+		}
+	}
+	if v := query.Get("rate"); v != "" {
+		if req.Rate, err = strconv.Atoi(v); err != nil || req.Rate <= 0 || req.Rate > maxTailRate {
+			return req, fmt.Errorf("rate must be between 1 and %d", maxTailRate)
+		}
+	}
+	if v := query.Get("last"); v != "" {
+		if req.Last, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return req, fmt.Errorf("invalid last: %s", v)
+		}
+	}
+	return req, req.Validate()
+}
+
+// handleTail 处理 /api/tail，通过 Server-Sent Events 推送新消息(record)，因限速丢弃消息时推送 skipped
+func handleTail(client sarama.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseTailRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		send, ok := newEventWriter(w)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		if send("", nil) != nil {
+			return
+		}
+
+		// 没有新消息时定时发送心跳，以便尽早发现断开的连接
+		lastSent := time.Now()
+		err = tailTopic(client, req, r.Context().Done(), func(record Record) error {
+			lastSent = time.Now()
+			return send("record", record)
+		}, func(skipped int) error {
+			if skipped > 0 {
+				lastSent = time.Now()
+				return send("skipped", map[string]int{"skipped": skipped})
+			}
+			if time.Since(lastSent) >= heartbeatInterval {
+				lastSent = time.Now()
+				return send("", nil)
+			}
+			return nil
+		})
+		if err != nil {
+			_ = send("error", map[string]string{"error": err.Error()})
+		}
+	}
+}
+
+func runTail(args []string) {
+	fs := newFlagSet("tail")
+	partitions := fs.String("p", "", "comma separated partitions, all partitions by default")
+	key := fs.String("key", "", "only show records whose key matches this regular expression")
+	value := fs.String("value", "", "only show records whose value matches this regular expression")
+	decoderFlags(fs)
+	decode := fs.String("decode", decodeUTF8, "value decoder: utf8, json, hex, base64, avro or protobuf[:type]")
+	rate := fs.Int("rate", defaultTailRate, "show at most this many records per second, 0 for unlimited")
+	last := fs.Int64("n", 0, "show the last n records of every partition first")
+	topic := requireArg(fs, fs.Parse(args), "topic")
+
+	req := TailRequest{Topic: topic, Decode: *decode, Rate: *rate, Last: *last}
+	var err error
+	if *partitions != "" {
+		req.Partitions, err = parseInt32s(*partitions)
+		exitOnError(err)
+	}
+	if *key != "" {
+		req.Key, err = regexp.Compile(*key)
+		exitOnError(err)
+	}
+	if *value != "" {
+		req.Value, err = regexp.Compile(*value)
+		exitOnError(err)
+	}
+	exitOnError(req.Validate())
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	monitor := NewKafkaMonitor()
+	exitOnError(tailTopic(monitor.kafkaClient, req, nil, func(record Record) error {
+		return printRecordLine(os.Stdout, fs.output, record)
+	}, func(skipped int) error {
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "... %d records skipped by rate limit\n", skipped)
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestParseTailRequest(t *testing.T) {
+	tests := []struct {
+		query      string
+		rate       int
+		last       int64
+		partitions int
+		err        string
+	}{
+		{"topic=orders", defaultTailRate, 0, 0, ""},
+		{"topic=orders&partitions=1,2&rate=1000&last=500&key=^k&value=paid", 1000, 500, 2, ""},
+		{"rate=1", 0, 0, 0, "missing topic"},
+		{"topic=orders&rate=0", 0, 0, 0, "rate must be between 1 and 1000"},
+		{"topic=orders&rate=1001", 0, 0, 0, "rate must be between 1 and 1000"},
+		{"topic=orders&rate=x", 0, 0, 0, "rate must be between 1 and 1000"},
+		{"topic=orders&last=501", 0, 0, 0, "last must be between 0 and 500"},
+		{"topic=orders&last=x", 0, 0, 0, "invalid last: x"},
+		{"topic=orders&key=(", 0, 0, 0, "invalid key pattern: error parsing regexp: missing closing ): `(`"},
+		{"topic=orders&value=[", 0, 0, 0, "invalid value pattern: error parsing regexp: missing closing ]: `[`"},
+		{"topic=orders&decode=xml", 0, 0, 0, "unknown decoder \"xml\", expected one of utf8/json/hex/base64/avro/protobuf[:type]"},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		req, err := parseTailRequest(query)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseTailRequest(%q) error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTailRequest(%q): %v", tt.query, err)
+			continue
+		}
+		if req.Rate != tt.rate || req.Last != tt.last || len(req.Partitions) != tt.partitions || req.Decode != decodeUTF8 {
+			t.Errorf("parseTailRequest(%q) = %+v", tt.query, req)
+		}
+	}
+}
+
+func TestTailRequestMatch(t *testing.T) {
+	query, _ := url.ParseQuery("topic=orders&key=^order-&value=paid")
+	req, err := parseTailRequest(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{"order-1", `{"status": "paid"}`, true},
+		{"order-1", `{"status": "new"}`, false},
+		{"refund-1", `{"status": "paid"}`, false},
+	}
+	for _, tt := range tests {
+		msg := &sarama.ConsumerMessage{Key: []byte(tt.key), Value: []byte(tt.value)}
+		if got := req.Match(msg); got != tt.want {
+			t.Errorf("Match(%s, %s) = %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// terminalRestore 保存当前需要恢复的终端状态，logrus.Fatal 退出前会调用它
+var terminalRestore struct {
+	sync.Mutex
+	restore func()
+}
+
+func init() {
+	logrus.RegisterExitHandler(func() {
+		terminalRestore.Lock()
+		defer terminalRestore.Unlock()
+		if terminalRestore.restore != nil {
+			terminalRestore.restore()
+			terminalRestore.restore = nil
+		}
+	})
+}
+
+// holdTerminal 将 restore 登记为进程退出前的恢复函数，返回的函数恢复终端并取消登记
+func holdTerminal(restore func()) func() {
+	terminalRestore.Lock()
+	terminalRestore.restore = restore
+	terminalRestore.Unlock()
+
+	return func() {
+		terminalRestore.Lock()
+		defer terminalRestore.Unlock()
+		if terminalRestore.restore != nil {
+			terminalRestore.restore()
+			terminalRestore.restore = nil
+		}
+	}
+}
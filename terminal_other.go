@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package main
+
+import "errors"
+
+var errNoTerminal = errors.New("terminal control is not supported on this platform")
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	topGroups = "groups"
+	topTopics = "topics"
+
+	sortByLag    = "lag"
+	sortByGrowth = "growth"
+
+	sparklineWidth = 30
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline 用最近 width 个点的 lag 绘制趋势图
+func sparkline(points []LagPoint, width int) string {
+	if len(points) > width {
+		points = points[len(points)-width:]
+	}
+
+	max := int64(0)
+	for _, p := range points {
+		if p.Lag > max {
+			max = p.Lag
+		}
+	}
+
+	runes := make([]rune, 0, len(points))
+	for _, p := range points {
+		idx := 0
+		if max > 0 {
+			idx = int(p.Lag * int64(len(sparkBlocks)-1) / max)
+		}
+		runes = append(runes, sparkBlocks[idx])
+	}
+	return string(runes)
+}
+
+// topRow 是列表中的一行，cells 与表头一一对应
+type topRow struct {
+	name   string
+	lag    int64
+	growth int64
+	cells  []string
+}
+
+// Top 是全屏的 lag 查看界面，每个 tick 重新采集一次
+type Top struct {
+	sync.Mutex
+	monitor *KafkaMonitor
+	metrics *Metrics
+	updated time.Time
+	err     error
+
+	view      string
+	sortBy    string
+	filter    string
+	filtering bool
+	detail    string
+	selected  int
+	scroll    int
+}
+
+func NewTop() *Top {
+	return &Top{monitor: NewKafkaMonitor(), view: topGroups, sortBy: sortByLag}
+}
+
+// refresh 采集期间不持有锁，界面仍然可以响应按键，采集失败时保留上一次的结果
+func (t *Top) refresh() {
+	err := t.monitor.Refresh()
+
+	t.Lock()
+	defer t.Unlock()
+	t.err = err
+	if err == nil {
+		t.metrics = currentMetrics
+		t.updated = time.Now()
+	}
+}
+
+func (t *Top) rows(metrics *Metrics) ([]string, []topRow) {
+	rows := make([]topRow, 0)
+	var headers []string
+
+	if t.view == topGroups {
+		headers = []string{"GROUP", "STATE", "MEMBERS", "LAG", "GROWTH", "HEALTH", "TREND"}
+		for _, g := range groupResources(metrics) {
+			points := lagHistory.Get(g.GroupID)
+			growth := lagGrowth(points)
+			rows = append(rows, topRow{name: g.GroupID, lag: g.Lag, growth: growth, cells: []string{
+				g.GroupID, g.State, fmt.Sprint(len(g.Members)), fmt.Sprint(g.Lag), fmt.Sprintf("%+d", growth), g.Health, sparkline(points, sparklineWidth),
+			}})
+		}
+	} else {
+		headers = []string{"TOPIC", "PARTITIONS", "LOGSIZE", "MSG/S", "MAX_LAG", "GROWTH", "TREND"}
+		for _, topic := range topicResources(metrics) {
+			points := lagHistory.GetTopic(topic.Name)
+			growth := lagGrowth(points)
+			rows = append(rows, topRow{name: topic.Name, lag: topic.Lag, growth: growth, cells: []string{
+				topic.Name, fmt.Sprint(len(topic.Partitions)), fmt.Sprint(topic.LogSize), fmt.Sprintf("%.1f", topic.ProduceRate),
+				fmt.Sprint(topic.Lag), fmt.Sprintf("%+d", growth), sparkline(points, sparklineWidth),
+			}})
+		}
+	}
+
+	filtered := rows[:0]
+	for _, row := range rows {
+		if strings.Contains(row.name, t.filter) {
+			filtered = append(filtered, row)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if t.sortBy == sortByGrowth && filtered[i].growth != filtered[j].growth {
+			return filtered[i].growth > filtered[j].growth
+		}
+		if filtered[i].lag != filtered[j].lag {
+			return filtered[i].lag > filtered[j].lag
+		}
+		return filtered[i].name < filtered[j].name
+	})
+	return headers, filtered
+}
+
+// detailRows 返回 group 或 topic 每个分区的详情，topic 的每个订阅者占一列 lag
+func (t *Top) detailRows(metrics *Metrics) ([]string, [][]string, []LagPoint) {
+	rows := make([][]string, 0)
+
+	if t.view == topGroups {
+		headers := []string{"TOPIC", "PARTITION", "OFFSET", "LOG_END", "LAG"}
+		for _, p := range partitionLags(metrics, t.detail) {
+			rows = append(rows, []string{p.Topic, fmt.Sprint(p.Partition), fmt.Sprint(p.Offset), fmt.Sprint(p.LogEndOffset), fmt.Sprint(p.Lag)})
+		}
+		return headers, rows, lagHistory.Get(t.detail)
+	}
+
+	headers := []string{"PARTITION", "LEADER", "ISR", "LOG_START", "LOG_END"}
+	idx, ok := metrics.Topics.filter[t.detail]
+	if !ok {
+		return headers, rows, nil
+	}
+
+	topic := metrics.Topics.Items[idx]
+	for _, sub := range topic.Subscribers {
+		headers = append(headers, sub.GroupID)
+	}
+	for j, partition := range topic.Partitions {
+		row := []string{fmt.Sprint(partition), "-", "-", "-", "-"}
+		if j < len(topic.Leaders) {
+			row[1] = fmt.Sprint(topic.Leaders[j])
+		}
+		if j < len(topic.ISR) {
+			row[2] = formatInt32s(topic.ISR[j])
+		}
+		if j < len(topic.LogStartOffsets) {
+			row[3] = fmt.Sprint(topic.LogStartOffsets[j])
+		}
+		if j < len(topic.AvailableOffsets) {
+			row[4] = fmt.Sprint(topic.AvailableOffsets[j])
+		}
+		for _, sub := range topic.Subscribers {
+			lag := "-"
+			if j < len(sub.NextOffsets) && sub.NextOffsets[j] != -1 && j < len(topic.AvailableOffsets) {
+				lag = fmt.Sprint(topic.AvailableOffsets[j] - sub.NextOffsets[j])
+			}
+			row = append(row, lag)
+		}
+		rows = append(rows, row)
+	}
+	return headers, rows, lagHistory.GetTopic(t.detail)
+}
+
+func formatTable(headers []string, rows [][]string) []string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
+
+// render 输出一屏内容，选中的行反色显示
+func (t *Top) render(width, height int) string {
+	t.Lock()
+	defer t.Unlock()
+
+	metrics := t.metrics
+	lines := make([]string, 0, height)
+
+	sortBy := t.sortBy
+	if t.detail != "" {
+		sortBy = "-"
+	}
+	filter := t.filter
+	if t.filtering {
+		filter += "_"
+	}
+	lines = append(lines, fmt.Sprintf("kfk top - %s  updated %s  view: %s  sort: %s  filter: %s",
+		brokerAddr, t.updated.Format("15:04:05"), t.view, sortBy, filter))
+	if t.err != nil {
+		lines = append(lines, fmt.Sprintf("refresh failed: %v", t.err))
+	}
+
+	var body []string
+	selectable := 0
+	if metrics != nil && t.detail == "" {
+		headers, rows := t.rows(metrics)
+		cells := make([][]string, 0, len(rows))
+		for _, row := range rows {
+			cells = append(cells, row.cells)
+		}
+		body = formatTable(headers, cells)
+		selectable = len(rows)
+	} else if metrics != nil {
+		headers, rows, points := t.detailRows(metrics)
+		lines = append(lines, fmt.Sprintf("%s %s  lag %s", strings.TrimSuffix(t.view, "s"), t.detail, sparkline(points, width/2)))
+		body = formatTable(headers, rows)
+		selectable = len(rows)
+	}
+
+	if t.selected >= selectable {
+		t.selected = selectable - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+
+	visible := height - len(lines) - 3
+	if visible < 1 {
+		visible = 1
+	}
+	if t.selected < t.scroll {
+		t.scroll = t.selected
+	}
+	if t.selected >= t.scroll+visible {
+		t.scroll = t.selected - visible + 1
+	}
+
+	lines = append(lines, "")
+	if len(body) > 0 {
+		lines = append(lines, body[0])
+		for i := t.scroll; i < t.scroll+visible && i+1 < len(body); i++ {
+			line := truncate(body[i+1], width)
+			if i == t.selected {
+				line = "\x1b[7m" + line + strings.Repeat(" ", width-utf8.RuneCountInString(line)) + "\x1b[0m"
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "tab switch view  s sort  / filter  enter details  esc back  q quit")
+
+	for i := range lines {
+		if !strings.HasPrefix(lines[i], "\x1b") {
+			lines[i] = truncate(lines[i], width)
+		}
+	}
+	return "\x1b[H\x1b[2J" + strings.Join(lines, "\r\n")
+}
+
+// handleKey 处理一次按键，返回 false 表示退出
+func (t *Top) handleKey(key string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.filtering {
+		switch key {
+		case "\r", "\n":
+			t.filtering = false
+		case "\x1b":
+			t.filtering, t.filter = false, ""
+		case "\x7f", "\b":
+			if len(t.filter) > 0 {
+				t.filter = t.filter[:len(t.filter)-1]
+			}
+		default:
+			if len(key) == 1 && key[0] >= 32 {
+				t.filter += key
+			}
+		}
+		t.selected, t.scroll = 0, 0
+		return true
+	}
+
+	switch key {
+	case "q", "\x03":
+		return false
+	case "\x1b[A", "k":
+		t.selected--
+	case "\x1b[B", "j":
+		t.selected++
+	case "\x1b[5~":
+		t.selected -= 10
+	case "\x1b[6~":
+		t.selected += 10
+	case "\t":
+		if t.detail == "" {
+			if t.view == topGroups {
+				t.view = topTopics
+			} else {
+				t.view = topGroups
+			}
+			t.selected, t.scroll = 0, 0
+		}
+	case "s":
+		if t.sortBy == sortByLag {
+			t.sortBy = sortByGrowth
+		} else {
+			t.sortBy = sortByLag
+		}
+	case "/":
+		if t.detail == "" {
+			t.filtering = true
+		}
+	case "\r", "\n":
+		if t.detail == "" && t.metrics != nil {
+			if _, rows := t.rows(t.metrics); t.selected < len(rows) {
+				t.detail = rows[t.selected].name
+				t.selected, t.scroll = 0, 0
+			}
+		}
+	case "\x1b", "\x7f", "\b":
+		t.detail = ""
+		t.selected, t.scroll = 0, 0
+	}
+	return true
+}
+
+func (t *Top) Run() error {
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("kfk top requires a terminal: %v", err)
+	}
+
+	// 使用备用屏幕并隐藏光标，退出后恢复原来的终端内容
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer holdTerminal(func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restore()
+	})()
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 32)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			// 方向键等控制序列通常一次读取完整
+			keys <- string(buf[:n])
+		}
+	}()
+
+	// 上一次采集还没完成时跳过本次 tick
+	refreshed := make(chan struct{}, 1)
+	refreshing := true
+	refresh := func() {
+		t.refresh()
+		refreshed <- struct{}{}
+	}
+	go refresh()
+
+	ticker := time.NewTicker(time.Duration(tickInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		width, height, err := terminalSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Print(t.render(width, height))
+
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handleKey(key) {
+				return nil
+			}
+		case <-refreshed:
+			refreshing = false
+		case <-ticker.C:
+			if !refreshing {
+				refreshing = true
+				go refresh()
+			}
+		}
+	}
+}
+
+func runTop(args []string) {
+	fs := newFlagSet("top")
+	fs.Parse(args)
+
+	// 采集过程中的警告会打乱界面
+	logrus.SetLevel(logrus.ErrorLevel)
+	exitOnError(NewTop().Run())
+}
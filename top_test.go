@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lagPoints(lags ...int64) []LagPoint {
+	points := make([]LagPoint, 0, len(lags))
+	for i, lag := range lags {
+		points = append(points, LagPoint{Timestamp: int64(i), Lag: lag})
+	}
+	return points
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		points []LagPoint
+		width  int
+		want   string
+	}{
+		{nil, 10, ""},
+		{lagPoints(0, 0, 0), 10, "▁▁▁"},
+		{lagPoints(0, 7, 14), 10, "▁▄█"},
+		{lagPoints(0, 1, 2, 3, 4, 5, 6, 7), 8, "▁▂▃▄▅▆▇█"},
+		{lagPoints(100, 0, 10, 20), 2, "▄█"},
+	}
+
+	for _, tt := range tests {
+		if got := sparkline(tt.points, tt.width); got != tt.want {
+			t.Errorf("sparkline(%v, %d) = %q, want %q", tt.points, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  string
+	}{
+		{"orders", 10, "orders"},
+		{"orders", 6, "orders"},
+		{"orders", 3, "ord"},
+		{"▁▂▃▄▅", 2, "▁▂"},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.line, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTable(t *testing.T) {
+	got := formatTable([]string{"GROUP", "LAG"}, [][]string{{"billing", "60"}, {"a", "0"}})
+	want := []string{"GROUP    LAG", "billing  60", "a        0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formatTable = %q, want %q", got, want)
+	}
+}
+
+func TestTopRows(t *testing.T) {
+	metrics := newTestMetrics()
+	tests := []struct {
+		view   string
+		filter string
+		want   []string
+	}{
+		{topGroups, "", []string{"billing", "audit"}},
+		{topGroups, "aud", []string{"audit"}},
+		{topTopics, "", []string{"orders", "payments"}},
+		{topTopics, "none", []string{}},
+	}
+
+	for _, tt := range tests {
+		top := &Top{view: tt.view, sortBy: sortByLag, filter: tt.filter}
+		_, rows := top.rows(metrics)
+		names := make([]string, 0, len(rows))
+		for _, row := range rows {
+			names = append(names, row.name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("rows(%s, %q) = %v, want %v", tt.view, tt.filter, names, tt.want)
+		}
+	}
+}
+
+func TestTopHandleKey(t *testing.T) {
+	top := &Top{metrics: newTestMetrics(), view: topGroups, sortBy: sortByLag}
+
+	for _, key := range []string{"/", "a", "u", "d", "\r", "\r"} {
+		if !top.handleKey(key) {
+			t.Fatalf("handleKey(%q) quit", key)
+		}
+	}
+	if top.filter != "aud" || top.detail != "audit" {
+		t.Errorf("filter = %q, detail = %q, want aud and audit", top.filter, top.detail)
+	}
+
+	top.handleKey("\x1b")
+	top.handleKey("\t")
+	top.handleKey("s")
+	if top.detail != "" || top.view != topTopics || top.sortBy != sortByGrowth {
+		t.Errorf("detail = %q, view = %s, sort = %s", top.detail, top.view, top.sortBy)
+	}
+	if top.handleKey("q") {
+		t.Error("q did not quit")
+	}
+}
+
+func TestTopRenderRefreshError(t *testing.T) {
+	top := &Top{metrics: newTestMetrics(), view: topGroups, sortBy: sortByLag, err: errors.New("could not find controller")}
+	screen := top.render(120, 20)
+	if !strings.Contains(screen, "refresh failed: could not find controller") {
+		t.Error("render does not show the refresh error")
+	}
+	if !strings.Contains(screen, "billing") {
+		t.Error("render does not keep the last snapshot")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	changeConfig            = "config"
+	changePartitions        = "partitions"
+	changeReplicationFactor = "replication_factor"
+
+	maxTopicChanges = 100
+)
+
+type TopicChange struct {
+	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+	Topic     string `json:"topic" bson:"topic"`
+	Type      string `json:"type" bson:"type"`
+	Name      string `json:"name" bson:"name"`
+	OldValue  string `json:"old_value" bson:"old_value"`
+	NewValue  string `json:"new_value" bson:"new_value"`
+}
+
+// TopicChanges 在内存中保存每个 topic 最近的 maxTopicChanges 条变更记录
+type TopicChanges struct {
+	sync.RWMutex
+	items map[string][]TopicChange
+}
+
+func (t *TopicChanges) Add(changes ...TopicChange) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, change := range changes {
+		items := append(t.items[change.Topic], change)
+		if len(items) > maxTopicChanges {
+			items = items[len(items)-maxTopicChanges:]
+		}
+		t.items[change.Topic] = items
+	}
+}
+
+func (t *TopicChanges) Get(topic string) []TopicChange {
+	t.RLock()
+	defer t.RUnlock()
+
+	return append([]TopicChange{}, t.items[topic]...)
+}
+
+var topicChanges = &TopicChanges{items: make(map[string][]TopicChange)}
+
+// diffTopic 对比同一个 topic 前后两次采集的分区数、副本数，configs 为 true 时同时对比配置
+func diffTopic(last, current *Topic, timestamp int64, configs bool) []TopicChange {
+	changes := make([]TopicChange, 0)
+	record := func(typ, name, oldValue, newValue string) {
+		changes = append(changes, TopicChange{
+			Timestamp: timestamp,
+			Topic:     current.Name,
+			Type:      typ,
+			Name:      name,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+		})
+	}
+
+	if len(last.Partitions) != len(current.Partitions) {
+		record(changePartitions, changePartitions,
+			strconv.Itoa(len(last.Partitions)), strconv.Itoa(len(current.Partitions)))
+	}
+
+	if last.ReplicationFactor != current.ReplicationFactor && last.ReplicationFactor > 0 && current.ReplicationFactor > 0 {
+		record(changeReplicationFactor, changeReplicationFactor,
+			strconv.Itoa(last.ReplicationFactor), strconv.Itoa(current.ReplicationFactor))
+	}
+
+	// 配置获取失败时为空，此时无法判断是否发生了变化
+	if !configs || last.Configs == nil || current.Configs == nil {
+		return changes
+	}
+
+	lastValues := configValues(last.Configs)
+	currentValues := configValues(current.Configs)
+
+	names := make([]string, 0)
+	for name := range lastValues {
+		names = append(names, name)
+	}
+	for name := range currentValues {
+		if _, ok := lastValues[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if lastValues[name] != currentValues[name] {
+			record(changeConfig, name, lastValues[name], currentValues[name])
+		}
+	}
+	return changes
+}
+
+func configValues(entries []ConfigEntry) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.Sensitive {
+			values[entry.Name] = entry.Value
+		}
+	}
+	return values
+}
+
+// detectTopicChanges
+// 新建的 topic 没有可以对比的历史状态，不记录变更；配置只在本次重新获取了的时候对比
+func (m *KafkaMonitor) detectTopicChanges() {
+	if m.lastMetrics == nil {
+		return
+	}
+
+	changes := make([]TopicChange, 0)
+	for _, topic := range m.metrics.Topics.Items {
+		idx, ok := m.lastMetrics.Topics.filter[topic.Name]
+		if !ok {
+			continue
+		}
+		changes = append(changes, diffTopic(m.lastMetrics.Topics.Items[idx], topic, m.metrics.Timestamp, m.configsDescribed[topic.Name])...)
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		logrus.Infof("topic %s %s %s changed: %q -> %q",
+			change.Topic, change.Type, change.Name, change.OldValue, change.NewValue)
+	}
+
+	topicChanges.Add(changes...)
+	if IsUseMongo() {
+		checkErr(mgoClient.SaveTopicChanges(changes))
+	}
+}
+
+// handleTopicChanges 处理 /api/topics/{name}/changes
+// 使用 MongoDB 时从数据库读取完整的历史记录，否则返回内存中最近的记录
+func handleTopicChanges(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/topics/")
+	if !strings.HasSuffix(path, "/changes") {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimSuffix(path, "/changes")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var changes []TopicChange
+	if IsUseMongo() {
+		var err error
+		if changes, err = mgoClient.FindTopicChanges(name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		changes = topicChanges.Get(name)
+	}
+
+	b, _ := json.Marshal(changes)
+	_, _ = fmt.Fprint(w, string(b))
+}
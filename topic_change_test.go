@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffTopic(t *testing.T) {
+	configs := func(retention string) []ConfigEntry {
+		return []ConfigEntry{
+			{Name: "retention.ms", Value: retention, Override: true},
+			{Name: "sasl.jaas.config", Value: retention, Sensitive: true},
+		}
+	}
+	topic := func(partitions, replication int, entries []ConfigEntry) *Topic {
+		return &Topic{Name: "orders", Partitions: make([]int32, partitions), ReplicationFactor: replication, Configs: entries}
+	}
+
+	tests := []struct {
+		name    string
+		last    *Topic
+		current *Topic
+		configs bool
+		want    []TopicChange
+	}{
+		{"unchanged", topic(3, 2, configs("1000")), topic(3, 2, configs("1000")), true, []TopicChange{}},
+		{"partitions and replication", topic(3, 2, nil), topic(6, 3, nil), true, []TopicChange{
+			{Timestamp: 1, Topic: "orders", Type: changePartitions, Name: changePartitions, OldValue: "3", NewValue: "6"},
+			{Timestamp: 1, Topic: "orders", Type: changeReplicationFactor, Name: changeReplicationFactor, OldValue: "2", NewValue: "3"},
+		}},
+		{"unknown replication", topic(3, 2, nil), topic(3, 0, nil), true, []TopicChange{}},
+		{"config", topic(3, 2, configs("1000")), topic(3, 2, configs("2000")), true, []TopicChange{
+			{Timestamp: 1, Topic: "orders", Type: changeConfig, Name: "retention.ms", OldValue: "1000", NewValue: "2000"},
+		}},
+		{"configs not described", topic(3, 2, configs("1000")), topic(3, 2, configs("2000")), false, []TopicChange{}},
+		{"configs failed", topic(3, 2, configs("1000")), topic(3, 2, nil), true, []TopicChange{}},
+	}
+
+	for _, tt := range tests {
+		got := diffTopic(tt.last, tt.current, 1, tt.configs)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
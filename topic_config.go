@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	configRetentionMs    = "retention.ms"
+	configRetentionBytes = "retention.bytes"
+)
+
+type ConfigEntry struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Override  bool   `json:"override"`
+	ReadOnly  bool   `json:"read_only"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+func newConfigEntry(resourceType sarama.ConfigResourceType, entry *sarama.ConfigEntry) ConfigEntry {
+	override := !entry.Default
+	if entry.Source != sarama.SourceUnknown {
+		switch resourceType {
+		case sarama.TopicResource:
+			override = entry.Source == sarama.SourceTopic
+		case sarama.BrokerResource:
+			override = entry.Source == sarama.SourceDynamicBroker || entry.Source == sarama.SourceStaticBroker
+		}
+	}
+
+	return ConfigEntry{
+		Name:      entry.Name,
+		Value:     entry.Value,
+		Source:    entry.Source.String(),
+		Override:  override,
+		ReadOnly:  entry.ReadOnly,
+		Sensitive: entry.Sensitive,
+	}
+}
+
+// describeConfigs 获取指定类型资源的全部配置项
+// broker 类型的资源只能由对应的 broker 自身返回
+func describeConfigs(broker *sarama.Broker, resourceType sarama.ConfigResourceType, names []string) (map[string][]ConfigEntry, error) {
+	resources := make([]*sarama.ConfigResource, 0, len(names))
+	for _, name := range names {
+		resources = append(resources, &sarama.ConfigResource{Type: resourceType, Name: name})
+	}
+
+	// version 1 返回每个配置项的来源，用于区分默认值和覆盖值
+	resp, err := broker.DescribeConfigs(&sarama.DescribeConfigsRequest{Version: 1, Resources: resources})
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string][]ConfigEntry)
+	for _, res := range resp.Resources {
+		if res.ErrorMsg != "" {
+			logrus.Warnf("describe configs of %s error: %s", res.Name, res.ErrorMsg)
+			continue
+		}
+
+		entries := make([]ConfigEntry, 0, len(res.Configs))
+		for _, entry := range res.Configs {
+			entries = append(entries, newConfigEntry(resourceType, entry))
+		}
+		configs[res.Name] = entries
+	}
+	return configs, nil
+}
+
+func (m *KafkaMonitor) describeTopicConfigs(names []string) (map[string][]ConfigEntry, error) {
+	controller, err := m.kafkaClient.Controller()
+	if err != nil {
+		return nil, err
+	}
+	return describeConfigs(controller, sarama.TopicResource, names)
+}
+
+// refreshTopicConfigs
+// topic 配置变化频率远低于 offset，按 CONFIG_INTERVAL 间隔刷新，期间新增的 topic 会被立即补齐。
+// 获取失败的 topic 沿用上一次的配置，避免被误认为配置发生了变化；configsDescribed 记录本次实际获取了配置的 topic
+func (m *KafkaMonitor) refreshTopicConfigs() {
+	defer m.refreshSubscriber()
+
+	expired := time.Since(m.configsUpdated) >= time.Duration(configInterval)*time.Second
+
+	names := make([]string, 0)
+	for _, topic := range m.metrics.Topics.Items {
+		if _, ok := m.topicConfigs[topic.Name]; expired || !ok {
+			names = append(names, topic.Name)
+		}
+	}
+
+	m.configsDescribed = make(map[string]bool)
+	if len(names) > 0 {
+		configs, err := m.describeTopicConfigs(names)
+		if err != nil {
+			logrus.Warnf("describe topic configs error: %v", err)
+		} else if expired {
+			m.configsUpdated = time.Now()
+		}
+
+		topicConfigs := make(map[string][]ConfigEntry, len(m.metrics.Topics.Items))
+		for _, topic := range m.metrics.Topics.Items {
+			if entries, ok := configs[topic.Name]; ok {
+				topicConfigs[topic.Name] = entries
+				m.configsDescribed[topic.Name] = true
+			} else if entries, ok := m.topicConfigs[topic.Name]; ok {
+				topicConfigs[topic.Name] = entries
+			}
+		}
+		m.topicConfigs = topicConfigs
+	}
+
+	for _, topic := range m.metrics.Topics.Items {
+		topic.Configs = m.topicConfigs[topic.Name]
+		for _, entry := range topic.Configs {
+			value, err := strconv.ParseInt(entry.Value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch entry.Name {
+			case configRetentionMs:
+				topic.RetentionMs = value
+			case configRetentionBytes:
+				topic.RetentionBytes = value
+			}
+		}
+	}
+}
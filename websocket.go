@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsMaxPayload = 1 << 20
+)
+
+// wsConn 是一个只实现了推送所需功能的 WebSocket (RFC 6455) 服务端连接
+type wsConn struct {
+	sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// upgradeWebSocket 完成握手，握手请求不合法时直接返回 400
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") || key == "" {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return nil, errors.New("hijacking unsupported")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	_, _ = io.WriteString(h, key+wsGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (c *wsConn) WriteFrame(opcode byte, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(data); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// ReadFrame 读取一个客户端发送的帧，客户端的帧必须带掩码
+func (c *wsConn) ReadFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > wsMaxPayload {
+		return 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, data); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return opcode, data, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// handleWebSocket 处理 /api/ws，参数与 /api/stream 相同
+// 浏览器无法自定义 WebSocket 请求头，断线重连时使用 last_event_id 参数
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	opts := parseStreamOptions(r)
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		logrus.Warnf("websocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 客户端不会发送业务数据，只需要处理 ping 和 close
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opcode, data, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsOpPing:
+				_ = conn.WriteFrame(wsOpPong, data)
+			case wsOpClose:
+				_ = conn.WriteFrame(wsOpClose, nil)
+				return
+			}
+		}
+	}()
+
+	send := func(p payload) error {
+		b, _ := json.Marshal(struct {
+			ID   int64           `json:"id,omitempty"`
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}{p.id, p.kind, p.data})
+		return conn.WriteFrame(wsOpText, b)
+	}
+	heartbeat := func() error {
+		return conn.WriteFrame(wsOpPing, nil)
+	}
+
+	serveStream(opts, done, send, heartbeat)
+}
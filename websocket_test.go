@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBufferConn(in []byte) (*wsConn, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	rw := bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(in)), bufio.NewWriter(out))
+	return &wsConn{rw: rw}, out
+}
+
+// maskFrame 按客户端的格式构造一个带掩码的帧
+func maskFrame(opcode byte, data []byte) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode}
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask...)
+	for i, b := range data {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebSocketWriteFrame(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0xFFFF, []byte{0x81, 126, 0xFF, 0xFF}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		c, out := newBufferConn(nil)
+		data := bytes.Repeat([]byte{'x'}, tt.size)
+		if err := c.WriteFrame(wsOpText, data); err != nil {
+			t.Fatalf("WriteFrame(%d): %v", tt.size, err)
+		}
+		if got := out.Bytes(); !bytes.Equal(got[:len(tt.header)], tt.header) || !bytes.Equal(got[len(tt.header):], data) {
+			t.Errorf("WriteFrame(%d) header = %v, want %v", tt.size, got[:len(tt.header)], tt.header)
+		}
+	}
+}
+
+func TestWebSocketReadFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  []byte
+		opcode byte
+		data   string
+		err    bool
+	}{
+		{"masked text", maskFrame(wsOpText, []byte("hello")), wsOpText, "hello", false},
+		{"unmasked ping", []byte{0x89, 2, 'h', 'i'}, wsOpPing, "hi", false},
+		{"close", maskFrame(wsOpClose, nil), wsOpClose, "", false},
+		{"16 bit length", maskFrame(wsOpText, bytes.Repeat([]byte{'a'}, 300)), wsOpText, strings.Repeat("a", 300), false},
+		{"64 bit length", maskFrame(wsOpText, bytes.Repeat([]byte{'b'}, 70000)), wsOpText, strings.Repeat("b", 70000), false},
+		{"too large", []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 0, 0x20, 0, 0}, 0, "", true},
+		{"truncated payload", []byte{0x81, 5, 'a'}, 0, "", true},
+		{"truncated header", []byte{0x81}, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		c, _ := newBufferConn(tt.frame)
+		opcode, data, err := c.ReadFrame()
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if opcode != tt.opcode || string(data) != tt.data {
+			t.Errorf("%s: got opcode %d and %d bytes, want opcode %d and %d bytes", tt.name, opcode, len(data), tt.opcode, len(tt.data))
+		}
+	}
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if opcode, data, err := c.ReadFrame(); err == nil {
+			_ = c.WriteFrame(opcode, data)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET status = %d, want 400", resp.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 使用 RFC 6455 1.3 节的示例 key
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", accept)
+	}
+
+	_, _ = conn.Write(maskFrame(wsOpText, []byte("echo")))
+	c := &wsConn{conn: conn, rw: bufio.NewReadWriter(r, bufio.NewWriter(conn))}
+	if opcode, data, err := c.ReadFrame(); err != nil || opcode != wsOpText || string(data) != "echo" {
+		t.Errorf("echo = %d %q %v", opcode, data, err)
+	}
+}